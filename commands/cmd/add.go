@@ -19,6 +19,26 @@ import (
 	"fmt"
 	"github.com/spf13/cobra"
 	"if0/environments"
+	"os"
+	"strings"
+)
+
+var (
+	// answers flags: answer the questions of if0 add
+	provider      string
+	tokenFromEnv  string
+	secretFromEnv string
+	domain        string
+	nodes         string
+	answersFile   string
+	// template flag: template the environment is created from
+	templateName string
+	// input flag: answers the inputs of the template
+	inputs []string
+	// yes flag: runs the post-create hooks of templates from outside ~/.if0/templates without confirmation
+	runHooks bool
+	// non-interactive flag: fails on unanswered questions instead of reading the console
+	nonInteractive bool
 )
 
 // addCmd represents the add command
@@ -32,7 +52,16 @@ If GL_TOKEN is set in ~/.if0/if0.env, a private GitLab project is created,
 and the local environment is synced with it. In this case a repo url is not needed.
 
 If a repo url is provided, the repository present at the remote repository url is cloned locally.
-If there is no GL_TOKEN and no repo url, only a local copy is created at ~/.if0/.environments`,
+If there is no GL_TOKEN and no repo url, only a local copy is created at ~/.if0/.environments
+
+The questions about a new environment can be answered with flags or an answers file (YAML or KEY=VALUE),
+with the keys name, provider, token, secret, nodes and domain. Example:
+if0 add test-env --provider hcloud --token-from-env HCLOUD_TOKEN --domain example.com --non-interactive
+
+--template creates the environment from a template directory, a git repository or a template
+in ~/.if0/templates, e.g. if0 add test-env --template customer-k3s --input customer=acme
+The post-create hooks of templates from outside ~/.if0/templates are shown and only run after a confirmation,
+--yes runs them without it.`,
 
 	Run: func(cmd *cobra.Command, args []string) {
 		//if len(args) < 1 {
@@ -40,7 +69,12 @@ If there is no GL_TOKEN and no repo url, only a local copy is created at ~/.if0/
 		//	fmt.Println("example command: if0 add repo-name [git@gitlab.com:repo-name.git]")
 		//	return
 		//}
-		err := environments.AddEnv(args)
+		prompter, err := addPrompter()
+		if err != nil {
+			fmt.Println("Error: Reading answers - ", err)
+			return
+		}
+		err = environments.AddEnv(args, environments.AddOptions{Prompter: prompter, Template: templateName})
 		if err != nil {
 			fmt.Println("Error: Adding repo - ", err)
 			return
@@ -48,6 +82,60 @@ If there is no GL_TOKEN and no repo url, only a local copy is created at ~/.if0/
 	},
 }
 
+// addPrompter answers the questions of if0 add from the answers file and the flags, the flags take precedence
+func addPrompter() (environments.Prompter, error) {
+	answers := make(map[string]string)
+	if answersFile != "" {
+		var err error
+		answers, err = environments.ReadAnswersFile(answersFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, input := range inputs {
+		kv := strings.SplitN(input, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, fmt.Errorf("invalid input %q, expected name=value", input)
+		}
+		answers[kv[0]] = kv[1]
+	}
+	if runHooks {
+		answers[environments.HooksAnswer] = "y"
+	}
+	for key, val := range map[string]string{"provider": provider, "domain": domain, "nodes": nodes} {
+		if val != "" {
+			answers[key] = val
+		}
+	}
+	// credentials are read from the environment, so they do not end up in the shell history
+	for key, envVar := range map[string]string{"token": tokenFromEnv, "secret": secretFromEnv} {
+		if envVar == "" {
+			continue
+		}
+		val := os.Getenv(envVar)
+		if val == "" {
+			return nil, fmt.Errorf("%s is not set", envVar)
+		}
+		answers[key] = val
+	}
+	if nonInteractive {
+		return environments.NewAnswersPrompter(answers, nil), nil
+	}
+	return environments.NewAnswersPrompter(answers, environments.NewConsolePrompter(os.Stdin, os.Stdout)), nil
+}
+
 func init() {
 	rootCmd.AddCommand(addCmd)
+	addCmd.Flags().StringVar(&provider, "provider", "",
+		"cloud provider: "+strings.Join(environments.ProviderNames(), ", ")+" or none")
+	addCmd.Flags().StringVar(&tokenFromEnv, "token-from-env", "", "environment variable holding the token of the provider")
+	addCmd.Flags().StringVar(&secretFromEnv, "secret-from-env", "", "environment variable holding the secret key of the provider, e.g. AWS_SECRET_ACCESS_KEY")
+	addCmd.Flags().StringVar(&domain, "domain", "", "custom domain of the environment")
+	addCmd.Flags().StringVar(&nodes, "nodes", "", "IPs of the nodes, with --provider none")
+	addCmd.Flags().StringVar(&answersFile, "answers", "", "answers file, YAML or KEY=VALUE pairs")
+	addCmd.Flags().StringVar(&templateName, "template", environments.DefaultTemplate,
+		"template directory, git repository or name of a template in ~/.if0/templates")
+	addCmd.Flags().StringArrayVar(&inputs, "input", nil, "answers an input of the template, name=value")
+	addCmd.Flags().BoolVarP(&runHooks, "yes", "y", false, "runs the post-create hooks of the template without confirmation")
+	addCmd.Flags().BoolVar(&nonInteractive, "non-interactive", false, "fails listing the missing answers instead of asking for them")
 }
@@ -0,0 +1,66 @@
+/*
+Copyright © 2020 NAME HERE <EMAIL ADDRESS>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"if0/environments"
+)
+
+var (
+	// assetsCmd groups the commands to manage the assets of new environments
+	assetsCmd = &cobra.Command{
+		Use:   "assets",
+		Short: "manages the assets new environments are created with",
+		Long: `The .gitlab-ci.yml of new environments is built into if0, so 'if0 add' works offline.
+The logo is not built in yet, new environments get it once 'if0 assets refresh' downloaded it.
+Newer versions are downloaded to the asset cache in ~/.if0/assets with 'if0 assets refresh',
+files placed there by hand are used as well.`,
+	}
+
+	assetsRefreshCmd = &cobra.Command{
+		Use:   "refresh",
+		Short: "downloads the latest assets to the asset cache",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			refreshed, err := environments.RefreshAssets()
+			for _, name := range refreshed {
+				fmt.Println("Refreshed", name)
+			}
+			if err != nil {
+				fmt.Println("Error: Refreshing assets - ", err)
+			}
+		},
+	}
+
+	assetsListCmd = &cobra.Command{
+		Use:   "list",
+		Short: "lists the assets and whether they are read from the cache or built in",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			for _, a := range environments.Assets() {
+				fmt.Printf("%s\t%s\t%s\n", a.Name, a.Source, a.URL)
+			}
+		},
+	}
+)
+
+func init() {
+	rootCmd.AddCommand(assetsCmd)
+	assetsCmd.AddCommand(assetsRefreshCmd)
+	assetsCmd.AddCommand(assetsListCmd)
+}
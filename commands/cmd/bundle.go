@@ -0,0 +1,124 @@
+/*
+Copyright © 2020 NAME HERE <EMAIL ADDRESS>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"golang.org/x/crypto/ssh/terminal"
+	"if0/environments"
+	"os"
+	"path"
+	"syscall"
+)
+
+// bundlePassphraseKey holds the passphrase of encrypted SSH keys in bundles, it is asked for otherwise
+const bundlePassphraseKey = "IF0_BUNDLE_PASSPHRASE"
+
+var (
+	// output flag: file the bundle is written to
+	output string
+	// ssh flag: include, exclude or encrypt the SSH keys of a bundle
+	bundleSSH string
+	// name flag: name of the imported environment
+	importName string
+	// signer flag: public key of a signer to trust
+	signer string
+
+	envExportCmd = &cobra.Command{
+		Use:   "export env-name",
+		Short: "writes an environment to a signed bundle",
+		Long: `Example: if0 env export gitlab.com/group/env-1 -o env-1.tar.zst
+The bundle holds the *.env files, SSH keys, terraform state and plans of the environment and a manifest
+with their checksums, signed with your key in ~/.if0/keys/signing.key. Bundles ending with .zst are
+compressed with zstd, all others with gzip. --ssh encrypt encrypts the SSH keys with a passphrase
+(` + bundlePassphraseKey + ` or a prompt), --ssh exclude leaves them out.`,
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			name, err := environments.ResolveEnvName(args[0])
+			if err != nil {
+				fmt.Println("Error: Exporting environment - ", err)
+				return
+			}
+			file := output
+			if file == "" {
+				file = path.Base(name) + ".tar.gz"
+			}
+			opts := environments.ExportOptions{SSH: bundleSSH}
+			if bundleSSH == environments.SSHEncrypt {
+				opts.Passphrase, err = bundlePassphrase()
+				if err != nil {
+					fmt.Println("Error: Reading passphrase - ", err)
+					return
+				}
+			}
+			manifest, err := environments.ExportEnv(name, file, opts)
+			if err != nil {
+				fmt.Println("Error: Exporting environment - ", err)
+				return
+			}
+			fmt.Printf("Exported environment %s with %d files to %s\n", name, len(manifest.Files), file)
+			fmt.Println("Signed by", manifest.Signer)
+		},
+	}
+
+	envImportCmd = &cobra.Command{
+		Use:   "import bundle",
+		Short: "creates an environment from a bundle",
+		Long: `Example: if0 env import env-1.tar.zst
+The signature and checksums of the bundle are verified. Bundles are imported if they are signed by you
+or a key in ~/.if0/keys/trusted-signers, --signer adds the key of the sender to the trusted signers.
+The environment is created below the location of its repository, --name overrides it.`,
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			envDir, err := environments.ImportEnv(args[0], environments.ImportOptions{
+				Name:       importName,
+				Signer:     signer,
+				Passphrase: bundlePassphrase,
+			})
+			if err != nil {
+				fmt.Println("Error: Importing environment - ", err)
+				return
+			}
+			fmt.Println("Imported environment", envDir)
+		},
+	}
+)
+
+// bundlePassphrase returns the passphrase of the SSH keys of a bundle
+func bundlePassphrase() ([]byte, error) {
+	return readPassphrase(bundlePassphraseKey, "Passphrase of the SSH keys: ")
+}
+
+// readPassphrase returns the value of the environment variable key, or asks for it without echo
+func readPassphrase(key, prompt string) ([]byte, error) {
+	if passphrase := os.Getenv(key); passphrase != "" {
+		return []byte(passphrase), nil
+	}
+	fmt.Print(prompt)
+	passphrase, err := terminal.ReadPassword(int(syscall.Stdin))
+	fmt.Println()
+	return passphrase, err
+}
+
+func init() {
+	envCmd.AddCommand(envExportCmd)
+	envCmd.AddCommand(envImportCmd)
+	envExportCmd.Flags().StringVarP(&output, "output", "o", "", "bundle file (default <env>.tar.gz)")
+	envExportCmd.Flags().StringVar(&bundleSSH, "ssh", environments.SSHInclude, "include, exclude or encrypt the SSH keys")
+	envImportCmd.Flags().StringVar(&importName, "name", "", "name of the imported environment")
+	envImportCmd.Flags().StringVar(&signer, "signer", "", "trusts the public key of the sender")
+}
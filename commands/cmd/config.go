@@ -24,10 +24,13 @@ import (
 )
 
 var (
-	// zero flag: used to distinguish between if0 and zero-cluster configuration files.
-	// default: false, for if0
-	// set to true for zero-cluster configurations when the command is called with -z or --zero flag
-	//zero bool
+	// env flag: scopes get/set/unset/list/merge to a configuration file of an environment
+	// instead of ~/.if0/if0.env. Example: if0 config get ZERO_BASE_DOMAIN --env gitlab.com/group/env
+	envName string
+
+	// file flag: configuration file inside the environment selected with --env.
+	// default: zero.env
+	envFile string
 
 	// add flag: used to add new or update configuration files.
 	add string
@@ -47,7 +50,7 @@ var (
 	// src flag: used to input the configuration file that needs to be merged
 	src string
 
-	// dst file: destination configuration file (in .environments dir, or if0.env file) to be merged with
+	// dst file: destination configuration file to be merged with. Ignored if --env is set.
 	dst string
 
 	// set flag: used to set environment variables.
@@ -66,10 +69,20 @@ var (
 				loadConfigFromFlags(set)
 			}
 
-			// if --merge is true, the file in --src is merged with file in --dst.
+			// if --merge is true, the file in --src is merged with file in --dst,
+			// or with the environment file selected with --env/--file.
 			// --dst is optional
 			if merge {
-				err := config.MergeConfigFiles(src, dst)
+				mergeDst := dst
+				if envName != "" {
+					target, err := config.GetConfigFile(envName, envFile)
+					if err != nil {
+						fmt.Println("Error: Merging config files - ", err)
+						return
+					}
+					mergeDst = target
+				}
+				err := config.MergeConfigFiles(src, mergeDst)
 				if err != nil {
 					fmt.Println("Error: Merging config files - ", err)
 					return
@@ -83,8 +96,12 @@ var (
 			}
 
 			// printing current running configuration to the stdout.
-			fmt.Println("Current Running Configuration")
-			config.PrintCurrentRunningConfig()
+			if envName != "" {
+				printScopedConfig()
+			} else {
+				fmt.Println("Current Running Configuration")
+				config.PrintCurrentRunningConfig()
+			}
 
 			// automatic garbage collection
 			config.GarbageCollection()
@@ -103,15 +120,108 @@ var (
 			}
 		},
 	}
+
+	// configGetCmd prints the value of a single configuration key
+	configGetCmd = &cobra.Command{
+		Use:   "get KEY",
+		Short: "prints the value of a configuration key",
+		Long: `Example: if0 config get IF0_REGISTRY_URL
+         if0 config get ZERO_BASE_DOMAIN --env gitlab.com/group/env --file zero.env`,
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			target, err := config.GetConfigFile(envName, envFile)
+			if err != nil {
+				fmt.Println("Error: Reading configuration - ", err)
+				return
+			}
+			val, err := config.GetConfigValue(target, args[0])
+			if err != nil {
+				fmt.Println("Error: Reading configuration - ", err)
+				return
+			}
+			fmt.Println(val)
+		},
+	}
+
+	// configUnsetCmd removes a configuration key
+	configUnsetCmd = &cobra.Command{
+		Use:   "unset KEY",
+		Short: "removes a configuration key",
+		Long: `Example: if0 config unset GL_TOKEN
+         if0 config unset HCLOUD_TOKEN --env gitlab.com/group/env --file dash1.env`,
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			target, err := config.GetConfigFile(envName, envFile)
+			if err != nil {
+				fmt.Println("Error: Removing configuration - ", err)
+				return
+			}
+			err = config.UnsetConfigValue(target, args[0])
+			if err != nil {
+				fmt.Println("Error: Removing configuration - ", err)
+				return
+			}
+			if envName == "" {
+				_ = os.Unsetenv(strings.ToUpper(strings.TrimSpace(args[0])))
+			}
+		},
+	}
+
+	// configListCmd prints all keys of a configuration file
+	configListCmd = &cobra.Command{
+		Use:   "list",
+		Short: "lists the configuration of if0.env or of an environment file",
+		Long: `Example: if0 config list
+         if0 config list --env gitlab.com/group/env --file dash1.env`,
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			printScopedConfig()
+		},
+	}
 )
 
 // loadConfigFromFlags is called when the if0 command is called with --set flag
 // it is used to set config variables for that particular run
 // example: `if0 config --set var1=val1` sets var1 with value val1
+// with --env, the variables are written to the selected environment file instead of if0.env
 func loadConfigFromFlags(configParams []string) {
+	var target string
+	if envName != "" {
+		var err error
+		target, err = config.GetConfigFile(envName, envFile)
+		if err != nil {
+			fmt.Println("Error: Setting configuration - ", err)
+			return
+		}
+	}
 	for _, param := range configParams {
-		set := strings.Split(param, "=")
-		config.SetEnvVariable(set[0], set[1])
+		set := strings.SplitN(param, "=", 2)
+		if len(set) != 2 {
+			fmt.Printf("Error: Invalid key-value pair %s, expected KEY=VALUE\n", param)
+			continue
+		}
+		if target == "" {
+			config.SetEnvVariable(set[0], set[1])
+			continue
+		}
+		err := config.SetConfigValue(target, set[0], strings.TrimSpace(set[1]))
+		if err != nil {
+			fmt.Printf("Error: Setting %s in %s - %s\n", set[0], target, err)
+		}
+	}
+}
+
+// printScopedConfig prints the configuration file selected with --env/--file, if0.env by default
+func printScopedConfig() {
+	target, err := config.GetConfigFile(envName, envFile)
+	if err != nil {
+		fmt.Println("Error: Reading configuration - ", err)
+		return
+	}
+	fmt.Println("Configuration in", target)
+	err = config.PrintConfigFile(target)
+	if err != nil {
+		fmt.Println("Error: Reading configuration - ", err)
 	}
 }
 
@@ -138,10 +248,15 @@ func loadConfigFromFile(configFile string) {
 
 func init() {
 	rootCmd.AddCommand(configCmd)
+	configCmd.AddCommand(configGetCmd)
+	configCmd.AddCommand(configUnsetCmd)
+	configCmd.AddCommand(configListCmd)
 
+	configCmd.PersistentFlags().StringVar(&envName, "env", "",
+		"environment whose configuration is read or updated, instead of if0.env")
+	configCmd.PersistentFlags().StringVar(&envFile, "file", "",
+		"configuration file of the environment selected with --env (default zero.env)")
 	configCmd.Flags().StringSliceVar(&set, "set", nil, "sets env variables via CLI")
-	//configCmd.Flags().BoolVarP(&zero, "zero", "z",
-	//	false, "updates zero cluster configuration")
 	configCmd.Flags().BoolVarP(&merge, "merge", "m",
 		false, "merges the new configuration with running configuration")
 	configCmd.Flags().StringVar(&add, "add", "", "configuration file to be added or updated")
@@ -0,0 +1,120 @@
+/*
+Copyright © 2020 NAME HERE <EMAIL ADDRESS>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"if0/environments"
+	"time"
+)
+
+var (
+	// platform flag: runs the platform step after rotating credentials
+	rotatePlatform bool
+	// show flag: prints the new admin password
+	showPassword bool
+	// type flag: type of the new SSH key
+	sshKeyType string
+	// bits flag: bits of the new SSH key
+	sshKeyBits int
+	// passphrase flag: encrypts the new SSH key with a passphrase
+	sshKeyPassphrase bool
+	// grace flag: how long the previous SSH key is kept
+	sshKeyGrace time.Duration
+
+	envRotateAdminCmd = &cobra.Command{
+		Use:   "rotate-admin [env-name]",
+		Short: "regenerates the admin password of an environment",
+		Long: `Example: if0 env rotate-admin gitlab.com/group/env-1 --platform
+ZERO_ADMIN_PASSWORD and ZERO_ADMIN_PASSWORD_HASH of zero.env are replaced, the previous zero.env is
+kept as a snapshot (see 'if0 config snapshots'). The new password is encrypted, --show prints it.
+--platform runs 'if0 platform' afterwards, so that the platform uses the new password.
+The length and characters of the password are set with IF0_ADMIN_PASSWORD_LENGTH and IF0_ADMIN_PASSWORD_ALPHABET.`,
+		Args: cobra.MaximumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			envDir, err := getEnvDir(args)
+			if err != nil {
+				fmt.Println("Error: Rotating admin password - ", err)
+				return
+			}
+			snapshot, password, err := environments.RotateAdmin(envDir)
+			if err != nil {
+				fmt.Println("Error: Rotating admin password - ", err)
+				return
+			}
+			fmt.Println("Rotated admin password of", envDir)
+			fmt.Println("Previous zero.env saved as snapshot", snapshot.ID)
+			if showPassword {
+				fmt.Println("New admin password:", password)
+			}
+			if rotatePlatform {
+				err = environments.ZeroPlatform(envDir, overrides)
+				if err != nil {
+					fmt.Println("Error: zero provision - ", err)
+					return
+				}
+			}
+		},
+	}
+
+	envRotateSSHKeyCmd = &cobra.Command{
+		Use:   "rotate-ssh-key [env-name]",
+		Short: "replaces the SSH key pair of an environment",
+		Long: `Example: if0 env rotate-ssh-key gitlab.com/group/env-1 --type ecdsa --bits 384
+A new key pair is created in the .ssh directory of the environment, ed25519 unless --type or
+IF0_SSH_KEY_TYPE select ecdsa or rsa. The previous pair is renamed to <file>.<timestamp> and removed
+by the first rotation after --grace, so nodes can be switched to the new key in the meantime.
+Fingerprints and dates of the keys are recorded in .if0-env.json of the environment.
+--passphrase encrypts the private key with ` + environments.SSHKeyPassphraseKey + ` or a passphrase asked for.`,
+		Args: cobra.MaximumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			envDir, err := getEnvDir(args)
+			if err != nil {
+				fmt.Println("Error: Rotating SSH key - ", err)
+				return
+			}
+			opts := environments.SSHKeyOptions{Type: sshKeyType, Bits: sshKeyBits}
+			if sshKeyPassphrase {
+				opts.Passphrase, err = readPassphrase(environments.SSHKeyPassphraseKey, "Passphrase of the new SSH key: ")
+				if err != nil {
+					fmt.Println("Error: Reading passphrase - ", err)
+					return
+				}
+			} else {
+				opts.Passphrase = []byte{}
+			}
+			key, err := environments.RotateSSHKey(envDir, opts, sshKeyGrace)
+			if err != nil {
+				fmt.Println("Error: Rotating SSH key - ", err)
+				return
+			}
+			fmt.Printf("Created %s key %s %s\n", key.Type, key.File, key.Fingerprint)
+		},
+	}
+)
+
+func init() {
+	envCmd.AddCommand(envRotateAdminCmd)
+	envRotateAdminCmd.Flags().BoolVar(&rotatePlatform, "platform", false, "runs the platform step with the new password")
+	envRotateAdminCmd.Flags().BoolVar(&showPassword, "show", false, "prints the new admin password")
+	addOverrideFlag(envRotateAdminCmd)
+	envCmd.AddCommand(envRotateSSHKeyCmd)
+	envRotateSSHKeyCmd.Flags().StringVar(&sshKeyType, "type", "", "ed25519, ecdsa or rsa (default IF0_SSH_KEY_TYPE or ed25519)")
+	envRotateSSHKeyCmd.Flags().IntVar(&sshKeyBits, "bits", 0, "bits of ecdsa (256, 384, 521) and rsa keys")
+	envRotateSSHKeyCmd.Flags().BoolVar(&sshKeyPassphrase, "passphrase", false, "encrypts the private key with a passphrase")
+	envRotateSSHKeyCmd.Flags().DurationVar(&sshKeyGrace, "grace", environments.DefaultSSHKeyGrace, "how long the previous key is kept")
+}
@@ -27,8 +27,12 @@ var destroyCmd = &cobra.Command{
 	Short: "",
 	Long: `Example: if0 destroy [env-name]`,
 	Run: func(cmd *cobra.Command, args []string) {
-		envDir := getEnvDir(args)
-		err := environments.Dash1Destroy(envDir)
+		envDir, err := getEnvDir(args)
+		if err != nil {
+			fmt.Println("Error: dash1 destroy - ", err)
+			return
+		}
+		err = environments.Dash1Destroy(envDir, overrides)
 		if err != nil {
 			fmt.Println("Error: dash1 destroy - ", err)
 			return
@@ -38,4 +42,5 @@ var destroyCmd = &cobra.Command{
 
 func init() {
 	rootCmd.AddCommand(destroyCmd)
+	addOverrideFlag(destroyCmd)
 }
@@ -0,0 +1,159 @@
+/*
+Copyright © 2020 NAME HERE <EMAIL ADDRESS>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/spf13/cobra"
+	"if0/environments"
+	"os"
+	"strings"
+)
+
+var (
+	// force flag: removes environments with infrastructure state
+	force bool
+	// yes flag: skips the confirmation
+	yes bool
+
+	// envCmd groups the commands managing the lifecycle of environments
+	envCmd = &cobra.Command{
+		Use:   "env",
+		Short: "removes, copies, renames and archives environments",
+		Long: `Environments can be addressed by their full name or a unique short name, see 'if0 use'.
+Environments are added with 'if0 add'.`,
+	}
+
+	envRmCmd = &cobra.Command{
+		Use:   "rm env-name",
+		Short: "removes the local copy of an environment",
+		Long: `Example: if0 env rm gitlab.com/group/env-1
+Environments with terraform state (*.tfstate) are only removed with --force,
+run 'if0 destroy env-name' first to remove their infrastructure. The remote repository is not changed.`,
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			name, err := environments.ResolveEnvName(args[0])
+			if err != nil {
+				fmt.Println("Error: Removing environment - ", err)
+				return
+			}
+			if !yes && !confirm(fmt.Sprintf("Remove environment %s?", name)) {
+				return
+			}
+			err = environments.RemoveEnv(name, force)
+			if err != nil {
+				fmt.Println("Error: Removing environment - ", err)
+				return
+			}
+			fmt.Println("Removed environment", name)
+		},
+	}
+
+	envCpCmd = &cobra.Command{
+		Use:   "cp src-env dst-env",
+		Short: "creates a new environment from the configuration of an existing one",
+		Long: `Example: if0 env cp gitlab.com/group/prod gitlab.com/group/staging
+The admin password, secrets and SSH keys of the new environment are regenerated,
+git history and infrastructure state are not copied. Add a remote with 'if0 add'.`,
+		Args: cobra.ExactArgs(2),
+		Run: func(cmd *cobra.Command, args []string) {
+			envDir, err := environments.CopyEnv(args[0], args[1])
+			if err != nil {
+				fmt.Println("Error: Copying environment - ", err)
+				return
+			}
+			fmt.Println("Created environment", envDir)
+		},
+	}
+
+	envMvCmd = &cobra.Command{
+		Use:   "mv src-env dst-env",
+		Short: "renames an environment",
+		Long: `Example: if0 env mv gitlab.com/group/env-1 gitlab.com/group/env-2
+IF0_ENVIRONMENT and the current environment are updated, the git remote is not changed.`,
+		Args: cobra.ExactArgs(2),
+		Run: func(cmd *cobra.Command, args []string) {
+			envDir, err := environments.MoveEnv(args[0], args[1])
+			if err != nil {
+				fmt.Println("Error: Renaming environment - ", err)
+				return
+			}
+			fmt.Println("Moved environment to", envDir)
+		},
+	}
+
+	envArchiveCmd = &cobra.Command{
+		Use:   "archive env-name",
+		Short: "moves an environment into a compressed archive in ~/.if0/.archive",
+		Long: `Example: if0 env archive gitlab.com/group/env-1
+Archived environments are no longer listed and are restored with 'if0 env unarchive'.`,
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			archive, err := environments.ArchiveEnv(args[0])
+			if err != nil {
+				fmt.Println("Error: Archiving environment - ", err)
+				return
+			}
+			fmt.Println("Archived environment to", archive)
+		},
+	}
+
+	envUnarchiveCmd = &cobra.Command{
+		Use:   "unarchive [env-name]",
+		Short: "restores an archived environment, without env-name the archived environments are listed",
+		Args:  cobra.MaximumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			if len(args) == 0 {
+				names, err := environments.ArchivedEnvNames()
+				if err != nil {
+					fmt.Println("Error: Listing archived environments - ", err)
+					return
+				}
+				for _, name := range names {
+					fmt.Println(name)
+				}
+				return
+			}
+			envDir, err := environments.UnarchiveEnv(args[0])
+			if err != nil {
+				fmt.Println("Error: Restoring environment - ", err)
+				return
+			}
+			fmt.Println("Restored environment", envDir)
+		},
+	}
+)
+
+// confirm asks a yes/no question on the console, no is the default
+func confirm(question string) bool {
+	fmt.Print(question + " [y/N]: ")
+	reader := bufio.NewReader(os.Stdin)
+	answer, _ := reader.ReadString('\n')
+	answer = strings.TrimSpace(strings.ToLower(answer))
+	return answer == "y" || answer == "yes"
+}
+
+func init() {
+	rootCmd.AddCommand(envCmd)
+	envCmd.AddCommand(envRmCmd)
+	envCmd.AddCommand(envCpCmd)
+	envCmd.AddCommand(envMvCmd)
+	envCmd.AddCommand(envArchiveCmd)
+	envCmd.AddCommand(envUnarchiveCmd)
+	envRmCmd.Flags().BoolVar(&force, "force", false, "removes the environment even if it has infrastructure state")
+	envRmCmd.Flags().BoolVarP(&yes, "yes", "y", false, "removes the environment without confirmation")
+}
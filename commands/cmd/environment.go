@@ -18,10 +18,8 @@ package cmd
 import (
 	"fmt"
 	"github.com/spf13/cobra"
-	"if0/common"
 	"if0/environments"
 	"os"
-	"path/filepath"
 )
 
 const (
@@ -52,42 +50,62 @@ var environmentCmd = &cobra.Command{
 				fmt.Println("example command: if0 environment add repo-name [git@gitlab.com:repo-name.git]")
 				return
 			}
-			err := environments.AddEnv(args)
+			err := environments.AddEnv(args, environments.AddOptions{})
 			if err != nil {
 				fmt.Println("Error: Adding repo - ", err)
 				return
 			}
 		case syncArg:
-			envDir := getEnvDir(args)
-			err := environments.SyncEnv(envDir)
+			envDir, err := getEnvDir(args)
+			if err != nil {
+				fmt.Println("Error: ", err)
+				return
+			}
+			err = environments.SyncEnv(envDir)
 			if err != nil {
 				fmt.Println("Error: Syncing repo - ", err)
 				return
 			}
 		case planArg:
-			envDir := getEnvDir(args)
-			err := environments.Dash1Plan(envDir)
+			envDir, err := getEnvDir(args)
+			if err != nil {
+				fmt.Println("Error: ", err)
+				return
+			}
+			err = environments.Dash1Plan(envDir, nil)
 			if err != nil {
 				fmt.Println("Error: dash1 plan - ", err)
 				return
 			}
 		case provisionArg:
-			envDir := getEnvDir(args)
-			err := environments.ZeroPlatform(envDir)
+			envDir, err := getEnvDir(args)
+			if err != nil {
+				fmt.Println("Error: ", err)
+				return
+			}
+			err = environments.ZeroPlatform(envDir, nil)
 			if err != nil {
 				fmt.Println("Error: zero provision - ", err)
 				return
 			}
 		case zeroArg:
-			envDir := getEnvDir(args)
-			err := environments.Dash1Infrastructure(envDir)
+			envDir, err := getEnvDir(args)
+			if err != nil {
+				fmt.Println("Error: ", err)
+				return
+			}
+			err = environments.Dash1Infrastructure(envDir, nil)
 			if err != nil {
 				fmt.Println("Error: dash1 zero - ", err)
 				return
 			}
 		case destroyArg:
-			envDir := getEnvDir(args)
-			err := environments.Dash1Destroy(envDir)
+			envDir, err := getEnvDir(args)
+			if err != nil {
+				fmt.Println("Error: ", err)
+				return
+			}
+			err = environments.Dash1Destroy(envDir, nil)
 			if err != nil {
 				fmt.Println("Error: dash1 destroy - ", err)
 				return
@@ -96,14 +114,22 @@ var environmentCmd = &cobra.Command{
 	},
 }
 
-func getEnvDir(args []string) string {
-	var envDir string
-	if len(args) < 1 {
-		envDir, _ = os.Getwd()
-	} else {
-		envDir = filepath.Join(common.EnvDir, args[0])
+// getEnvDir returns the directory of the environment given as first argument, which can be a short name
+// like group/env or env. without an argument, the current environment set by `if0 use` is used,
+// and without a current environment, the working directory.
+func getEnvDir(args []string) (string, error) {
+	if len(args) > 0 {
+		name, err := environments.ResolveEnvName(args[0])
+		if err != nil {
+			return "", err
+		}
+		return environments.EnvDirOf(name), nil
+	}
+	if name := environments.CurrentEnv(); name != "" {
+		fmt.Println("Using current environment", name)
+		return environments.EnvDirOf(name), nil
 	}
-	return envDir
+	return os.Getwd()
 }
 
 func init() {
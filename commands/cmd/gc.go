@@ -0,0 +1,68 @@
+/*
+Copyright © 2020 NAME HERE <EMAIL ADDRESS>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"if0/config"
+)
+
+var (
+	// dryRun flag: only reports what would be changed
+	dryRun bool
+
+	// gcCmd runs the snapshot garbage collection with the policy configured in if0.env
+	gcCmd = &cobra.Command{
+		Use:   "gc",
+		Short: "removes configuration snapshots according to the GC_* policy in if0.env",
+		Long: `Example: if0 config gc --dry-run
+
+Policies (if0.env):
+  GC_PERIOD=30      remove snapshots older than 30 days (default)
+  GC_KEEP_LAST=5    keep the last 5 snapshots of every configuration file
+  GC_MAX_SIZE=10MB  remove the oldest snapshots until all snapshots fit into 10MB
+With GC_KEEP_LAST and GC_PERIOD, the last N snapshots are kept and older ones for at least GC_PERIOD days.
+GC_AUTO=yes runs the garbage collection after every 'if0 config'. Pinned and tagged snapshots are kept.`,
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			policy, err := config.LoadGcPolicy()
+			if err != nil {
+				fmt.Println("Error: Garbage collection - ", err)
+				return
+			}
+			removed, err := config.RunGarbageCollection(policy, dryRun)
+			if err != nil {
+				fmt.Println("Error: Garbage collection - ", err)
+				return
+			}
+			action := "Removed"
+			if dryRun {
+				action = "Would remove"
+			}
+			for _, s := range removed {
+				fmt.Printf("%s snapshot %s (%s, %d bytes)\n", action, s.ID,
+					s.Created.Format("2006-01-02 15:04:05"), s.Size)
+			}
+			fmt.Printf("%s %d snapshot(s)\n", action, len(removed))
+		},
+	}
+)
+
+func init() {
+	configCmd.AddCommand(gcCmd)
+	gcCmd.Flags().BoolVar(&dryRun, "dry-run", false, "reports the snapshots that would be removed")
+}
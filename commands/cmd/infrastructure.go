@@ -27,8 +27,12 @@ var infraCmd = &cobra.Command{
 	Short: "",
 	Long: `Example: if0 infrastructure [env-name]`,
 	Run: func(cmd *cobra.Command, args []string) {
-		envDir := getEnvDir(args)
-		err := environments.Dash1Infrastructure(envDir)
+		envDir, err := getEnvDir(args)
+		if err != nil {
+			fmt.Println("Error: dash1 infrastructure - ", err)
+			return
+		}
+		err = environments.Dash1Infrastructure(envDir, overrides)
 		if err != nil {
 			fmt.Println("Error: dash1 infrastructure - ", err)
 			return
@@ -38,4 +42,5 @@ var infraCmd = &cobra.Command{
 
 func init() {
 	rootCmd.AddCommand(infraCmd)
+	addOverrideFlag(infraCmd)
 }
@@ -16,21 +16,45 @@ limitations under the License.
 package cmd
 
 import (
+	"fmt"
 	"if0/environments"
 
 	"github.com/spf13/cobra"
 )
 
+var (
+	// resolve flag: expands ${VAR} references and shows the resolved values next to the raw values
+	resolve bool
+	// explain flag: shows where every value comes from and which layers it shadows
+	explain bool
+)
+
 // inspectCmd represents the inspect command
 var inspectCmd = &cobra.Command{
 	Use:   "inspect",
 	Short: "displays the configuration of a zero environment",
+	Long: `Example: if0 inspect [env-name] [--resolve] [--explain] [--override KEY=VALUE]
+Configuration is layered, a later layer takes precedence over an earlier one:
+  1. ~/.if0/if0.env
+  2. the environment's *.env files, in the order of IF0_ENV_FILES (default: zero.env,dash1.env),
+     files not listed follow in alphabetical order
+  3. the process environment
+  4. --override KEY=VALUE
+With --resolve, ${VAR} and ${VAR:-default} references are expanded.
+With --explain, every value is printed with the layer it comes from and the layers it shadows.`,
 	Run: func(cmd *cobra.Command, args []string) {
-		envDir := getEnvDir(args)
-		environments.InspectEnv(envDir)
+		envDir, err := getEnvDir(args)
+		if err != nil {
+			fmt.Println("Error: Inspect environment - ", err)
+			return
+		}
+		environments.InspectEnv(envDir, overrides, resolve, explain)
 	},
 }
 
 func init() {
 	rootCmd.AddCommand(inspectCmd)
+	inspectCmd.Flags().BoolVar(&resolve, "resolve", false, "shows the values with ${VAR} references resolved")
+	inspectCmd.Flags().BoolVar(&explain, "explain", false, "shows where every value comes from and which layers it shadows")
+	addOverrideFlag(inspectCmd)
 }
@@ -0,0 +1,76 @@
+/*
+Copyright © 2020 NAME HERE <EMAIL ADDRESS>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	gitsync "if0/common/sync"
+	"strings"
+)
+
+var (
+	// knownHostsCmd groups the commands to manage the host keys if0 trusts
+	knownHostsCmd = &cobra.Command{
+		Use:   "known-hosts",
+		Short: "manages the host keys of git remotes trusted by if0",
+		Long: `The host keys of git remotes are verified against ~/.ssh/known_hosts and the known_hosts of the profile,
+~/.if0/known_hosts. IF0_HOST_KEY_POLICY in the if0.env of the profile selects what happens to unknown hosts:
+tofu (default) asks to confirm the fingerprint, or accepts it with --accept-host-key when not on a terminal,
+strict refuses unknown hosts, accept-new adds them without asking and off disables the verification.
+Hosts accepted by if0 are added to ~/.if0/known_hosts, a host whose key has changed is refused.`,
+	}
+
+	knownHostsListCmd = &cobra.Command{
+		Use:   "list",
+		Short: "lists the host keys trusted by if0",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			hosts, err := gitsync.ListKnownHosts()
+			if err != nil {
+				fmt.Println("Error: Reading known hosts - ", err)
+				return
+			}
+			for _, h := range hosts {
+				fmt.Printf("%s\t%s\t%s\n", strings.Join(h.Hosts, ","), h.Type, h.Fingerprint)
+			}
+		},
+	}
+
+	knownHostsRemoveCmd = &cobra.Command{
+		Use:   "rm HOST",
+		Short: "removes the host keys of HOST, e.g. gitlab.com or [git.example.com]:2222",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			removed, err := gitsync.RemoveKnownHost(args[0])
+			if err != nil {
+				fmt.Println("Error: Removing known host - ", err)
+				return
+			}
+			if removed == 0 {
+				fmt.Println(args[0], "is not in", gitsync.KnownHostsFile())
+				return
+			}
+			fmt.Printf("Removed %d key(s) of %s from %s\n", removed, args[0], gitsync.KnownHostsFile())
+		},
+	}
+)
+
+func init() {
+	rootCmd.AddCommand(knownHostsCmd)
+	knownHostsCmd.AddCommand(knownHostsListCmd)
+	knownHostsCmd.AddCommand(knownHostsRemoveCmd)
+}
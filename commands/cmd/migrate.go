@@ -0,0 +1,64 @@
+/*
+Copyright © 2020 NAME HERE <EMAIL ADDRESS>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"if0/config"
+)
+
+// configMigrateCmd upgrades if0.env and the environments to the configuration version of this if0 release
+var configMigrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "migrates if0.env and all environments to the current configuration version",
+	Long: `Example: if0 config migrate [--dry-run]
+if0.env records its version in IF0_VERSION, environments in IF0_ENV_VERSION of zero.env.
+Every file is snapshotted before a migration step changes it. Migrations also run on 'if0 config'.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		changes, err := config.Migrate(dryRun)
+		if err != nil {
+			fmt.Println("Error: Migrating configuration - ", err)
+			return
+		}
+		if len(changes) == 0 {
+			fmt.Printf("Configuration is up to date (version %d)\n", config.CurrentVersion())
+			return
+		}
+		if dryRun {
+			fmt.Println("Planned changes:")
+		}
+		for _, c := range changes {
+			fmt.Printf("%s: version %d: %s\n", c.File, c.Version, c.Step)
+			for _, k := range c.Changes {
+				switch {
+				case k.Added:
+					fmt.Printf("  + %s=%s\n", k.Key, k.NewValue)
+				case k.Removed:
+					fmt.Printf("  - %s=%s\n", k.Key, k.OldValue)
+				default:
+					fmt.Printf("  ~ %s: %s -> %s\n", k.Key, k.OldValue, k.NewValue)
+				}
+			}
+		}
+	},
+}
+
+func init() {
+	configCmd.AddCommand(configMigrateCmd)
+	configMigrateCmd.Flags().BoolVar(&dryRun, "dry-run", false, "prints the planned changes without writing them")
+}
@@ -28,8 +28,12 @@ var planCmd = &cobra.Command{
 	Short: "",
 	Long: `Example: if0 plan [env-name]`,
 	Run: func(cmd *cobra.Command, args []string) {
-		envDir := getEnvDir(args)
-		err := environments.Dash1Plan(envDir)
+		envDir, err := getEnvDir(args)
+		if err != nil {
+			fmt.Println("Error: dash1 plan - ", err)
+			return
+		}
+		err = environments.Dash1Plan(envDir, overrides)
 		if err != nil {
 			fmt.Println("Error: dash1 plan - ", err)
 			return
@@ -39,4 +43,5 @@ var planCmd = &cobra.Command{
 
 func init() {
 	rootCmd.AddCommand(planCmd)
+	addOverrideFlag(planCmd)
 }
@@ -28,8 +28,12 @@ var platformCmd = &cobra.Command{
 	Short: "A brief description of your command",
 	Long: `Example: if0 platform [env-name]`,
 	Run: func(cmd *cobra.Command, args []string) {
-		envDir := getEnvDir(args)
-		err := environments.ZeroPlatform(envDir)
+		envDir, err := getEnvDir(args)
+		if err != nil {
+			fmt.Println("Error: zero provision - ", err)
+			return
+		}
+		err = environments.ZeroPlatform(envDir, overrides)
 		if err != nil {
 			fmt.Println("Error: zero provision - ", err)
 			return
@@ -39,4 +43,5 @@ var platformCmd = &cobra.Command{
 
 func init() {
 	rootCmd.AddCommand(platformCmd)
+	addOverrideFlag(platformCmd)
 }
@@ -0,0 +1,90 @@
+/*
+Copyright © 2020 NAME HERE <EMAIL ADDRESS>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"if0/config"
+)
+
+var (
+	// profileCmd groups the commands to manage profiles
+	profileCmd = &cobra.Command{
+		Use:   "profile",
+		Short: "manages profiles, separate if0 configurations for different customers or GitLab instances",
+		Long: `Every profile has its own if0.env, environments and snapshots.
+The default profile is kept in ~/.if0 (or IF0_HOME), named profiles in ~/.if0/profiles/<name>.
+A profile is selected with --profile, IF0_PROFILE or 'if0 profile use', in this order.`,
+	}
+
+	profileCreateCmd = &cobra.Command{
+		Use:   "create NAME",
+		Short: "creates a profile",
+		Long: `Example: if0 profile create acme
+         if0 --profile acme config --set IF0_REGISTRY_URL=https://git.acme.com`,
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			p, err := config.CreateProfile(args[0])
+			if err != nil {
+				fmt.Println("Error: Creating profile - ", err)
+				return
+			}
+			fmt.Printf("Created profile %s in %s\n", p.Name, p.Dir)
+		},
+	}
+
+	profileUseCmd = &cobra.Command{
+		Use:   "use NAME",
+		Short: "makes NAME the active profile",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			err := config.UseProfile(args[0])
+			if err != nil {
+				fmt.Println("Error: Selecting profile - ", err)
+				return
+			}
+			fmt.Println("Using profile", args[0])
+		},
+	}
+
+	profileListCmd = &cobra.Command{
+		Use:   "list",
+		Short: "lists the profiles, the profile of this run is marked with *",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			profiles, err := config.ListProfiles()
+			if err != nil {
+				fmt.Println("Error: Listing profiles - ", err)
+				return
+			}
+			for _, p := range profiles {
+				marker := " "
+				if p.Name == config.CurrentProfile() {
+					marker = "*"
+				}
+				fmt.Printf("%s %s\t%s\n", marker, p.Name, p.Dir)
+			}
+		},
+	}
+)
+
+func init() {
+	rootCmd.AddCommand(profileCmd)
+	profileCmd.AddCommand(profileCreateCmd)
+	profileCmd.AddCommand(profileUseCmd)
+	profileCmd.AddCommand(profileListCmd)
+}
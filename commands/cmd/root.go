@@ -19,14 +19,20 @@ import (
 	"fmt"
 	"github.com/spf13/cobra"
 	"if0/common"
+	gitsync "if0/common/sync"
+	"if0/config"
 	"os"
-	"path/filepath"
+)
 
-	homedir "github.com/mitchellh/go-homedir"
-	"github.com/spf13/viper"
+var (
+	// cfgFile replaces the if0.env of the profile
+	cfgFile string
+	// profile selects the profile, it takes precedence over IF0_PROFILE and `if0 profile use`
+	profile string
 )
 
-var cfgFile string
+// overrides are KEY=VALUE pairs taking precedence over all configuration files and the process environment
+var overrides []string
 
 // rootCmd represents the base command when called without any subcommands
 var rootCmd = &cobra.Command{
@@ -55,27 +61,29 @@ func Execute() {
 func init() {
 	cobra.OnInitialize(initConfig)
 	rootCmd.PersistentFlags().BoolVarP(&common.Verbose, "verbose", "v", false, "verbose output")
+	rootCmd.PersistentFlags().StringVar(&profile, "profile", "", "profile to use (default: IF0_PROFILE or the profile selected with 'if0 profile use')")
+	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "configuration file to use instead of the if0.env of the profile")
+	rootCmd.PersistentFlags().BoolVar(&gitsync.AcceptHostKey, "accept-host-key", false,
+		"trust the host keys of unknown git hosts without asking (IF0_HOST_KEY_POLICY=tofu)")
+}
+
+// addOverrideFlag adds the --override flag to commands that read the layered environment configuration
+func addOverrideFlag(cmd *cobra.Command) {
+	cmd.Flags().StringArrayVar(&overrides, "override", nil,
+		"KEY=VALUE overriding the environment configuration, can be repeated")
 }
 
-// initConfig reads in config file and ENV variables if set.
+// initConfig selects the profile and reads in config file and ENV variables if set.
 func initConfig() {
+	err := config.SelectProfile(config.ResolveProfile(profile))
+	if err != nil {
+		fmt.Println("Error: Selecting profile - ", err)
+		os.Exit(1)
+	}
 	if cfgFile != "" {
-		// Use config file from the flag.
-		viper.SetConfigFile(cfgFile)
-	} else {
-		// Find home directory.
-		home, err := homedir.Dir()
-		if err != nil {
-			fmt.Println(err)
-			os.Exit(1)
-		}
-
-		// Search config in home directory with file name "if0.env".
-		viper.SetConfigFile(filepath.Join(home, ".if0", "if0.env"))
+		common.If0Default = cfgFile
 	}
 
-	viper.AutomaticEnv() // read in environment variables that match
-
-	// If a config file is found, read it in.
-	_ = viper.ReadInConfig()
+	// environment variables take precedence over the values of the config file
+	config.ReadConfigFile(common.If0Default)
 }
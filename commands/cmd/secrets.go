@@ -0,0 +1,163 @@
+/*
+Copyright © 2020 NAME HERE <EMAIL ADDRESS>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"if0/config"
+)
+
+var (
+	// secretsCmd groups the commands to encrypt the secrets of an environment
+	secretsCmd = &cobra.Command{
+		Use:   "secrets",
+		Short: "encrypts and decrypts the secrets of an environment",
+		Long: `Secrets are stored as ENC[...] values in the *.env files of an environment, so they stay encrypted in git.
+Values are encrypted for the public keys in the .if0-recipients file of the environment,
+the private key of the user is kept in ~/.if0/keys/identity.key.
+Encrypted values are decrypted when they are passed to the dash1 and zero containers.
+The environment is selected with --env, or is the current environment (see if0 use) or working directory.`,
+	}
+
+	secretsEncryptCmd = &cobra.Command{
+		Use:   "encrypt [KEY...]",
+		Short: "encrypts the given keys, or all known secrets, of an environment",
+		Long: `Example: if0 secrets encrypt --env gitlab.com/group/env
+         if0 secrets encrypt --env gitlab.com/group/env MY_API_TOKEN`,
+		Run: func(cmd *cobra.Command, args []string) {
+			envDir, err := secretsEnvDir()
+			if err != nil {
+				fmt.Println("Error: Encrypting secrets - ", err)
+				return
+			}
+			count, err := config.EncryptEnvironment(envDir, args)
+			if err != nil {
+				fmt.Println("Error: Encrypting secrets - ", err)
+				return
+			}
+			fmt.Printf("%d value(s) encrypted\n", count)
+		},
+	}
+
+	secretsDecryptCmd = &cobra.Command{
+		Use:   "decrypt [KEY...]",
+		Short: "writes the given keys, or all encrypted values, of an environment back in plaintext",
+		Run: func(cmd *cobra.Command, args []string) {
+			envDir, err := secretsEnvDir()
+			if err != nil {
+				fmt.Println("Error: Decrypting secrets - ", err)
+				return
+			}
+			count, err := config.DecryptEnvironment(envDir, args)
+			if err != nil {
+				fmt.Println("Error: Decrypting secrets - ", err)
+				return
+			}
+			fmt.Printf("%d value(s) decrypted\n", count)
+		},
+	}
+
+	secretsRotateKeyCmd = &cobra.Command{
+		Use:   "rotate-key",
+		Short: "replaces your key pair and re-encrypts the secrets of all environments shared with it",
+		Long: `Example: if0 secrets rotate-key
+The previous private key is kept as ~/.if0/keys/identity.key.old.`,
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			rotated, err := config.RotateIdentity()
+			for _, envDir := range rotated {
+				fmt.Println("Re-encrypted secrets of", envDir)
+			}
+			if err != nil {
+				fmt.Println("Error: Rotating key - ", err)
+				return
+			}
+			id, _ := config.LoadIdentity()
+			fmt.Println("New public key:", id.Recipient())
+		},
+	}
+
+	secretsPublicKeyCmd = &cobra.Command{
+		Use:   "public-key",
+		Short: "prints your public key, to be added to an environment by a teammate",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			id, err := config.LoadOrCreateIdentity()
+			if err != nil {
+				fmt.Println("Error: Reading key - ", err)
+				return
+			}
+			fmt.Println(id.Recipient())
+		},
+	}
+
+	secretsAddRecipientCmd = &cobra.Command{
+		Use:   "add-recipient PUBLIC_KEY",
+		Short: "shares the secrets of an environment with a teammate",
+		Long:  `Example: if0 secrets add-recipient --env gitlab.com/group/env if0pub1...`,
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			envDir, err := secretsEnvDir()
+			if err != nil {
+				fmt.Println("Error: Adding recipient - ", err)
+				return
+			}
+			err = config.AddRecipient(envDir, args[0])
+			if err != nil {
+				fmt.Println("Error: Adding recipient - ", err)
+			}
+		},
+	}
+
+	secretsRemoveRecipientCmd = &cobra.Command{
+		Use:   "remove-recipient PUBLIC_KEY",
+		Short: "stops sharing the secrets of an environment with a teammate",
+		Long: `Example: if0 secrets remove-recipient --env gitlab.com/group/env if0pub1...
+Values the teammate has seen before should be changed as well.`,
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			envDir, err := secretsEnvDir()
+			if err != nil {
+				fmt.Println("Error: Removing recipient - ", err)
+				return
+			}
+			err = config.RemoveRecipient(envDir, args[0])
+			if err != nil {
+				fmt.Println("Error: Removing recipient - ", err)
+			}
+		},
+	}
+)
+
+// secretsEnvDir returns the environment selected with --env, the current environment by default
+func secretsEnvDir() (string, error) {
+	if envName == "" {
+		return getEnvDir(nil)
+	}
+	return getEnvDir([]string{envName})
+}
+
+func init() {
+	rootCmd.AddCommand(secretsCmd)
+	secretsCmd.AddCommand(secretsEncryptCmd)
+	secretsCmd.AddCommand(secretsDecryptCmd)
+	secretsCmd.AddCommand(secretsRotateKeyCmd)
+	secretsCmd.AddCommand(secretsPublicKeyCmd)
+	secretsCmd.AddCommand(secretsAddRecipientCmd)
+	secretsCmd.AddCommand(secretsRemoveRecipientCmd)
+	secretsCmd.PersistentFlags().StringVar(&envName, "env", "", "environment whose secrets are encrypted or decrypted")
+}
@@ -0,0 +1,189 @@
+/*
+Copyright © 2020 NAME HERE <EMAIL ADDRESS>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"if0/config"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+)
+
+var (
+	// snapshotsCmd groups the commands to work with the backups in ~/.if0/.snapshots
+	snapshotsCmd = &cobra.Command{
+		Use:   "snapshots",
+		Short: "lists, compares, restores and tags configuration snapshots",
+		Long: `Snapshots are taken in ~/.if0/.snapshots whenever a configuration file is replaced or merged.
+Pinned or tagged snapshots are never removed by the garbage collection.`,
+	}
+
+	snapshotsListCmd = &cobra.Command{
+		Use:   "list",
+		Short: "lists the configuration snapshots",
+		Long: `Example: if0 config snapshots list
+         if0 config snapshots list --env gitlab.com/group/env --file dash1.env`,
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			snapshots, err := config.ListSnapshots()
+			if err != nil {
+				fmt.Println("Error: Listing snapshots - ", err)
+				return
+			}
+			var source string
+			if envName != "" {
+				source, err = config.GetConfigFile(envName, envFile)
+				if err != nil {
+					fmt.Println("Error: Listing snapshots - ", err)
+					return
+				}
+				source, _ = filepath.Abs(source)
+			}
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			_, _ = fmt.Fprintln(w, "ID\tCREATED\tSOURCE\tTAGS")
+			for _, s := range snapshots {
+				if source != "" && s.Source != source {
+					continue
+				}
+				tags := strings.Join(s.Tags, ",")
+				if s.Pinned {
+					tags = strings.TrimPrefix(tags+",pinned", ",")
+				}
+				src := s.Source
+				if src == "" {
+					src = "unknown"
+				}
+				_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", s.ID,
+					s.Created.Format("2006-01-02 15:04:05"), src, tags)
+			}
+			_ = w.Flush()
+		},
+	}
+
+	snapshotsDiffCmd = &cobra.Command{
+		Use:   "diff SNAPSHOT [SNAPSHOT|running]",
+		Short: "compares two snapshots, or a snapshot with its current source file",
+		Long: `Example: if0 config snapshots diff if0-02042020_170240
+         if0 config snapshots diff if0-02042020_170240 before-upgrade`,
+		Args: cobra.RangeArgs(1, 2),
+		Run: func(cmd *cobra.Command, args []string) {
+			b := config.RunningSnapshot
+			if len(args) > 1 {
+				b = args[1]
+			}
+			changes, err := config.DiffSnapshots(args[0], b)
+			if err != nil {
+				fmt.Println("Error: Comparing snapshots - ", err)
+				return
+			}
+			if len(changes) == 0 {
+				fmt.Println("No differences found")
+				return
+			}
+			for _, c := range changes {
+				switch {
+				case c.Added:
+					fmt.Printf("+ %s=%s\n", c.Key, c.NewValue)
+				case c.Removed:
+					fmt.Printf("- %s=%s\n", c.Key, c.OldValue)
+				default:
+					fmt.Printf("~ %s: %s -> %s\n", c.Key, c.OldValue, c.NewValue)
+				}
+			}
+		},
+	}
+
+	snapshotsRestoreCmd = &cobra.Command{
+		Use:   "restore SNAPSHOT",
+		Short: "restores a snapshot to the file it was taken from",
+		Long: `Example: if0 config snapshots restore if0-02042020_170240
+The current content of the file is snapshotted before it is replaced.`,
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			backup, err := config.RestoreSnapshot(args[0])
+			if err != nil {
+				fmt.Println("Error: Restoring snapshot - ", err)
+				return
+			}
+			fmt.Println("Snapshot restored:", args[0])
+			if backup != nil {
+				fmt.Println("Previous configuration saved as snapshot", backup.ID)
+			}
+		},
+	}
+
+	snapshotsTagCmd = &cobra.Command{
+		Use:   "tag SNAPSHOT TAG",
+		Short: "tags a snapshot, tagged snapshots are kept by the garbage collection",
+		Args:  cobra.ExactArgs(2),
+		Run: func(cmd *cobra.Command, args []string) {
+			err := config.TagSnapshot(args[0], args[1])
+			if err != nil {
+				fmt.Println("Error: Tagging snapshot - ", err)
+			}
+		},
+	}
+
+	snapshotsUntagCmd = &cobra.Command{
+		Use:   "untag SNAPSHOT TAG",
+		Short: "removes a tag from a snapshot",
+		Args:  cobra.ExactArgs(2),
+		Run: func(cmd *cobra.Command, args []string) {
+			err := config.UntagSnapshot(args[0], args[1])
+			if err != nil {
+				fmt.Println("Error: Removing snapshot tag - ", err)
+			}
+		},
+	}
+
+	snapshotsPinCmd = &cobra.Command{
+		Use:   "pin SNAPSHOT",
+		Short: "pins a snapshot, pinned snapshots are kept by the garbage collection",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			err := config.PinSnapshot(args[0], true)
+			if err != nil {
+				fmt.Println("Error: Pinning snapshot - ", err)
+			}
+		},
+	}
+
+	snapshotsUnpinCmd = &cobra.Command{
+		Use:   "unpin SNAPSHOT",
+		Short: "unpins a snapshot",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			err := config.PinSnapshot(args[0], false)
+			if err != nil {
+				fmt.Println("Error: Unpinning snapshot - ", err)
+			}
+		},
+	}
+)
+
+func init() {
+	configCmd.AddCommand(snapshotsCmd)
+	snapshotsCmd.AddCommand(snapshotsListCmd)
+	snapshotsCmd.AddCommand(snapshotsDiffCmd)
+	snapshotsCmd.AddCommand(snapshotsRestoreCmd)
+	snapshotsCmd.AddCommand(snapshotsTagCmd)
+	snapshotsCmd.AddCommand(snapshotsUntagCmd)
+	snapshotsCmd.AddCommand(snapshotsPinCmd)
+	snapshotsCmd.AddCommand(snapshotsUnpinCmd)
+}
@@ -16,21 +16,42 @@ limitations under the License.
 package cmd
 
 import (
+	"errors"
 	"fmt"
 	"github.com/spf13/cobra"
+	"if0/config"
 	"if0/environments"
 )
 
+var (
+	// ours and theirs resolve the conflicting changes of a sync
+	ours   bool
+	theirs bool
+)
+
 // syncCmd represents the sync command
 var syncCmd = &cobra.Command{
 	Use:   "sync",
 	Short: "A brief description of your command",
 	Long: `Example: if0 sync [env-name]
 This command is used to sync the local environment env-name with its remote repository.
-If the env-name is not provided, the current working directory is assumed to be the environment to be synced.`,
+If the env-name is not provided, the current environment (see if0 use) or the current working directory is synced.
+Local changes are committed and merged with the remote changes: files changed on one side take that side,
+.env files changed on both sides are merged key by key. Keys and other files changed on both sides
+are asked about on a terminal, or resolved with --ours or --theirs.`,
 	Run: func(cmd *cobra.Command, args []string) {
-		envDir := getEnvDir(args)
-		err := environments.SyncEnv(envDir)
+		strategy, err := mergeStrategy()
+		if err != nil {
+			fmt.Println("Error: Syncing repo - ", err)
+			return
+		}
+		config.MergeStrategy = strategy
+		envDir, err := getEnvDir(args)
+		if err != nil {
+			fmt.Println("Error: Syncing repo - ", err)
+			return
+		}
+		err = environments.SyncEnv(envDir)
 		if err != nil {
 			fmt.Println("Error: Syncing repo - ", err)
 			return
@@ -38,6 +59,21 @@ If the env-name is not provided, the current working directory is assumed to be
 	},
 }
 
+// mergeStrategy returns the side selected with --ours or --theirs, empty if none is
+func mergeStrategy() (string, error) {
+	switch {
+	case ours && theirs:
+		return "", errors.New("--ours and --theirs cannot be used together")
+	case ours:
+		return config.MergeOurs, nil
+	case theirs:
+		return config.MergeTheirs, nil
+	}
+	return "", nil
+}
+
 func init() {
 	rootCmd.AddCommand(syncCmd)
+	syncCmd.Flags().BoolVar(&ours, "ours", false, "resolves conflicting changes with the local values")
+	syncCmd.Flags().BoolVar(&theirs, "theirs", false, "resolves conflicting changes with the remote values")
 }
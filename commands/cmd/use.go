@@ -0,0 +1,57 @@
+/*
+Copyright © 2020 NAME HERE <EMAIL ADDRESS>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"if0/environments"
+)
+
+// useCmd sets the current environment of the profile
+var useCmd = &cobra.Command{
+	Use:   "use [env-name]",
+	Short: "sets the environment used by commands without an env-name",
+	Long: `Example: if0 use gitlab.com/group/env-1
+         if0 use env-1
+
+Environments can be addressed by their full name, trailing parts of it (group/env-1, env-1)
+or any unique part of the name. Without env-name, the current environment is printed.
+plan, infrastructure, platform, destroy, sync, inspect and secrets use the current environment
+when no env-name is given. IF0_CURRENT_ENV overrides it for a single run.`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) == 0 {
+			current := environments.CurrentEnv()
+			if current == "" {
+				fmt.Println("No current environment set")
+				return
+			}
+			fmt.Println(current)
+			return
+		}
+		name, err := environments.UseEnv(args[0])
+		if err != nil {
+			fmt.Println("Error: Selecting environment - ", err)
+			return
+		}
+		fmt.Println("Using environment", name)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(useCmd)
+}
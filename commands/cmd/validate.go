@@ -0,0 +1,69 @@
+/*
+Copyright © 2020 NAME HERE <EMAIL ADDRESS>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"if0/common"
+	"if0/config"
+	"if0/environments"
+)
+
+// configValidateCmd validates if0.env, or the configuration of an environment, against the configuration schema
+var configValidateCmd = &cobra.Command{
+	Use:   "validate",
+	Short: "validates the configuration against the schema of known keys",
+	Long: `Example: if0 config validate
+         if0 config validate --env gitlab.com/group/env
+Without --env, ~/.if0/if0.env is validated. With --env, the layered configuration of the environment is validated:
+value types, keys required by DASH1_MODULE and deprecated keys.
+The configuration of an environment is also validated before a dash1 or zero container is started.`,
+	Args: cobra.NoArgs,
+	// Execute prints the error, the usage is not helpful for an invalid configuration
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var issues []config.Issue
+		var err error
+		if envName == "" {
+			issues, err = config.ValidateGlobal(common.If0Default)
+		} else {
+			var name string
+			name, err = environments.ResolveEnvName(envName)
+			if err == nil {
+				issues, err = config.ValidateEnvironment(environments.EnvDirOf(name), overrides)
+			}
+		}
+		if err != nil {
+			return errors.Wrap(err, "validating configuration")
+		}
+		for _, issue := range issues {
+			fmt.Println(issue)
+		}
+		if config.HasErrors(issues) {
+			return errors.New("the configuration is invalid")
+		}
+		fmt.Println("Configuration is valid")
+		return nil
+	},
+}
+
+func init() {
+	configCmd.AddCommand(configValidateCmd)
+	addOverrideFlag(configValidateCmd)
+}
@@ -8,17 +8,43 @@ import (
 const (
 	IF0_VERSION  = "IF0_VERSION"
 	ZERO_VERSION = "ZERO_VERSION"
+
+	ZeroEnvFile  = "zero.env"
+	Dash1EnvFile = "dash1.env"
 )
 
+// IF0_HOME overrides the location of the if0 home directory, ~/.if0 by default
+const If0HomeKey = "IF0_HOME"
+
 var (
-	RootPath, _    = os.UserHomeDir()
-	If0Dir         = filepath.Join(RootPath, ".if0")
+	RootPath, _ = os.UserHomeDir()
+	// If0Home holds the default profile and the named profiles
+	If0Home = if0Home()
+	// If0Dir is the directory of the active profile, the other paths are below it
+	If0Dir         = If0Home
 	EnvDir         = filepath.Join(If0Dir, ".environments")
 	SnapshotsDir   = filepath.Join(If0Dir, ".snapshots")
+	ArchiveDir     = filepath.Join(If0Dir, ".archive")
 	If0Default     = filepath.Join(If0Dir, "if0.env")
 	DefaultEnvFile = filepath.Join("config", "defenv", "defaultIf0.env")
 )
 
+func if0Home() string {
+	if home := os.Getenv(If0HomeKey); home != "" {
+		return home
+	}
+	return filepath.Join(RootPath, ".if0")
+}
+
+// SetIf0Dir points If0Dir and the paths below it to dir
+func SetIf0Dir(dir string) {
+	If0Dir = dir
+	EnvDir = filepath.Join(dir, ".environments")
+	SnapshotsDir = filepath.Join(dir, ".snapshots")
+	ArchiveDir = filepath.Join(dir, ".archive")
+	If0Default = filepath.Join(dir, "if0.env")
+}
+
 // common flag
 var (
 	Verbose bool
@@ -0,0 +1,265 @@
+package sync
+
+import (
+	"bufio"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	gitssh "github.com/go-git/go-git/v5/plumbing/transport/ssh"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"if0/common"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// HostKeyPolicyKey selects how the host keys of git remotes are verified, it is set in the if0.env of a profile
+const HostKeyPolicyKey = "IF0_HOST_KEY_POLICY"
+
+// Host key policies
+const (
+	// HostKeyStrict only connects to hosts in known_hosts
+	HostKeyStrict = "strict"
+	// HostKeyTOFU asks to confirm the fingerprint of unknown hosts, or accepts them with --accept-host-key
+	HostKeyTOFU = "tofu"
+	// HostKeyAcceptNew adds unknown hosts without asking, like StrictHostKeyChecking=accept-new
+	HostKeyAcceptNew = "accept-new"
+	// HostKeyOff does not verify host keys
+	HostKeyOff = "off"
+)
+
+// HostKeyPolicies are the valid values of IF0_HOST_KEY_POLICY, the first one is the default
+var HostKeyPolicies = []string{HostKeyTOFU, HostKeyStrict, HostKeyAcceptNew, HostKeyOff}
+
+// AcceptHostKey accepts the keys of unknown hosts with the tofu policy, set by --accept-host-key
+var AcceptHostKey bool
+
+// KnownHostsFile returns the known_hosts file managed by if0, hosts accepted on first use are added to it
+func KnownHostsFile() string {
+	return filepath.Join(common.If0Dir, "known_hosts")
+}
+
+// knownHostsFiles returns the existing known_hosts files: the one of the user and the one of if0
+func knownHostsFiles() []string {
+	var files []string
+	for _, file := range []string{filepath.Join(common.RootPath, ".ssh", "known_hosts"), KnownHostsFile()} {
+		if _, err := os.Stat(file); err == nil {
+			files = append(files, file)
+		}
+	}
+	return files
+}
+
+// HostKeyChangedError is returned if a host presents a key other than the one in known_hosts
+type HostKeyChangedError struct {
+	Host        string
+	Fingerprint string
+	Known       []knownhosts.KnownKey
+}
+
+func (e *HostKeyChangedError) Error() string {
+	var known []string
+	for _, k := range e.Known {
+		known = append(known, fmt.Sprintf("%s (%s:%d)", ssh.FingerprintSHA256(k.Key), k.Filename, k.Line))
+	}
+	return fmt.Sprintf("host key of %s has changed to %s, expected %s. someone could be intercepting the connection; "+
+		"if the host key has been replaced, remove the old entry with 'ssh-keygen -R %s -f <file>' and try again",
+		e.Host, e.Fingerprint, strings.Join(known, ", "), knownhosts.Normalize(e.Host))
+}
+
+// hostKeyCallback verifies host keys with the policy of the profile
+func hostKeyCallback(authObj AuthOps) (ssh.HostKeyCallback, error) {
+	policy := authObj.getenv(HostKeyPolicyKey)
+	if policy == "" {
+		policy = HostKeyPolicies[0]
+	}
+	if !containsString(HostKeyPolicies, policy) {
+		return nil, fmt.Errorf("%s must be one of %s, got %q", HostKeyPolicyKey, strings.Join(HostKeyPolicies, ", "), policy)
+	}
+	if policy == HostKeyOff {
+		verbose("Host keys are not verified,", HostKeyPolicyKey, "is", policy)
+		return ssh.InsecureIgnoreHostKey(), nil
+	}
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		ok, known, err := knownHostKeys(hostname, remote, key)
+		if err != nil || ok {
+			return err
+		}
+		if len(known) > 0 {
+			return &HostKeyChangedError{Host: hostname, Fingerprint: ssh.FingerprintSHA256(key), Known: known}
+		}
+		fingerprint := ssh.FingerprintSHA256(key)
+		switch {
+		case policy == HostKeyAcceptNew || (policy == HostKeyTOFU && AcceptHostKey):
+			fmt.Printf("Adding %s key %s of %s to %s\n", key.Type(), fingerprint, hostname, KnownHostsFile())
+		case policy == HostKeyTOFU && authObj.interactive():
+			if !authObj.confirmHostKey(hostname, key) {
+				return fmt.Errorf("host key of %s rejected", hostname)
+			}
+		case policy == HostKeyTOFU:
+			return fmt.Errorf("host %s is unknown, verify its %s key %s and run again with --accept-host-key",
+				hostname, key.Type(), fingerprint)
+		default:
+			return fmt.Errorf("host %s is not in known_hosts (%s is %s), its %s key is %s",
+				hostname, HostKeyPolicyKey, policy, key.Type(), fingerprint)
+		}
+		return addKnownHost(hostname, key)
+	}, nil
+}
+
+// knownHostKeys reports whether key is known for hostname, if not the other keys known for it are returned
+func knownHostKeys(hostname string, remote net.Addr, key ssh.PublicKey) (bool, []knownhosts.KnownKey, error) {
+	files := knownHostsFiles()
+	if len(files) == 0 {
+		return false, nil, nil
+	}
+	check, err := knownhosts.New(files...)
+	if err != nil {
+		return false, nil, err
+	}
+	err = check(hostname, remote, key)
+	var keyErr *knownhosts.KeyError
+	if errors.As(err, &keyErr) {
+		return false, keyErr.Want, nil
+	}
+	return err == nil, nil, err
+}
+
+// knownHostAlgorithms returns the algorithms of the keys known for host, the server is asked for one of them
+func knownHostAlgorithms(host string) []string {
+	// a key which is never known reveals the known keys of the host
+	probe, err := ssh.NewPublicKey(ed25519Probe)
+	if err != nil {
+		return nil
+	}
+	_, known, _ := knownHostKeys(host, &net.TCPAddr{}, probe)
+	var algorithms []string
+	for _, k := range known {
+		for _, algorithm := range keyAlgorithms(k.Key.Type()) {
+			if !containsString(algorithms, algorithm) {
+				algorithms = append(algorithms, algorithm)
+			}
+		}
+	}
+	return algorithms
+}
+
+// keyAlgorithms returns the signature algorithms of a key type. RSA keys are asked for with SHA-2 signatures
+// first, OpenSSH 8.8 and later no longer offer ssh-rsa, which signs with SHA-1
+func keyAlgorithms(keyType string) []string {
+	if keyType == ssh.KeyAlgoRSA {
+		return []string{ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSA}
+	}
+	return []string{keyType}
+}
+
+var ed25519Probe = ed25519.PublicKey(make([]byte, ed25519.PublicKeySize))
+
+// addKnownHost adds the key of hostname to the known_hosts file of if0
+func addKnownHost(hostname string, key ssh.PublicKey) error {
+	err := os.MkdirAll(filepath.Dir(KnownHostsFile()), 0700)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(KnownHostsFile(), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = f.WriteString(knownhosts.Line([]string{knownhosts.Normalize(hostname)}, key) + "\n")
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// KnownHost is an entry of the known_hosts file of if0
+type KnownHost struct {
+	Hosts       []string
+	Type        string
+	Fingerprint string
+}
+
+// ListKnownHosts returns the entries of the known_hosts file of if0
+func ListKnownHosts() ([]KnownHost, error) {
+	data, err := ioutil.ReadFile(KnownHostsFile())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var hosts []KnownHost
+	for len(data) > 0 {
+		_, names, key, _, rest, err := ssh.ParseKnownHosts(data)
+		if err != nil {
+			break
+		}
+		hosts = append(hosts, KnownHost{Hosts: names, Type: key.Type(), Fingerprint: ssh.FingerprintSHA256(key)})
+		data = rest
+	}
+	return hosts, nil
+}
+
+// RemoveKnownHost removes the entries of host from the known_hosts file of if0, returns the number removed
+func RemoveKnownHost(host string) (int, error) {
+	data, err := ioutil.ReadFile(KnownHostsFile())
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	host = knownhosts.Normalize(host)
+	var kept []string
+	removed := 0
+	for _, line := range strings.SplitAfter(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) > 0 && !strings.HasPrefix(fields[0], "#") && containsString(strings.Split(fields[0], ","), host) {
+			removed++
+			continue
+		}
+		kept = append(kept, line)
+	}
+	if removed == 0 {
+		return 0, nil
+	}
+	return removed, ioutil.WriteFile(KnownHostsFile(), []byte(strings.Join(kept, "")), 0600)
+}
+
+// hostKeyAuth asks the server for a host key of a type known for the host, so that a host known by
+// its ed25519 key is not taken for a changed host when it offers its ECDSA key first
+type hostKeyAuth struct {
+	gitssh.AuthMethod
+	algorithms []string
+}
+
+func (a *hostKeyAuth) ClientConfig() (*ssh.ClientConfig, error) {
+	cfg, err := a.AuthMethod.ClientConfig()
+	if err != nil {
+		return nil, err
+	}
+	cfg.HostKeyAlgorithms = a.algorithms
+	return cfg, nil
+}
+
+// confirmHostKey asks on the terminal whether the key of an unknown host is trusted
+func (p *Auth) confirmHostKey(hostname string, key ssh.PublicKey) bool {
+	fmt.Printf("The authenticity of host %s can't be established.\n", hostname)
+	fmt.Printf("%s key fingerprint is %s.\n", key.Type(), ssh.FingerprintSHA256(key))
+	fmt.Print("Are you sure you want to continue connecting (yes/no)? ")
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "yes" || answer == "y"
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,172 @@
+package sync
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"if0/common"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// useTempHostKeys points the known_hosts files of the user and of if0 to a temporary directory
+func useTempHostKeys(t *testing.T) func() {
+	dir, err := ioutil.TempDir("", "if0-hostkeys")
+	assert.Nil(t, err)
+	rootPath, if0Dir := common.RootPath, common.If0Dir
+	common.RootPath = dir
+	common.SetIf0Dir(filepath.Join(dir, ".if0"))
+	_ = os.MkdirAll(filepath.Join(dir, ".ssh"), 0700)
+	return func() {
+		_ = os.RemoveAll(dir)
+		common.RootPath = rootPath
+		common.SetIf0Dir(if0Dir)
+		AcceptHostKey = false
+	}
+}
+
+func newHostKey(t *testing.T) ssh.PublicKey {
+	public, _, err := ed25519.GenerateKey(rand.Reader)
+	assert.Nil(t, err)
+	key, err := ssh.NewPublicKey(public)
+	assert.Nil(t, err)
+	return key
+}
+
+var remoteAddr = &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 22}
+
+func TestHostKeyTOFU(t *testing.T) {
+	defer useTempHostKeys(t)()
+	key := newHostKey(t)
+	testObj := &mockAuth{batch: true}
+	check, err := hostKeyCallback(testObj)
+	assert.Nil(t, err)
+
+	err = check("gitlab.com:22", remoteAddr, key)
+	assert.EqualError(t, err, "host gitlab.com:22 is unknown, verify its ssh-ed25519 key "+
+		ssh.FingerprintSHA256(key)+" and run again with --accept-host-key")
+	AcceptHostKey = true
+	assert.Nil(t, check("gitlab.com:22", remoteAddr, key))
+	AcceptHostKey = false
+	assert.Nil(t, check("gitlab.com:22", remoteAddr, key))
+	assert.Equal(t, []string{"ssh-ed25519"}, knownHostAlgorithms("gitlab.com:22"))
+
+	// a changed key is never accepted
+	AcceptHostKey = true
+	err = check("gitlab.com:22", remoteAddr, newHostKey(t))
+	changed, ok := err.(*HostKeyChangedError)
+	assert.True(t, ok, "%v", err)
+	assert.Equal(t, KnownHostsFile(), changed.Known[0].Filename)
+	assert.Equal(t, ssh.FingerprintSHA256(key), ssh.FingerprintSHA256(changed.Known[0].Key))
+
+	// interactive confirmation
+	testObj.batch = false
+	AcceptHostKey = false
+	assert.EqualError(t, check("gitlab.example.com:22", remoteAddr, key), "host key of gitlab.example.com:22 rejected")
+	testObj.trust = true
+	assert.Nil(t, check("gitlab.example.com:22", remoteAddr, key))
+
+	hosts, err := ListKnownHosts()
+	assert.Nil(t, err)
+	assert.Equal(t, []KnownHost{
+		{Hosts: []string{"gitlab.com"}, Type: "ssh-ed25519", Fingerprint: ssh.FingerprintSHA256(key)},
+		{Hosts: []string{"gitlab.example.com"}, Type: "ssh-ed25519", Fingerprint: ssh.FingerprintSHA256(key)},
+	}, hosts)
+	removed, err := RemoveKnownHost("gitlab.com:22")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, removed)
+	hosts, _ = ListKnownHosts()
+	assert.Len(t, hosts, 1)
+}
+
+func TestHostKeyPolicies(t *testing.T) {
+	defer useTempHostKeys(t)()
+	key := newHostKey(t)
+	userKnownHosts := filepath.Join(common.RootPath, ".ssh", "known_hosts")
+	line := knownhosts.Line([]string{knownhosts.Normalize("github.com:22")}, key) + "\n"
+	assert.Nil(t, ioutil.WriteFile(userKnownHosts, []byte(line), 0600))
+
+	strict := &mockAuth{env: map[string]string{HostKeyPolicyKey: HostKeyStrict}, trust: true}
+	check, err := hostKeyCallback(strict)
+	assert.Nil(t, err)
+	assert.Nil(t, check("github.com:22", remoteAddr, key), "keys of ~/.ssh/known_hosts are trusted")
+	AcceptHostKey = true
+	assert.NotNil(t, check("gitlab.com:22", remoteAddr, key))
+	_, err = os.Stat(KnownHostsFile())
+	assert.True(t, os.IsNotExist(err))
+
+	acceptNew := &mockAuth{env: map[string]string{HostKeyPolicyKey: HostKeyAcceptNew}, batch: true}
+	check, _ = hostKeyCallback(acceptNew)
+	assert.Nil(t, check("gitlab.com:22", remoteAddr, key))
+	_, ok := check("github.com:22", remoteAddr, newHostKey(t)).(*HostKeyChangedError)
+	assert.True(t, ok)
+
+	off := &mockAuth{env: map[string]string{HostKeyPolicyKey: HostKeyOff}, batch: true}
+	check, _ = hostKeyCallback(off)
+	assert.Nil(t, check("github.com:22", remoteAddr, newHostKey(t)))
+
+	_, err = hostKeyCallback(&mockAuth{env: map[string]string{HostKeyPolicyKey: "yes"}})
+	assert.EqualError(t, err, `IF0_HOST_KEY_POLICY must be one of tofu, strict, accept-new, off, got "yes"`)
+}
+
+func TestHostKeyAuthAlgorithms(t *testing.T) {
+	defer useTempHostKeys(t)()
+	_, edPrivate, _ := ed25519.GenerateKey(rand.Reader)
+	edSigner, _ := ssh.NewSignerFromKey(edPrivate)
+	ecPrivate, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	ecSigner, _ := ssh.NewSignerFromKey(ecPrivate)
+	assert.Nil(t, addKnownHost("gitlab.com:22", edSigner.PublicKey()))
+
+	// the server prefers its ECDSA key, the client asks for the known ed25519 key
+	serverConfig := &ssh.ServerConfig{NoClientAuth: true}
+	serverConfig.AddHostKey(ecSigner)
+	serverConfig.AddHostKey(edSigner)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer listener.Close()
+	go func() {
+		serverConn, err := listener.Accept()
+		if err == nil {
+			_, _, _, _ = ssh.NewServerConn(serverConn, serverConfig)
+			_ = serverConn.Close()
+		}
+	}()
+
+	clientSigner, _ := ssh.NewSignerFromKey(edPrivate)
+	testObj := &mockAuth{agent: []ssh.Signer{clientSigner}, files: map[string][]byte{}, batch: true}
+	auth, err := getAuth(testObj, "git@gitlab.com:group/env.git")
+	assert.Nil(t, err)
+	config, err := auth.(*hostKeyAuth).ClientConfig()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{ssh.KeyAlgoED25519}, config.HostKeyAlgorithms)
+	clientConn, err := net.DialTimeout("tcp", listener.Addr().String(), 10*time.Second)
+	assert.Nil(t, err)
+	defer clientConn.Close()
+	_ = clientConn.SetDeadline(time.Now().Add(10 * time.Second))
+	conn, _, _, err := ssh.NewClientConn(clientConn, "gitlab.com:22", config)
+	assert.Nil(t, err)
+	if conn != nil {
+		_ = conn.Close()
+	}
+}
+
+func TestKnownHostAlgorithmsRSA(t *testing.T) {
+	defer useTempHostKeys(t)()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+	key, err := ssh.NewPublicKey(&rsaKey.PublicKey)
+	assert.Nil(t, err)
+	assert.Nil(t, addKnownHost("gitlab.com:22", key))
+	assert.Nil(t, addKnownHost("gitlab.com:22", newHostKey(t)))
+	// RSA keys are asked for with SHA-2 signatures first
+	assert.Equal(t, []string{"rsa-sha2-512", "rsa-sha2-256", "ssh-rsa", "ssh-ed25519"}, knownHostAlgorithms("gitlab.com:22"))
+}
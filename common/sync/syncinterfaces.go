@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"github.com/go-git/go-git/v5"
 	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/go-git/go-git/v5/plumbing/object"
 	"github.com/go-git/go-git/v5/plumbing/transport"
 	"os"
@@ -18,6 +19,8 @@ type SyncOps interface {
 	Status(w *git.Worktree) (git.Status, error)
 	AddFile(w *git.Worktree, file string) error
 	Commit(w *git.Worktree) error
+	// CommitMerge commits the index as the merge of HEAD and theirs
+	CommitMerge(w *git.Worktree, r *git.Repository, theirs plumbing.Hash, msg string) error
 	Push(auth transport.AuthMethod, r *git.Repository) error
 	Clone(repoUrl, localRepoPath string, auth transport.AuthMethod) (*git.Repository, error)
 	GetWorktree(r *git.Repository) (*git.Worktree, error)
@@ -86,6 +89,24 @@ func (s *Sync) Commit(w *git.Worktree) error {
 	return err
 }
 
+func (s *Sync) CommitMerge(w *git.Worktree, r *git.Repository, theirs plumbing.Hash, msg string) error {
+	head, err := r.Head()
+	if err != nil {
+		return err
+	}
+	name, email := getUserConfig()
+	commitOptions := &git.CommitOptions{
+		Author: &object.Signature{
+			When:  time.Now(),
+			Name:  name,
+			Email: email,
+		},
+		Parents: []plumbing.Hash{head.Hash(), theirs},
+	}
+	_, err = w.Commit(msg, commitOptions)
+	return err
+}
+
 func (s *Sync) Push(auth transport.AuthMethod, r *git.Repository) error {
 	fmt.Println("Pushing local changes")
 	pushOptions := &git.PushOptions{
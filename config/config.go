@@ -3,29 +3,96 @@ package config
 import (
 	"fmt"
 	"github.com/pkg/errors"
-	"github.com/spf13/cast"
-	"github.com/spf13/viper"
 	"if0/common"
 	"os"
+	"path/filepath"
 	"strings"
 )
 
-// SetEnvVariable sets a config variable
+// SetEnvVariable sets a config variable in the current process and in if0.env
 func SetEnvVariable(key, value string) {
-	key = strings.ToUpper(strings.TrimSpace(key))
+	key = normalizeKey(key)
 	value = strings.TrimSpace(value)
 	err := os.Setenv(key, value)
 	if err != nil {
 		fmt.Printf("Error: Setting env variable %s - %s\n", key, err)
 	}
-	writeToIf0(key, value)
+	err = SetConfigValue(common.If0Default, key, value)
+	if err != nil {
+		fmt.Printf("Error: Setting %s in if0.env - %s\n", key, err)
+	}
+}
+
+// GetConfigFile returns the configuration file addressed by an environment name and a file name.
+// Without an environment, the running configuration if0.env is returned.
+// For an environment, fileName defaults to zero.env and must be a plain .env file name
+// inside the environment directory, for example: GetConfigFile("gitlab.com/group/env", "dash1.env")
+func GetConfigFile(envName, fileName string) (string, error) {
+	if envName == "" {
+		if fileName != "" {
+			return "", errors.New("a configuration file can only be selected together with an environment")
+		}
+		return common.If0Default, nil
+	}
+	if fileName == "" {
+		fileName = common.ZeroEnvFile
+	}
+	if fileName != filepath.Base(fileName) || filepath.Ext(fileName) != ".env" {
+		return "", fmt.Errorf("invalid configuration file name %s, expected a .env file name", fileName)
+	}
+	envDir := filepath.Join(common.EnvDir, envName)
+	if info, err := os.Stat(envDir); err != nil || !info.IsDir() {
+		return "", fmt.Errorf("environment %s not found at %s", envName, envDir)
+	}
+	return filepath.Join(envDir, fileName), nil
+}
+
+// GetConfigValue reads the value of key from the configuration file
+func GetConfigValue(configFile, key string) (string, error) {
+	doc, err := ReadDocument(configFile)
+	if err != nil {
+		return "", err
+	}
+	key = normalizeKey(key)
+	val, ok := doc.Get(key)
+	if !ok {
+		return "", errNotSet(key, configFile)
+	}
+	return val, nil
+}
+
+// SetConfigValue sets key in the configuration file, leaving every other line of the file untouched.
+// The file is created if it does not exist.
+func SetConfigValue(configFile, key, value string) error {
+	return NewStore(configFile).Set(key, value)
+}
+
+// UnsetConfigValue removes key from the configuration file, leaving every other line of the file untouched
+func UnsetConfigValue(configFile, key string) error {
+	return NewStore(configFile).Unset(key)
+}
+
+func errNotSet(key, configFile string) error {
+	return fmt.Errorf("%s is not set in %s", key, configFile)
+}
+
+// PrintConfigFile prints the key-value pairs of a configuration file in the order they are defined
+func PrintConfigFile(configFile string) error {
+	doc, err := ReadDocument(configFile)
+	if err != nil {
+		return err
+	}
+	for _, key := range doc.Keys() {
+		val, _ := doc.Get(key)
+		fmt.Println(key + "=" + val)
+	}
+	return nil
 }
 
-// GetEnvVariable retrieves the value of a config variable
+// GetEnvVariable retrieves the value of a config variable from the process environment,
+// or from the configuration file last read by ReadConfigFile
 func GetEnvVariable(key string) string {
-	viper.AutomaticEnv()
-	val := viper.Get(key)
-	return cast.ToString(val)
+	return runningStore().Get(key)
 }
 
 // PrintCurrentRunningConfig reads the current running if0/env configuration file and prints it
@@ -42,9 +109,9 @@ func PrintCurrentRunningConfig() {
 	if err != nil {
 		return
 	}
-	ReadConfigFile(common.If0Default)
-	for key, val := range viper.AllSettings() {
-		fmt.Println(strings.ToUpper(key)+"="+val.(string))
+	err = PrintConfigFile(common.If0Default)
+	if err != nil {
+		fmt.Println("Error: Reading if0.env - ", err)
 	}
 }
 
@@ -54,6 +121,12 @@ func PrintCurrentRunningConfig() {
 // and then proceeds to replace the current running config file
 func AddConfigFile(srcConfigFile string) error {
 	runningConfigFile := common.If0Default
+	unlock, err := LockConfig()
+	if err != nil {
+		fmt.Println("Error: Add/update the config file - ", err)
+		return err
+	}
+	defer unlock()
 	// taking a backup of the running configuration if already present
 	present := isFilePresent(runningConfigFile)
 	if present {
@@ -63,7 +136,7 @@ func AddConfigFile(srcConfigFile string) error {
 			return err
 		}
 	}
-	err := createConfigFile(srcConfigFile, runningConfigFile)
+	err = createConfigFile(srcConfigFile, runningConfigFile)
 	if err != nil {
 		fmt.Println("Error: Creating config file - ", err)
 		return err
@@ -72,46 +145,51 @@ func AddConfigFile(srcConfigFile string) error {
 }
 
 // MergeConfigFiles merges configuration at dst with configuration from source.
-// it first gets a valid dst file path to be merged with
-// if the file is present, the dst file is backed-up in the .snapshots directory
+// dst defaults to the running configuration if0.env, in which case src has to be a valid if0 configuration.
+// if the dst file is present, it is backed-up in the .snapshots directory
 // and then merged with the src config file
 func MergeConfigFiles(src, dst string) error {
 	if src == "" {
 		return errors.New("Please provide valid source/destination configuration files for merge.")
 	}
-	dst = common.If0Default
-	srcValid, err := IsConfigFileValid(src)
-	if !srcValid {
-		fmt.Println("Please provide a valid configuration file for merge.")
-		return err
+	if dst == "" {
+		dst = common.If0Default
 	}
-	if isFilePresent(dst) {
-		err = backupToSnapshots(dst)
-		if err != nil {
-			fmt.Println("Error: Config file backup - ", err)
+	if dst == common.If0Default {
+		srcValid, err := IsConfigFileValid(src)
+		if !srcValid {
+			fmt.Println("Please provide a valid configuration file for merge.")
 			return err
 		}
-		mergeConfigFiles(src, dst)
-	} else {
+	}
+	if !isFilePresent(dst) {
 		fmt.Println("Destination configuration file not found for merge. " +
 			"Please provide a valid destination file")
+		return fmt.Errorf("destination configuration file %s not found", dst)
+	}
+	unlock, err := LockConfig()
+	if err != nil {
+		fmt.Println("Error: Merging config files - ", err)
 		return err
 	}
-	return nil
+	defer unlock()
+	err = backupToSnapshots(dst)
+	if err != nil {
+		fmt.Println("Error: Config file backup - ", err)
+		return err
+	}
+	return mergeConfigFiles(src, dst)
 }
 
 // IsConfigFileValid checks if the provided configuration file is valid for the config add/update operation.
 // valid if0.env files contain IF0_VERSION key
 func IsConfigFileValid(configFile string) (bool, error) {
-	// read IF0_VERSION
-	viper.SetConfigFile(configFile)
-	err := viper.ReadInConfig()
+	doc, err := ReadDocument(configFile)
 	if err != nil {
 		fmt.Println("Error: Reading config file: ", err)
+		return false, errors.New("no valid IF0_VERSION found in the config file")
 	}
-	if0Version := viper.IsSet(common.IF0_VERSION)
-
-	if !if0Version {
+	if !doc.Has(common.IF0_VERSION) {
 		return false, errors.New("no valid IF0_VERSION found in the config file")
 	}
 	return true, nil
@@ -1,14 +1,14 @@
 package config
 
 import (
-	"fmt"
-	"github.com/spf13/viper"
 	"github.com/stretchr/testify/assert"
 	"if0/common"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strconv"
 	"testing"
+	"time"
 )
 
 func TestPrintCurrentRunningConfigNoDefaultConfig(t *testing.T) {
@@ -19,7 +19,7 @@ func TestPrintCurrentRunningConfigNoDefaultConfig(t *testing.T) {
 	_ = os.RemoveAll(common.If0Dir)
 	PrintCurrentRunningConfig()
 	ReadConfigFile(common.If0Default)
-	assert.Equal(t, "1", GetEnvVariable("IF0_VERSION"))
+	assert.Equal(t, strconv.Itoa(CurrentVersion()), GetEnvVariable("IF0_VERSION"))
 	assert.Equal(t, "https://gitlab.com/peter.saarland/shipmate/-/raw/master/shipmate.gitlab-ci.yml",
 		GetEnvVariable("SHIPMATE_WORKFLOW_URL"))
 }
@@ -30,7 +30,7 @@ func TestPrintCurrentRunningConfigWithDefaultConfig(t *testing.T) {
 	common.DefaultEnvFile = filepath.Join("defenv", "defaultIf0.env")
 	PrintCurrentRunningConfig()
 	ReadConfigFile(common.If0Default)
-	assert.Equal(t, "1", GetEnvVariable("IF0_VERSION"))
+	assert.Equal(t, strconv.Itoa(CurrentVersion()), GetEnvVariable("IF0_VERSION"))
 	assert.Equal(t, "https://gitlab.com/peter.saarland/shipmate/-/raw/master/shipmate.gitlab-ci.yml",
 		GetEnvVariable("SHIPMATE_WORKFLOW_URL"))
 }
@@ -42,10 +42,9 @@ func TestAddConfigFileReplace(t *testing.T) {
 	testConfig := "config.env"
 	_ = ioutil.WriteFile(testConfig, []byte("testkey1=testval1"), 0644)
 	AddConfigFile(testConfig)
-	ReadConfigFile(common.If0Default)
-	configMap := viper.AllSettings()
-	assert.Equal(t, 1, len(configMap))
-	assert.Equal(t, "testval1", configMap["testkey1"])
+	store, _ := LoadStore(common.If0Default)
+	assert.Equal(t, 1, len(store.Keys()))
+	assert.Equal(t, "testval1", store.Get("testkey1"))
 }
 
 func TestMergeConfigFiles(t *testing.T) {
@@ -55,11 +54,10 @@ func TestMergeConfigFiles(t *testing.T) {
 	testConfig := "config2.env"
 	_ = ioutil.WriteFile(testConfig, []byte("testkey2=testval2\nIF0_VERSION=1"), 0644)
 	_ = MergeConfigFiles(testConfig, common.If0Default)
-	ReadConfigFile(common.If0Default)
-	configMap := viper.AllSettings()
-	assert.Equal(t, 3, len(configMap))
-	assert.Equal(t, "testval1", configMap["testkey1"])
-	assert.Equal(t, "testval2", configMap["testkey2"])
+	store, _ := LoadStore(common.If0Default)
+	assert.Equal(t, 3, len(store.Keys()))
+	assert.Equal(t, "testval1", store.Get("testkey1"))
+	assert.Equal(t, "testval2", store.Get("testkey2"))
 	_ = os.Remove(testConfig)
 }
 
@@ -70,10 +68,9 @@ func TestAddConfigFileEnvironment(t *testing.T) {
 	testConfig := "zero1.env"
 	_ = ioutil.WriteFile(testConfig, []byte("zerokey1=zeroval1"), 0644)
 	AddConfigFile(testConfig)
-	ReadConfigFile(filepath.Join(common.EnvDir, testConfig))
-	configMap := viper.AllSettings()
-	assert.Equal(t, 1, len(configMap))
-	assert.Equal(t, "zeroval1", configMap["zerokey1"])
+	store, _ := LoadStore(common.If0Default)
+	assert.Equal(t, 1, len(store.Keys()))
+	assert.Equal(t, "zeroval1", store.Get("zerokey1"))
 	_ = os.Remove(testConfig)
 }
 
@@ -90,7 +87,6 @@ func TestMergeConfigFilesInvalid(t *testing.T) {
 func TestSetEnvVariable(t *testing.T) {
 	SetEnvVariable("test", "val")
 	val := GetEnvVariable("test")
-	fmt.Println(viper.AllSettings())
 	assert.Equal(t, "val", val)
 }
 
@@ -175,9 +171,10 @@ func TestParseGcAutoStr(t *testing.T) {
 }
 
 func TestWriteDefaultIf0ConfigNone(t *testing.T) {
+	common.If0Dir = "testdata"
 	common.If0Default = filepath.Join("testdata", "if0.env")
 	defFile := filepath.Join("testdata", "testDefEnv.env")
-	ioutil.WriteFile(defFile, []byte("IF0_VERSION=1\nTESTIF0=YES\n"), 0644)
+	ioutil.WriteFile(defFile, []byte("IF0_VERSION="+strconv.Itoa(CurrentVersion())+"\nTESTIF0=YES\n"), 0644)
 	os.Remove(common.If0Default)
 	err := writeDefaultIf0Config(defFile)
 	assert.Nil(t, err)
@@ -199,3 +196,129 @@ func TestWriteDefaultIf0ConfigAppend(t *testing.T) {
 	ioutil.WriteFile(defFile, []byte("IF0_VERSION=1\nTESTIF0=YES\n"), 0644)
 	os.Remove(common.If0Default)
 }
+
+func TestSetEnvVariableKeepsOtherKeys(t *testing.T) {
+	common.If0Default = filepath.Join("testdata", "if0.env")
+	_ = ioutil.WriteFile(common.If0Default, []byte("# comment\nIF0_VERSION=1\nGL_TOKEN=\"\"\n"), 0644)
+	SetEnvVariable("gl_token", "secret")
+	SetEnvVariable("GC_AUTO", "no")
+	content, _ := ioutil.ReadFile(common.If0Default)
+	assert.Equal(t, "# comment\nIF0_VERSION=1\nGL_TOKEN=\"secret\"\nGC_AUTO=no\n", string(content))
+	_ = os.Remove(common.If0Default)
+}
+
+func TestGetConfigFile(t *testing.T) {
+	common.If0Default = filepath.Join("testdata", "if0.env")
+	common.EnvDir = filepath.Join("testdata", ".environments")
+	envDir := filepath.Join(common.EnvDir, "gitlab.com", "group", "env")
+	_ = os.MkdirAll(envDir, 0755)
+	defer os.RemoveAll(common.EnvDir)
+
+	file, err := GetConfigFile("", "")
+	assert.Nil(t, err)
+	assert.Equal(t, common.If0Default, file)
+	file, err = GetConfigFile("gitlab.com/group/env", "")
+	assert.Nil(t, err)
+	assert.Equal(t, filepath.Join(envDir, "zero.env"), file)
+	file, err = GetConfigFile("gitlab.com/group/env", "dash1.env")
+	assert.Nil(t, err)
+	assert.Equal(t, filepath.Join(envDir, "dash1.env"), file)
+
+	_, err = GetConfigFile("", "zero.env")
+	assert.Error(t, err)
+	_, err = GetConfigFile("gitlab.com/group/env", "../../if0.env")
+	assert.Error(t, err)
+	_, err = GetConfigFile("missing-env", "")
+	assert.Error(t, err)
+}
+
+func TestScopedConfigValues(t *testing.T) {
+	common.EnvDir = filepath.Join("testdata", ".environments")
+	envDir := filepath.Join(common.EnvDir, "env")
+	_ = os.MkdirAll(envDir, 0755)
+	defer os.RemoveAll(common.EnvDir)
+	zeroFile := filepath.Join(envDir, "zero.env")
+	_ = ioutil.WriteFile(zeroFile, []byte("IF0_ENVIRONMENT=env\n# admin\nZERO_ADMIN_USER=admin\n"), 0644)
+
+	err := SetConfigValue(zeroFile, "zero_base_domain", "example.com")
+	assert.Nil(t, err)
+	val, err := GetConfigValue(zeroFile, "ZERO_BASE_DOMAIN")
+	assert.Nil(t, err)
+	assert.Equal(t, "example.com", val)
+
+	err = UnsetConfigValue(zeroFile, "ZERO_ADMIN_USER")
+	assert.Nil(t, err)
+	err = UnsetConfigValue(zeroFile, "ZERO_ADMIN_USER")
+	assert.EqualError(t, err, "ZERO_ADMIN_USER is not set in "+zeroFile)
+	_, err = GetConfigValue(zeroFile, "ZERO_ADMIN_USER")
+	assert.Error(t, err)
+
+	content, _ := ioutil.ReadFile(zeroFile)
+	assert.Equal(t, "IF0_ENVIRONMENT=env\n# admin\nZERO_BASE_DOMAIN=example.com\n", string(content))
+}
+
+func TestMergeConfigFilesEnvironment(t *testing.T) {
+	common.If0Dir = "testdata"
+	common.SnapshotsDir = filepath.Join("testdata", ".snapshots")
+	dstFile := filepath.Join("testdata", "zero.env")
+	srcFile := filepath.Join("testdata", "zero-src.env")
+	_ = ioutil.WriteFile(dstFile, []byte("A=1\nB=2\n"), 0644)
+	_ = ioutil.WriteFile(srcFile, []byte("B=3\n"), 0644)
+	defer os.RemoveAll(common.SnapshotsDir)
+	defer os.Remove(filepath.Join("testdata", lockFileName))
+	defer os.Remove(dstFile)
+	defer os.Remove(srcFile)
+
+	err := MergeConfigFiles(srcFile, dstFile)
+	assert.Nil(t, err)
+	content, _ := ioutil.ReadFile(dstFile)
+	assert.Equal(t, "A=1\nB=3\n", string(content))
+}
+
+func TestParseGcPolicy(t *testing.T) {
+	policy, err := ParseGcPolicy("yes", "", "", "")
+	assert.Nil(t, err)
+	assert.Equal(t, &GcPolicy{Auto: true, Period: 30, KeepLast: -1, MaxSize: -1}, policy)
+	policy, err = ParseGcPolicy("", "7", "5", "10MB")
+	assert.Nil(t, err)
+	assert.Equal(t, &GcPolicy{Period: 7, KeepLast: 5, MaxSize: 10 << 20}, policy)
+	policy, err = ParseGcPolicy("true", "", "3", "")
+	assert.Nil(t, err)
+	assert.Equal(t, -1, policy.Period)
+
+	_, err = ParseGcPolicy("maybe", "", "", "")
+	assert.Error(t, err)
+	_, err = ParseGcPolicy("yes", "thirty", "", "")
+	assert.EqualError(t, err, `invalid GC_PERIOD "thirty": expected a non-negative number`)
+	_, err = ParseGcPolicy("yes", "", "-1", "")
+	assert.Error(t, err)
+	_, err = ParseGcPolicy("yes", "", "", "10XB")
+	assert.Error(t, err)
+}
+
+func TestGcPolicySelect(t *testing.T) {
+	now := time.Now()
+	day := 24 * time.Hour
+	snapshot := func(id, source string, age time.Duration, size int64) *Snapshot {
+		return &Snapshot{ID: id, Source: source, Created: now.Add(-age), Size: size}
+	}
+	a1 := snapshot("a1", "a.env", 40*day, 10)
+	a2 := snapshot("a2", "a.env", 20*day, 10)
+	a3 := snapshot("a3", "a.env", 1*day, 10)
+	b1 := snapshot("b1", "b.env", 50*day, 10)
+	pinned := snapshot("p", "a.env", 60*day, 10)
+	pinned.Pinned = true
+	snapshots := []*Snapshot{a3, b1, a1, pinned, a2}
+
+	ids := func(selected []*Snapshot) []string {
+		var ids []string
+		for _, s := range selected {
+			ids = append(ids, s.ID)
+		}
+		return ids
+	}
+	assert.Equal(t, []string{"b1", "a1"}, ids((&GcPolicy{Period: 30, KeepLast: -1, MaxSize: -1}).Select(snapshots, now)))
+	assert.Equal(t, []string{"a1", "a2"}, ids((&GcPolicy{Period: -1, KeepLast: 1, MaxSize: -1}).Select(snapshots, now)))
+	assert.Equal(t, []string{"a1"}, ids((&GcPolicy{Period: 30, KeepLast: 1, MaxSize: -1}).Select(snapshots, now)))
+	assert.Equal(t, []string{"b1", "a1", "a2"}, ids((&GcPolicy{Period: -1, KeepLast: -1, MaxSize: 20}).Select(snapshots, now)))
+}
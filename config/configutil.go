@@ -3,97 +3,72 @@ package config
 import (
 	"fmt"
 	"github.com/pkg/errors"
-	"github.com/spf13/viper"
 	"if0/common"
-	"io/ioutil"
 	"os"
-	"path/filepath"
+	"strconv"
 	"strings"
-	"time"
+	"sync"
 )
 
-// getDstFileForMerge returns dst filepath based on zero flag.
-// for zero = true, if dst is not provided, dst file name is set to src file name,
-// and looked-up in the .environments directory
-// if zero is set to false, the src and dst files are the same - if0.env
-func getDstFileForMerge(src string, dst string, zero bool) string {
-	// setting dst path for zero configuration files
-	if zero {
-		if dst == "" {
-			dst = filepath.Join(common.EnvDir, filepath.Base(src))
-		} else {
-			dst = filepath.Join(common.EnvDir, filepath.Base(dst))
-		}
-	} else {
-		dst = common.If0Default
-	}
-	return dst
-}
-
 // mergeConfigFiles combines configuration from source .env file with configuration in the destination .env file
-// For config keys that are already present, the values are updated from source .env file
-func mergeConfigFiles(srcConfigFile, dstConfigFile string) {
-	ReadConfigFile(dstConfigFile)
-	currentConfigMap := viper.AllSettings()
-	ReadConfigFile(srcConfigFile)
-	newConfigMap := viper.AllSettings()
-	for k, v := range newConfigMap {
-		currentConfigMap[k] = v
-	}
-	writeToConfigFile(dstConfigFile, currentConfigMap)
-}
-
-// writeToConfigFile is used to merge config files.
-// key-value pairs from currentConfigMap written to the current running config file
-func writeToConfigFile(runningConfigFile string, currentConfigMap map[string]interface{}) {
-	var lines []string
-	for key, val := range currentConfigMap {
-		lines = append(lines, fmt.Sprintf("%v=%v", strings.ToUpper(key), val))
-	}
-	s := strings.Join(lines, "\n")
-	err := ioutil.WriteFile(runningConfigFile, []byte(s), 0644)
+// For config keys that are already present, the values are updated from source .env file.
+// Comments, ordering and quoting of the destination file are preserved.
+func mergeConfigFiles(srcConfigFile, dstConfigFile string) error {
+	srcDoc, err := ReadDocument(srcConfigFile)
+	if err != nil {
+		fmt.Println("Error: Reading config file - ", err)
+		return err
+	}
+	dstDoc, err := ReadDocument(dstConfigFile)
+	if err != nil {
+		fmt.Println("Error: Reading config file - ", err)
+		return err
+	}
+	dstDoc.Merge(srcDoc)
+	err = dstDoc.WriteFile(dstConfigFile, 0644)
 	if err != nil {
 		fmt.Println("Error: Merging config files - ", err)
-		return
+		return err
 	}
+	return nil
 }
 
-// getRunningConfigFile returns the configuration file to be backed-up and updated
-// if the zero flag is set to true, configuration from .environments directory is set as the running config file
-// if the zero flag is set to false, if0.env is set as the running configuration file
-func getRunningConfigFile(srcConfigFile string, zero bool) string {
-	var runningConfigFile string
-	if zero {
-		// creating a .environments directory to store zero-cluster configurations, if it does not exist.
-		if _, err := os.Stat(common.EnvDir); os.IsNotExist(err) {
-			fmt.Println("Directory does not exist, creating dir for snapshots")
-			_ = os.Mkdir(common.EnvDir, os.ModeDir)
-		}
-		// setting configuration file path to update zero-cluster configuration
-		fmt.Println("Updating zero cluster configuration with ", srcConfigFile)
-		runningConfigFile = filepath.Join(common.EnvDir, filepath.Base(srcConfigFile))
-	} else {
-		// setting configuration file path to update if0.env configuration
-		fmt.Println("Updating if0.env configuration with ", srcConfigFile)
-		runningConfigFile = common.If0Default
-	}
-	return runningConfigFile
-}
+// running is the store of the configuration file read by ReadConfigFile
+var (
+	running     *Store
+	runningLock sync.Mutex
+)
 
-// ReadConfigFile reads the provided config file
+// ReadConfigFile reads the provided config file, its values are returned by GetEnvVariable
 func ReadConfigFile(configFile string) {
-	viper.SetConfigFile(configFile)
-	err := viper.ReadInConfig()
+	store, err := LoadStore(configFile)
 	if err != nil {
 		fmt.Println("Error: while reading config file - ", err)
-		return
 	}
+	runningLock.Lock()
+	running = store
+	runningLock.Unlock()
+}
+
+// runningStore returns the store read by ReadConfigFile,
+// before a file has been read only the process environment is consulted
+func runningStore() *Store {
+	runningLock.Lock()
+	defer runningLock.Unlock()
+	if running == nil {
+		return NewStore("")
+	}
+	return running
 }
 
 // createConfigFile creates a new running config file from the provided config file (src)
 func createConfigFile(srcConfigFile, runningConfigFile string) error {
-	ReadConfigFile(srcConfigFile)
-	err := viper.WriteConfigAs(runningConfigFile)
+	doc, err := ReadDocument(srcConfigFile)
+	if err != nil {
+		fmt.Println("Error: Reading config file - ", err)
+		return err
+	}
+	err = doc.WriteFile(runningConfigFile, 0644)
 	if err != nil {
 		fmt.Println("Error: Failed to add/update the config file - ", err)
 		return err
@@ -112,17 +87,10 @@ func isFilePresent(fileName string) bool {
 
 // backupToSnapshots takes a backup of the current running-config.env file, say if0.env
 // it creates a copy of if0.env file, with timestamp attached to the filename
-// and stores it in the ~if0/.snapshots directory
-// example: if0-02042020_170240.env
+// and stores it in the ~if0/.snapshots directory together with the path of the source file
+// example: if0-02042020_170240.env, if0-02042020_170240.json
 func backupToSnapshots(fileName string) error {
-	if _, err := os.Stat(common.SnapshotsDir); os.IsNotExist(err) {
-		fmt.Println("Directory does not exist, creating dir for snapshots")
-		_ = os.Mkdir(common.SnapshotsDir, os.ModeDir)
-	}
-	timestamp := string(time.Now().Format("02012006_150405"))
-	bkpFile := filepath.Join(common.SnapshotsDir, strings.Split(filepath.Base(fileName), ".")[0]+"-"+timestamp+".env")
-	ReadConfigFile(fileName)
-	err := viper.WriteConfigAs(bkpFile)
+	_, err := TakeSnapshot(fileName)
 	if err != nil {
 		fmt.Println("Error: while writing to backup file - ", err)
 		return errors.New("backup of previous config failed")
@@ -131,34 +99,78 @@ func backupToSnapshots(fileName string) error {
 }
 
 // writeDefaultIf0Config creates an if0.env file if not present at ~/.if0/
-// and copies the contents of defenv/defaultIf0.env to if0.env.
-// If if0.env is present at ~/.if0/, it appends the new contents from defenv/defaultIf0.env to if0.env
+// and copies the contents of defenv/defaultIf0.env to if0.env, with IF0_VERSION set to the current version.
+// If if0.env is present at ~/.if0/, the keys from defenv/defaultIf0.env that are missing in if0.env
+// are appended to it, values already set by the user are left untouched.
+// if0.env and the environments are then migrated to the current version.
 // This requires the user to run 'if0 config'
 func writeDefaultIf0Config(defaultEnvFile string) error {
-	defEnvBytes, err := ioutil.ReadFile(defaultEnvFile)
+	defDoc, err := ReadDocument(defaultEnvFile)
 	if err != nil {
 		fmt.Println("Error: Reading default .env file - ", err)
 		return err
 	}
+	err = withConfigLock(func() error {
+		return mergeDefaultIf0Config(defDoc)
+	})
+	if err != nil {
+		return err
+	}
+	return migrateConfig()
+}
 
+// mergeDefaultIf0Config writes the default configuration to if0.env, or merges the missing keys into it
+func mergeDefaultIf0Config(defDoc *Document) error {
 	if _, err := os.Stat(common.If0Default); os.IsNotExist(err) {
 		fmt.Println("if0.env does not exist, creating ", common.If0Default)
-		err = ioutil.WriteFile(common.If0Default, defEnvBytes, 0644)
+		defDoc.Set(common.IF0_VERSION, strconv.Itoa(CurrentVersion()))
+		err = defDoc.WriteFile(common.If0Default, 0644)
 		if err != nil {
 			fmt.Println("Error: Writing to if0.env file - ", err)
 			return err
 		}
-	} else {
-		mergeConfigFiles(defaultEnvFile, common.If0Default)
+		return nil
+	}
+
+	if0Doc, err := ReadDocument(common.If0Default)
+	if err != nil {
+		fmt.Println("Error: Reading if0.env file - ", err)
+		return err
+	}
+	// a missing IF0_VERSION means the file predates versioning, it is migrated from the base version
+	defDoc.Set(common.IF0_VERSION, strconv.Itoa(baseVersion))
+	if0Doc.MergeDefaults(defDoc)
+	err = if0Doc.WriteFile(common.If0Default, 0644)
+	if err != nil {
+		fmt.Println("Error: Writing to if0.env file - ", err)
+		return err
 	}
 	return nil
 }
 
-func writeToIf0(key, value string) {
-	s := key + "=" + value + "\n"
-	err := ioutil.WriteFile(common.If0Default, []byte(s), 0644)
+// migrateConfig upgrades if0.env and the environments to the current version
+func migrateConfig() error {
+	changes, err := Migrate(false)
 	if err != nil {
-		fmt.Printf("Error: Setting %s in if0.env -%s\n", key, err)
-		return
+		fmt.Println("Error: Migrating configuration - ", err)
+		return err
+	}
+	for _, c := range changes {
+		fmt.Printf("Migrated %s to version %d: %s\n", c.File, c.Version, c.Step)
+	}
+	return nil
+}
+
+// normalizeKey returns the key in the form it is stored in .env files
+func normalizeKey(key string) string {
+	return strings.ToUpper(strings.TrimSpace(key))
+}
+
+// readOrCreateDocument reads the .env file at path, an empty document is returned if the file does not exist
+func readOrCreateDocument(path string) (*Document, error) {
+	doc, err := ReadDocument(path)
+	if os.IsNotExist(err) {
+		return NewDocument(), nil
 	}
+	return doc, err
 }
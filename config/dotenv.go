@@ -0,0 +1,348 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// Document is an in-memory representation of a .env file.
+// Unlike viper, it keeps comments, blank lines, key order, quoting and
+// `$$` escapes, so that lines which are not modified are rendered byte-for-byte.
+type Document struct {
+	lines           []*envLine
+	crlf            bool
+	trailingNewline bool
+}
+
+// envLine is a single line of a .env file.
+// Lines that do not hold a KEY=VALUE pair (comments, blank lines, garbage) only carry raw.
+type envLine struct {
+	raw     string
+	key     string
+	value   string
+	quote   byte
+	export  bool
+	comment string
+}
+
+// NewDocument returns an empty .env document
+func NewDocument() *Document {
+	return &Document{trailingNewline: true}
+}
+
+// ParseDocument parses the contents of a .env file
+func ParseDocument(data []byte) *Document {
+	doc := NewDocument()
+	content := string(data)
+	if content == "" {
+		return doc
+	}
+	doc.crlf = strings.Contains(content, "\r\n")
+	doc.trailingNewline = strings.HasSuffix(content, "\n")
+	content = strings.TrimSuffix(content, "\n")
+	for _, raw := range strings.Split(content, "\n") {
+		if doc.crlf {
+			raw = strings.TrimSuffix(raw, "\r")
+		}
+		doc.lines = append(doc.lines, parseEnvLine(raw))
+	}
+	return doc
+}
+
+// ReadDocument reads and parses the .env file at path
+func ReadDocument(path string) (*Document, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseDocument(data), nil
+}
+
+// parseEnvLine splits a line into key, value, quote and trailing comment.
+// values are kept as written, except for `\"` and `\\` in double quotes: escapes such as `$$` are not interpreted.
+func parseEnvLine(raw string) *envLine {
+	l := &envLine{raw: raw}
+	s := strings.TrimSpace(raw)
+	if s == "" || strings.HasPrefix(s, "#") {
+		return l
+	}
+	if strings.HasPrefix(s, "export ") {
+		l.export = true
+		s = strings.TrimSpace(strings.TrimPrefix(s, "export "))
+	}
+	i := strings.Index(s, "=")
+	if i < 1 {
+		return l
+	}
+	key := strings.TrimSpace(s[:i])
+	if !isValidKey(key) {
+		return l
+	}
+	rest := strings.TrimLeft(s[i+1:], " \t")
+	if rest != "" && (rest[0] == '"' || rest[0] == '\'') {
+		end := closingQuote(rest, rest[0])
+		if end > 0 {
+			l.quote = rest[0]
+			l.value = rest[1:end]
+			if l.quote == '"' {
+				l.value = unescapeDoubleQuoted.Replace(l.value)
+			}
+			l.comment = rest[end+1:]
+			l.key = key
+			return l
+		}
+	}
+	value := rest
+	if j := strings.Index(rest, " #"); j >= 0 {
+		value = rest[:j]
+		l.comment = rest[j:]
+	}
+	l.key = key
+	l.value = strings.TrimSpace(value)
+	return l
+}
+
+// closingQuote returns the index of the quote closing s[0], or -1 if the quote is not closed
+func closingQuote(s string, quote byte) int {
+	for i := 1; i < len(s); i++ {
+		if s[i] == '\\' && quote == '"' {
+			i++
+			continue
+		}
+		if s[i] == quote {
+			return i
+		}
+	}
+	return -1
+}
+
+func isValidKey(key string) bool {
+	for i, r := range key {
+		switch {
+		case r == '_', r == '.', r >= 'A' && r <= 'Z', r >= 'a' && r <= 'z':
+		case r >= '0' && r <= '9' && i > 0:
+		default:
+			return false
+		}
+	}
+	return key != ""
+}
+
+// Get returns the value of key. If a key is defined more than once, the last definition wins.
+func (d *Document) Get(key string) (string, bool) {
+	l := d.find(key)
+	if l == nil {
+		return "", false
+	}
+	return l.value, true
+}
+
+// Has reports whether key is defined in the document
+func (d *Document) Has(key string) bool {
+	return d.find(key) != nil
+}
+
+// Keys returns the keys defined in the document, in the order of their first definition
+func (d *Document) Keys() []string {
+	var keys []string
+	seen := make(map[string]bool)
+	for _, l := range d.lines {
+		if l.key != "" && !seen[l.key] {
+			seen[l.key] = true
+			keys = append(keys, l.key)
+		}
+	}
+	return keys
+}
+
+// Map returns all key-value pairs of the document
+func (d *Document) Map() map[string]string {
+	m := make(map[string]string)
+	for _, l := range d.lines {
+		if l.key != "" {
+			m[l.key] = l.value
+		}
+	}
+	return m
+}
+
+// Set updates the value of key in place, keeping its quoting and inline comment.
+// New keys are appended to the end of the document.
+func (d *Document) Set(key, value string) {
+	if l := d.find(key); l != nil {
+		if l.value == value {
+			return
+		}
+		l.value = value
+		if l.quote == 0 || strings.IndexByte(value, l.quote) >= 0 {
+			l.quote = quoteFor(value)
+		}
+		l.raw = l.render()
+		return
+	}
+	l := &envLine{key: key, value: value, quote: quoteFor(value)}
+	l.raw = l.render()
+	d.append(l)
+}
+
+// SetDefault sets key to value only if the key is not defined yet.
+// returns true if the key was added.
+func (d *Document) SetDefault(key, value string) bool {
+	if d.Has(key) {
+		return false
+	}
+	d.Set(key, value)
+	return true
+}
+
+// Unset removes every definition of key. returns true if the key was present.
+func (d *Document) Unset(key string) bool {
+	var lines []*envLine
+	removed := false
+	for _, l := range d.lines {
+		if l.key == key {
+			removed = true
+			continue
+		}
+		lines = append(lines, l)
+	}
+	d.lines = lines
+	return removed
+}
+
+// Rename renames every definition of key in place. if newKey is already defined, it is kept
+// and the definitions of key are removed. returns true if the key was present.
+func (d *Document) Rename(key, newKey string) bool {
+	if !d.Has(key) {
+		return false
+	}
+	if d.Has(newKey) {
+		return d.Unset(key)
+	}
+	for _, l := range d.lines {
+		if l.key == key {
+			l.key = newKey
+			l.raw = l.render()
+		}
+	}
+	return true
+}
+
+// Merge copies every key of src into the document.
+// Keys that are already defined keep their position, new keys are appended
+// together with the comments directly preceding them in src.
+func (d *Document) Merge(src *Document) {
+	d.merge(src, nil, true)
+}
+
+// MergeDefaults copies the keys of src that are not yet defined in the document
+func (d *Document) MergeDefaults(src *Document) {
+	d.merge(src, nil, false)
+}
+
+// merge copies the keys of src for which include returns true, all keys if include is nil
+func (d *Document) merge(src *Document, include func(key string) bool, overwrite bool) {
+	var pending []*envLine
+	for _, l := range src.lines {
+		if l.key == "" {
+			if strings.TrimSpace(l.raw) == "" {
+				pending = nil
+			} else {
+				pending = append(pending, l)
+			}
+			continue
+		}
+		if include != nil && !include(l.key) {
+			pending = nil
+			continue
+		}
+		if existing := d.find(l.key); existing != nil {
+			if overwrite {
+				d.Set(l.key, l.value)
+			}
+		} else {
+			for _, c := range pending {
+				d.append(&envLine{raw: c.raw})
+			}
+			d.append(&envLine{raw: l.raw, key: l.key, value: l.value,
+				quote: l.quote, export: l.export, comment: l.comment})
+		}
+		pending = nil
+	}
+}
+
+// Render returns the document in .env file format
+func (d *Document) Render() []byte {
+	newline := "\n"
+	if d.crlf {
+		newline = "\r\n"
+	}
+	var b strings.Builder
+	for i, l := range d.lines {
+		b.WriteString(l.raw)
+		if i < len(d.lines)-1 || d.trailingNewline {
+			b.WriteString(newline)
+		}
+	}
+	return []byte(b.String())
+}
+
+// WriteFile writes the rendered document to path
+func (d *Document) WriteFile(path string, perm os.FileMode) error {
+	return writeFileAtomic(path, d.Render(), perm)
+}
+
+// append adds a line to the end of the document, terminating the previous last line
+func (d *Document) append(l *envLine) {
+	d.lines = append(d.lines, l)
+	d.trailingNewline = true
+}
+
+func (d *Document) find(key string) *envLine {
+	for i := len(d.lines) - 1; i >= 0; i-- {
+		if d.lines[i].key == key {
+			return d.lines[i]
+		}
+	}
+	return nil
+}
+
+func (l *envLine) render() string {
+	var b strings.Builder
+	if l.export {
+		b.WriteString("export ")
+	}
+	b.WriteString(l.key)
+	b.WriteString("=")
+	if l.quote != 0 {
+		b.WriteByte(l.quote)
+		if l.quote == '"' {
+			b.WriteString(escapeDoubleQuoted.Replace(l.value))
+		} else {
+			b.WriteString(l.value)
+		}
+		b.WriteByte(l.quote)
+	} else {
+		b.WriteString(l.value)
+	}
+	b.WriteString(l.comment)
+	return b.String()
+}
+
+// backslashes and double quotes are escaped in values written in double quotes
+var (
+	escapeDoubleQuoted   = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+	unescapeDoubleQuoted = strings.NewReplacer(`\\`, `\`, `\"`, `"`)
+)
+
+// quoteFor returns the quote needed to write value unambiguously, 0 if none is needed
+func quoteFor(value string) byte {
+	if !strings.ContainsAny(value, " \t#\"'") {
+		return 0
+	}
+	if strings.Contains(value, "\"") && !strings.Contains(value, "'") {
+		return '\''
+	}
+	return '"'
+}
@@ -0,0 +1,99 @@
+package config
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+const testEnvContent = `# if0 configuration
+IF0_VERSION=1
+
+# registry
+IF0_REGISTRY_URL="https://gitlab.com" # main instance
+GL_TOKEN=''
+export IF0_REGISTRY_GROUP=vpcs
+ZERO_ADMIN_PASSWORD_HASH=$$2y$$05$$abcdef
+`
+
+func TestParseDocumentGet(t *testing.T) {
+	doc := ParseDocument([]byte(testEnvContent))
+	val, ok := doc.Get("IF0_REGISTRY_URL")
+	assert.True(t, ok)
+	assert.Equal(t, "https://gitlab.com", val)
+	val, _ = doc.Get("IF0_REGISTRY_GROUP")
+	assert.Equal(t, "vpcs", val)
+	val, _ = doc.Get("ZERO_ADMIN_PASSWORD_HASH")
+	assert.Equal(t, "$$2y$$05$$abcdef", val)
+	val, ok = doc.Get("GL_TOKEN")
+	assert.True(t, ok)
+	assert.Equal(t, "", val)
+	_, ok = doc.Get("MISSING")
+	assert.False(t, ok)
+	assert.Equal(t, []string{"IF0_VERSION", "IF0_REGISTRY_URL", "GL_TOKEN",
+		"IF0_REGISTRY_GROUP", "ZERO_ADMIN_PASSWORD_HASH"}, doc.Keys())
+}
+
+func TestDocumentRenderUnchanged(t *testing.T) {
+	doc := ParseDocument([]byte(testEnvContent))
+	assert.Equal(t, testEnvContent, string(doc.Render()))
+	noNewline := "A=1\r\nB=2"
+	assert.Equal(t, noNewline, string(ParseDocument([]byte(noNewline)).Render()))
+}
+
+func TestDocumentSet(t *testing.T) {
+	doc := ParseDocument([]byte(testEnvContent))
+	doc.Set("IF0_REGISTRY_URL", "https://git.example.com")
+	doc.Set("IF0_REGISTRY_GROUP", "customers")
+	doc.Set("NEW_KEY", "some value")
+	expected := `# if0 configuration
+IF0_VERSION=1
+
+# registry
+IF0_REGISTRY_URL="https://git.example.com" # main instance
+GL_TOKEN=''
+export IF0_REGISTRY_GROUP=customers
+ZERO_ADMIN_PASSWORD_HASH=$$2y$$05$$abcdef
+NEW_KEY="some value"
+`
+	assert.Equal(t, expected, string(doc.Render()))
+}
+
+func TestDocumentSetQuotes(t *testing.T) {
+	doc := NewDocument()
+	for _, value := range []string{`it's "quoted"`, `say "hi"`, "it's", `C:\dir\ "x"`, `ends with \`} {
+		doc.Set("VALUE", value)
+		val, _ := ParseDocument(doc.Render()).Get("VALUE")
+		assert.Equal(t, value, val)
+	}
+	doc.Set("VALUE", `it's "quoted"`)
+	assert.Equal(t, `VALUE="it's \"quoted\""`+"\n", string(doc.Render()))
+	val, _ := ParseDocument([]byte(`A="say \"hi\" # not a comment" # comment`)).Get("A")
+	assert.Equal(t, `say "hi" # not a comment`, val)
+}
+
+func TestDocumentSetNoTrailingNewline(t *testing.T) {
+	doc := ParseDocument([]byte("A=1"))
+	doc.Set("B", "2")
+	assert.Equal(t, "A=1\nB=2\n", string(doc.Render()))
+}
+
+func TestDocumentUnset(t *testing.T) {
+	doc := ParseDocument([]byte("A=1\nB=2\nA=3\n"))
+	assert.True(t, doc.Unset("A"))
+	assert.False(t, doc.Unset("A"))
+	assert.Equal(t, "B=2\n", string(doc.Render()))
+}
+
+func TestDocumentMerge(t *testing.T) {
+	dst := ParseDocument([]byte("# mine\nA=1\nB=2\n"))
+	src := ParseDocument([]byte("B=20\n\n# the c key\nC=3\n"))
+	dst.Merge(src)
+	assert.Equal(t, "# mine\nA=1\nB=20\n# the c key\nC=3\n", string(dst.Render()))
+}
+
+func TestDocumentMergeDefaults(t *testing.T) {
+	dst := ParseDocument([]byte("A=1\nB=2\n"))
+	src := ParseDocument([]byte("B=20\nC=3\n"))
+	dst.MergeDefaults(src)
+	assert.Equal(t, "A=1\nB=2\nC=3\n", string(dst.Render()))
+}
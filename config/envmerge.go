@@ -0,0 +1,154 @@
+package config
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Sides of a merge, MergeStrategy resolves all conflicts of a sync in favour of one of them
+const (
+	MergeOurs   = "ours"
+	MergeTheirs = "theirs"
+)
+
+// MergeStrategy is the side conflicting changes of a sync are resolved with, set by --ours and --theirs.
+// if it is empty, conflicts are asked about on a terminal and fail the sync otherwise.
+var MergeStrategy string
+
+// SyncConflict is a key of a .env file changed differently on both sides of a sync. Key is empty if
+// the whole file conflicts: it is not a .env file, or one side removed it while the other changed it.
+type SyncConflict struct {
+	File string
+	Key  string
+	// Ours and Theirs are the values of the key on both sides
+	Ours   string
+	Theirs string
+	// OursRemoved and TheirsRemoved report that a side removed the key or the file
+	OursRemoved   bool
+	TheirsRemoved bool
+}
+
+func (c SyncConflict) String() string {
+	if c.Key == "" {
+		return c.File
+	}
+	if IsEncrypted(c.Ours) || IsEncrypted(c.Theirs) {
+		return c.File + ": " + c.Key + " (encrypted)"
+	}
+	return c.File + ": " + c.Key
+}
+
+// describe returns the change of a side of the conflict
+func (c SyncConflict) describe(value string, removed bool) string {
+	switch {
+	case removed:
+		return "(removed)"
+	case c.Key == "":
+		return "(changed)"
+	case IsEncrypted(value):
+		// neither the ciphertext nor the secret are shown
+		return "(encrypted)"
+	}
+	return value
+}
+
+// ConflictResolver returns the side a conflict is resolved with, MergeOurs or MergeTheirs
+type ConflictResolver func(c SyncConflict) (string, error)
+
+// strategyResolver resolves every conflict with the same side
+func strategyResolver(side string) ConflictResolver {
+	return func(c SyncConflict) (string, error) {
+		fmt.Printf("Resolving %s with %s\n", c, side)
+		return side, nil
+	}
+}
+
+// promptResolver asks which side to keep for every conflict
+func promptResolver(in io.Reader, out io.Writer) ConflictResolver {
+	reader := bufio.NewReader(in)
+	return func(c SyncConflict) (string, error) {
+		_, _ = fmt.Fprintf(out, "Conflicting changes of %s\n", c)
+		_, _ = fmt.Fprintf(out, "  ours:   %s\n", c.describe(c.Ours, c.OursRemoved))
+		_, _ = fmt.Fprintf(out, "  theirs: %s\n", c.describe(c.Theirs, c.TheirsRemoved))
+		for {
+			_, _ = fmt.Fprint(out, "Keep [o]urs or take [t]heirs? ")
+			answer, err := reader.ReadString('\n')
+			switch strings.ToLower(strings.TrimSpace(answer)) {
+			case "o", MergeOurs:
+				return MergeOurs, nil
+			case "t", MergeTheirs:
+				return MergeTheirs, nil
+			}
+			if err != nil {
+				return "", fmt.Errorf("no resolution for %s", c)
+			}
+		}
+	}
+}
+
+// MergeEnv merges the changes of ours and theirs to the .env file base key by key. keys changed on
+// one side only take the value of that side, keys changed differently on both sides are resolved by resolve.
+// ENC[...] values are compared decrypted if id is not nil, encrypting a value again changes its ciphertext.
+// the result keeps the layout of ours, keys added by them are appended with the comments preceding them.
+func MergeEnv(file string, base, ours, theirs *Document, id *Identity, resolve ConflictResolver) (*Document, error) {
+	result := ParseDocument(ours.Render())
+	baseValues, ourValues, theirValues := base.Map(), ours.Map(), theirs.Map()
+	// keys of theirs missing in ours which are added to the result
+	add := make(map[string]bool)
+	seen := make(map[string]bool)
+	for _, key := range append(append(ours.Keys(), theirs.Keys()...), base.Keys()...) {
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		b, inBase := baseValues[key]
+		o, inOurs := ourValues[key]
+		t, inTheirs := theirValues[key]
+		takeTheirs := false
+		switch {
+		case inOurs == inTheirs && sameValue(file, key, o, t, id):
+		case inOurs == inBase && sameValue(file, key, o, b, id):
+			takeTheirs = true
+		case inTheirs == inBase && sameValue(file, key, t, b, id):
+		default:
+			side, err := resolve(SyncConflict{File: file, Key: key, Ours: o, Theirs: t,
+				OursRemoved: !inOurs, TheirsRemoved: !inTheirs})
+			if err != nil {
+				return nil, err
+			}
+			takeTheirs = side == MergeTheirs
+		}
+		switch {
+		case !takeTheirs:
+		case !inTheirs:
+			result.Unset(key)
+		case inOurs:
+			result.Set(key, t)
+		default:
+			add[key] = true
+		}
+	}
+	result.merge(theirs, func(key string) bool {
+		return add[key]
+	}, false)
+	return result, nil
+}
+
+// sameValue reports whether a and b are the same value of key in file. two ENC[...] values are the same if they
+// decrypt to the same secret, values id cannot decrypt are compared as they are.
+func sameValue(file, key, a, b string, id *Identity) bool {
+	if a == b {
+		return true
+	}
+	if id == nil || !IsEncrypted(a) || !IsEncrypted(b) {
+		return false
+	}
+	plainA, err := DecryptValue(a, file, key, id)
+	if err != nil {
+		return false
+	}
+	plainB, err := DecryptValue(b, file, key, id)
+	return err == nil && plainA == plainB
+}
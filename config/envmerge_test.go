@@ -0,0 +1,128 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func TestMergeEnv(t *testing.T) {
+	base := "# zero\nIF0_ENVIRONMENT=env\nZERO_NODES_MANAGER=10.0.0.1\nZERO_BASE_DOMAIN=example.com\nOLD=1\n"
+	tests := []struct {
+		name     string
+		ours     string
+		theirs   string
+		strategy string
+		expected string
+		conflict []string
+	}{
+		{
+			name:     "disjoint keys",
+			ours:     "# zero\nIF0_ENVIRONMENT=env\nZERO_NODES_MANAGER=10.0.0.2\nZERO_BASE_DOMAIN=example.com\nOLD=1\n",
+			theirs:   "# zero\nIF0_ENVIRONMENT=env\nZERO_NODES_MANAGER=10.0.0.1\nZERO_BASE_DOMAIN=example.org\nOLD=1\n",
+			expected: "# zero\nIF0_ENVIRONMENT=env\nZERO_NODES_MANAGER=10.0.0.2\nZERO_BASE_DOMAIN=example.org\nOLD=1\n",
+		},
+		{
+			name:     "added and removed keys",
+			ours:     base + "OURS=1\n",
+			theirs:   "# zero\nIF0_ENVIRONMENT=env\nZERO_NODES_MANAGER=10.0.0.1\nZERO_BASE_DOMAIN=example.com\n\n# added by them\nTHEIRS=\"a b\"\n",
+			expected: "# zero\nIF0_ENVIRONMENT=env\nZERO_NODES_MANAGER=10.0.0.1\nZERO_BASE_DOMAIN=example.com\nOURS=1\n# added by them\nTHEIRS=\"a b\"\n",
+		},
+		{
+			name:     "same change on both sides",
+			ours:     strings.Replace(base, "example.com", "example.org", 1),
+			theirs:   strings.Replace(base, "example.com", "example.org", 1) + "NEW=1\n",
+			expected: strings.Replace(base, "example.com", "example.org", 1) + "NEW=1\n",
+		},
+		{
+			name:     "conflict resolved with ours",
+			ours:     strings.Replace(base, "example.com", "ours.com", 1),
+			theirs:   strings.Replace(base, "example.com", "theirs.com", 1),
+			strategy: MergeOurs,
+			expected: strings.Replace(base, "example.com", "ours.com", 1),
+			conflict: []string{"zero.env: ZERO_BASE_DOMAIN"},
+		},
+		{
+			name:     "conflicts resolved with theirs",
+			ours:     strings.Replace(strings.Replace(base, "example.com", "ours.com", 1), "OLD=1\n", "OLD=2\n", 1),
+			theirs:   strings.Replace(strings.Replace(base, "example.com", "theirs.com", 1), "OLD=1\n", "", 1),
+			strategy: MergeTheirs,
+			expected: strings.Replace(strings.Replace(base, "example.com", "theirs.com", 1), "OLD=1\n", "", 1),
+			conflict: []string{"zero.env: ZERO_BASE_DOMAIN", "zero.env: OLD"},
+		},
+	}
+	for _, test := range tests {
+		var conflicts []string
+		resolve := func(c SyncConflict) (string, error) {
+			conflicts = append(conflicts, c.String())
+			return test.strategy, nil
+		}
+		doc, err := MergeEnv("zero.env", ParseDocument([]byte(base)), ParseDocument([]byte(test.ours)),
+			ParseDocument([]byte(test.theirs)), nil, resolve)
+		assert.Nil(t, err, test.name)
+		assert.Equal(t, test.expected, string(doc.Render()), test.name)
+		assert.Equal(t, test.conflict, conflicts, test.name)
+	}
+}
+
+func TestMergeEnvResolverError(t *testing.T) {
+	_, err := MergeEnv("zero.env", ParseDocument([]byte("A=1\n")), ParseDocument([]byte("A=2\n")),
+		ParseDocument([]byte("A=3\n")), nil, func(c SyncConflict) (string, error) {
+			return "", errors.New("test-resolve-error")
+		})
+	assert.EqualError(t, err, "test-resolve-error")
+}
+
+func TestMergeEnvEncrypted(t *testing.T) {
+	id, _ := GenerateIdentity()
+	encrypt := func(val string) string {
+		enc, err := EncryptValue(val, "dash1.env", "HCLOUD_TOKEN", []string{id.Recipient()})
+		assert.Nil(t, err)
+		return enc
+	}
+	base := "HCLOUD_TOKEN=" + encrypt("token") + "\nZERO_ADMIN_USER=admin\n"
+	// ours encrypted the same token again, theirs changed it
+	ours := "HCLOUD_TOKEN=" + encrypt("token") + "\nZERO_ADMIN_USER=admin\n"
+	changed := encrypt("new-token")
+	theirs := "HCLOUD_TOKEN=" + changed + "\nZERO_ADMIN_USER=root\n"
+
+	var conflicts []SyncConflict
+	resolve := func(c SyncConflict) (string, error) {
+		conflicts = append(conflicts, c)
+		return MergeOurs, nil
+	}
+	doc, err := MergeEnv("dash1.env", ParseDocument([]byte(base)), ParseDocument([]byte(ours)),
+		ParseDocument([]byte(theirs)), id, resolve)
+	assert.Nil(t, err)
+	assert.Empty(t, conflicts)
+	val, _ := doc.Get("HCLOUD_TOKEN")
+	assert.Equal(t, changed, val)
+
+	// without identity the ciphertexts differ, the conflict is reported as encrypted
+	_, err = MergeEnv("dash1.env", ParseDocument([]byte(base)), ParseDocument([]byte(ours)),
+		ParseDocument([]byte(theirs)), nil, resolve)
+	assert.Nil(t, err)
+	assert.Len(t, conflicts, 1)
+	assert.Equal(t, "dash1.env: HCLOUD_TOKEN (encrypted)", conflicts[0].String())
+	var out bytes.Buffer
+	_, _ = promptResolver(strings.NewReader("o\n"), &out)(conflicts[0])
+	assert.Contains(t, out.String(), "  ours:   (encrypted)\n  theirs: (encrypted)\n")
+	assert.NotContains(t, out.String(), "ENC[")
+}
+
+func TestPromptResolver(t *testing.T) {
+	var out bytes.Buffer
+	resolve := promptResolver(strings.NewReader("x\nt\no\n"), &out)
+	side, err := resolve(SyncConflict{File: "zero.env", Key: "A", Ours: "1", TheirsRemoved: true})
+	assert.Nil(t, err)
+	assert.Equal(t, MergeTheirs, side)
+	assert.Equal(t, "Conflicting changes of zero.env: A\n  ours:   1\n  theirs: (removed)\n"+
+		"Keep [o]urs or take [t]heirs? Keep [o]urs or take [t]heirs? ", out.String())
+	side, err = resolve(SyncConflict{File: "logo.png"})
+	assert.Nil(t, err)
+	assert.Equal(t, MergeOurs, side)
+	_, err = resolve(SyncConflict{File: "logo.png"})
+	assert.EqualError(t, err, "no resolution for logo.png")
+}
@@ -0,0 +1,27 @@
+//go:build windows
+// +build windows
+
+package config
+
+import (
+	"golang.org/x/sys/windows"
+	"os"
+)
+
+// lockFile takes an exclusive lock on f. without block, it fails if the lock is held.
+func lockFile(f *os.File, block bool) error {
+	flags := uint32(windows.LOCKFILE_EXCLUSIVE_LOCK)
+	if !block {
+		flags |= windows.LOCKFILE_FAIL_IMMEDIATELY
+	}
+	return windows.LockFileEx(windows.Handle(f.Fd()), flags, 0, 1, 0, &windows.Overlapped{})
+}
+
+func unlockFile(f *os.File) error {
+	return windows.UnlockFileEx(windows.Handle(f.Fd()), 0, 1, 0, &windows.Overlapped{})
+}
+
+// syncDir is a no-op, directories cannot be flushed on windows
+func syncDir(dir string) error {
+	return nil
+}
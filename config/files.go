@@ -0,0 +1,90 @@
+package config
+
+import (
+	"fmt"
+	"if0/common"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// lockFileName is the advisory lock taken in ~/.if0 around every configuration read-modify-write
+const lockFileName = ".lock"
+
+// configMutex serializes configuration updates within the process, the lock file across processes
+var configMutex sync.Mutex
+
+// LockConfig takes the configuration lock, waiting for other if0 processes to release it.
+// the returned function releases the lock. the lock is not reentrant,
+// functions of this package that update configuration files take it themselves.
+func LockConfig() (func(), error) {
+	configMutex.Lock()
+	err := os.MkdirAll(common.If0Dir, 0755)
+	if err != nil {
+		configMutex.Unlock()
+		return nil, err
+	}
+	f, err := os.OpenFile(filepath.Join(common.If0Dir, lockFileName), os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		configMutex.Unlock()
+		return nil, err
+	}
+	if err = lockFile(f, false); err != nil {
+		fmt.Println("Waiting for another if0 process to finish updating the configuration")
+		err = lockFile(f, true)
+	}
+	if err != nil {
+		_ = f.Close()
+		configMutex.Unlock()
+		return nil, err
+	}
+	return func() {
+		_ = unlockFile(f)
+		_ = f.Close()
+		configMutex.Unlock()
+	}, nil
+}
+
+// withConfigLock runs fn while holding the configuration lock
+func withConfigLock(fn func() error) error {
+	unlock, err := LockConfig()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return fn()
+}
+
+// writeFileAtomic replaces path with data: the data is written to a temporary file
+// in the same directory, flushed to disk and renamed over path,
+// so readers see either the old or the new content, never a partial file.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(path)
+	tmp, err := ioutil.TempFile(dir, "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err = tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	if info, statErr := os.Stat(path); statErr == nil {
+		perm = info.Mode().Perm()
+	}
+	if err = os.Chmod(tmp.Name(), perm); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
@@ -2,78 +2,246 @@ package config
 
 import (
 	"fmt"
-	"github.com/djherbis/times"
+	"github.com/pkg/errors"
 	"if0/common"
-	"io/ioutil"
-	"os"
-	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 )
 
+const defaultGcPeriod = 30
+
+// GcPolicy describes which snapshots are removed by the garbage collection.
+// Policies are read from if0.env:
+//  GC_AUTO       run the garbage collection automatically after `if0 config` (default: false)
+//  GC_PERIOD     remove snapshots older than the given number of days (default: 30)
+//  GC_KEEP_LAST  keep the last N snapshots of every source file
+//  GC_MAX_SIZE   remove the oldest snapshots until all snapshots fit into the given size, e.g. 10MB
+// If GC_KEEP_LAST and GC_PERIOD are both set, the last N snapshots are kept,
+// and older snapshots are kept for at least GC_PERIOD days.
+// Pinned and tagged snapshots are never removed.
+type GcPolicy struct {
+	Auto bool
+	// Period in days, -1 if not set
+	Period int
+	// KeepLast snapshots per source file, -1 if not set
+	KeepLast int
+	// MaxSize of all snapshots in bytes, -1 if not set
+	MaxSize int64
+}
+
 // GarbageCollection automatically cleans up backed-up files in the ~/.if0/.snapshots directory
-// requires env variables GC_AUTO and GC_PERIOD to be set.
-// By default, GC_AUTO=false, GC_PERIOD=30 (days)
+// if GC_AUTO is set in if0.env.
 func GarbageCollection() {
-	gc, gcPeriod := getGcPeriod()
-	if gc {
-		files, err := ioutil.ReadDir(common.SnapshotsDir)
+	policy, err := LoadGcPolicy()
+	if err != nil {
+		fmt.Println("Error: Garbage collection - ", err)
+		return
+	}
+	if !policy.Auto {
+		return
+	}
+	_, err = RunGarbageCollection(policy, false)
+	if err != nil {
+		fmt.Println("Error: Garbage collection - ", err)
+	}
+}
+
+// RunGarbageCollection removes the snapshots selected by the policy and returns them.
+// with dryRun, the snapshots are only returned.
+func RunGarbageCollection(policy *GcPolicy, dryRun bool) ([]*Snapshot, error) {
+	snapshots, err := ListSnapshots()
+	if err != nil {
+		return nil, errors.Wrap(err, "reading snapshots")
+	}
+	selected := policy.Select(snapshots, time.Now())
+	if dryRun {
+		return selected, nil
+	}
+	unlock, err := LockConfig()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	for _, s := range selected {
+		err = DeleteSnapshot(s)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return selected, nil
+}
+
+// LoadGcPolicy reads the garbage collection policy from if0.env and the process environment
+func LoadGcPolicy() (*GcPolicy, error) {
+	store, err := LoadStore(common.If0Default)
+	if err != nil {
+		return nil, err
+	}
+	return ParseGcPolicy(store.Get("GC_AUTO"), store.Get("GC_PERIOD"),
+		store.Get("GC_KEEP_LAST"), store.Get("GC_MAX_SIZE"))
+}
+
+// ParseGcPolicy validates the GC_* values. Empty values are treated as not set.
+func ParseGcPolicy(auto, period, keepLast, maxSize string) (*GcPolicy, error) {
+	policy := &GcPolicy{Period: -1, KeepLast: -1, MaxSize: -1}
+	var err error
+	if auto != "" {
+		policy.Auto, err = parseGcAutoStrict(auto)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if period != "" {
+		policy.Period, err = parseGcCount("GC_PERIOD", period)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if keepLast != "" {
+		policy.KeepLast, err = parseGcCount("GC_KEEP_LAST", keepLast)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if maxSize != "" {
+		policy.MaxSize, err = parseSize(maxSize)
 		if err != nil {
-			fmt.Println("Error: Reading snaphots: ", err)
-			return
+			return nil, fmt.Errorf("invalid GC_MAX_SIZE %q: %s", maxSize, err)
+		}
+	}
+	// without any retention setting, snapshots are kept for 30 days
+	if policy.Period < 0 && policy.KeepLast < 0 && policy.MaxSize < 0 {
+		policy.Period = defaultGcPeriod
+	}
+	return policy, nil
+}
+
+// Select returns the snapshots to be removed, oldest first
+func (p *GcPolicy) Select(snapshots []*Snapshot, now time.Time) []*Snapshot {
+	sorted := make([]*Snapshot, len(snapshots))
+	copy(sorted, snapshots)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Created.Before(sorted[j].Created)
+	})
+
+	// position of each snapshot counted from the newest snapshot of the same source file
+	age := make(map[*Snapshot]int)
+	count := make(map[string]int)
+	for i := len(sorted) - 1; i >= 0; i-- {
+		source := snapshotGroup(sorted[i])
+		age[sorted[i]] = count[source]
+		count[source]++
+	}
+
+	remove := make(map[*Snapshot]bool)
+	var kept []*Snapshot
+	for _, s := range sorted {
+		if s.Protected() {
+			continue
+		}
+		expired := p.Period >= 0 && int(now.Sub(s.Created).Hours()/24) >= p.Period
+		superseded := p.KeepLast >= 0 && age[s] >= p.KeepLast
+		switch {
+		case p.Period >= 0 && p.KeepLast >= 0:
+			remove[s] = expired && superseded
+		case p.Period >= 0:
+			remove[s] = expired
+		case p.KeepLast >= 0:
+			remove[s] = superseded
+		}
+		if !remove[s] {
+			kept = append(kept, s)
+		}
+	}
+
+	if p.MaxSize >= 0 {
+		var total int64
+		for _, s := range sorted {
+			if !remove[s] {
+				total += s.Size
+			}
 		}
-		for _, f := range files {
-			creationTime := getCreationTome(f)
-			diff := time.Now().Sub(creationTime).Hours() / 24
-			if int(diff) >= gcPeriod {
-				_ = os.Remove(filepath.Join(common.SnapshotsDir, f.Name()))
+		for _, s := range kept {
+			if total <= p.MaxSize {
+				break
 			}
+			remove[s] = true
+			total -= s.Size
 		}
 	}
+
+	var selected []*Snapshot
+	for _, s := range sorted {
+		if remove[s] {
+			selected = append(selected, s)
+		}
+	}
+	return selected
 }
 
-func getCreationTome(f os.FileInfo) time.Time {
-	var creationTime time.Time
-	t, err := times.Stat(filepath.Join(common.SnapshotsDir, f.Name()))
-	if err != nil {
-		fmt.Println(err)
+// snapshotGroup returns the source file of a snapshot,
+// or the name part of the ID for snapshots without metadata
+func snapshotGroup(s *Snapshot) string {
+	if s.Source != "" {
+		return s.Source
 	}
-	if times.HasBirthTime {
-		creationTime = t.BirthTime()
-	} else {
-		creationTime = f.ModTime()
+	if i := strings.LastIndex(s.ID, "-"); i > 0 {
+		return s.ID[:i]
 	}
-	return creationTime
+	return s.ID
 }
 
-func getGcPeriod() (bool, int) {
-	ReadConfigFile(common.If0Default)
-	gcAutoStr := GetEnvVariable("GC_AUTO")
-	gcPeriodStr := GetEnvVariable("GC_PERIOD")
-
-	gcAuto := parseGcAuto(gcAutoStr)
-	// if GC_PERIOD is not set, setting it to default value of 30 days
-	var gcPeriod int
-	if gcAuto {
-		if gcPeriodStr == "" {
-			gcPeriod = 30
-		} else {
-			gcPeriod, _ = strconv.Atoi(gcPeriodStr)
+func parseGcCount(key, value string) (int, error) {
+	n, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid %s %q: expected a non-negative number", key, value)
+	}
+	return n, nil
+}
+
+// parseSize parses sizes like 1048576, 512K, 512KB, 10MB or 1GiB
+func parseSize(value string) (int64, error) {
+	s := strings.ToUpper(strings.TrimSpace(value))
+	units := []struct {
+		suffix string
+		factor int64
+	}{
+		{"KIB", 1 << 10}, {"MIB", 1 << 20}, {"GIB", 1 << 30},
+		{"KB", 1 << 10}, {"MB", 1 << 20}, {"GB", 1 << 30},
+		{"K", 1 << 10}, {"M", 1 << 20}, {"G", 1 << 30}, {"B", 1},
+	}
+	factor := int64(1)
+	for _, u := range units {
+		if strings.HasSuffix(s, u.suffix) {
+			factor = u.factor
+			s = strings.TrimSpace(strings.TrimSuffix(s, u.suffix))
+			break
 		}
-		return true, gcPeriod
 	}
-	return false, 0
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return 0, errors.New("expected a size such as 500KB, 10MB or 1GB")
+	}
+	return n * factor, nil
 }
 
 func parseGcAuto(gcAutoStr string) bool {
-	var gcAuto bool
-	if strings.ToLower(gcAutoStr) == "yes" {
-		gcAuto = true
-	} else if strings.ToLower(gcAutoStr) == "no" {
-		gcAuto = false
-	} else {
-		gcAuto, _ = strconv.ParseBool(gcAutoStr)
-	}
+	gcAuto, _ := parseGcAutoStrict(gcAutoStr)
 	return gcAuto
 }
+
+func parseGcAutoStrict(gcAutoStr string) (bool, error) {
+	switch strings.ToLower(strings.TrimSpace(gcAutoStr)) {
+	case "yes":
+		return true, nil
+	case "no":
+		return false, nil
+	}
+	gcAuto, err := strconv.ParseBool(strings.TrimSpace(gcAutoStr))
+	if err != nil {
+		return false, fmt.Errorf("invalid GC_AUTO %q: expected yes/no or true/false", gcAutoStr)
+	}
+	return gcAuto, nil
+}
@@ -0,0 +1,265 @@
+package config
+
+import (
+	"github.com/pkg/errors"
+	"if0/common"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Layer kinds, from the lowest to the highest precedence
+const (
+	GlobalLayer   = "global"
+	EnvFileLayer  = "environment"
+	ProcessLayer  = "process"
+	OverrideLayer = "override"
+)
+
+// EnvFilesOrderKey lists the environment files in the order they are layered, e.g. IF0_ENV_FILES=zero.env,dash1.env
+// files that are not listed are layered afterwards in alphabetical order.
+const EnvFilesOrderKey = "IF0_ENV_FILES"
+
+// defaultEnvFilesOrder is used if IF0_ENV_FILES is not set
+var defaultEnvFilesOrder = []string{common.ZeroEnvFile, common.Dash1EnvFile}
+
+// Layer is a named set of configuration values, for example the content of an .env file
+type Layer struct {
+	Name   string
+	Kind   string
+	Source string
+	Keys   []string
+	Values map[string]string
+	// Literal marks values which are not expanded, such as decrypted secrets
+	Literal map[string]bool
+}
+
+// setLiteral replaces the value of key by val, which is not expanded
+func (l *Layer) setLiteral(key, val string) {
+	if l.Literal == nil {
+		l.Literal = make(map[string]bool)
+	}
+	l.Values[key] = val
+	l.Literal[key] = true
+}
+
+// Origin is a layer defining a key, together with the raw value it defines
+type Origin struct {
+	Layer *Layer
+	Value string
+}
+
+// Explanation describes how the value of a key is resolved:
+// the winning layer and the lower layers it shadows, highest first
+type Explanation struct {
+	Key      string
+	Raw      string
+	Value    string
+	Err      error
+	Origin   *Layer
+	Shadowed []Origin
+}
+
+// NewDocumentLayer creates a layer out of a parsed .env file
+func NewDocumentLayer(name, kind, source string, doc *Document) *Layer {
+	return &Layer{Name: name, Kind: kind, Source: source, Keys: doc.Keys(), Values: doc.Map()}
+}
+
+// NewFileLayer reads the .env file at path into a layer named after the file
+func NewFileLayer(path, kind string) (*Layer, error) {
+	doc, err := ReadDocument(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewDocumentLayer(filepath.Base(path), kind, path, doc), nil
+}
+
+// NewProcessEnvLayer creates a layer out of the environment of the current process
+func NewProcessEnvLayer() *Layer {
+	layer := &Layer{Name: "process environment", Kind: ProcessLayer, Values: make(map[string]string)}
+	for _, kv := range os.Environ() {
+		pair := strings.SplitN(kv, "=", 2)
+		if len(pair) == 2 {
+			layer.Keys = append(layer.Keys, pair[0])
+			layer.Values[pair[0]] = pair[1]
+		}
+	}
+	return layer
+}
+
+// NewOverrideLayer creates a layer out of KEY=VALUE pairs given on the command line
+func NewOverrideLayer(overrides []string) (*Layer, error) {
+	layer := &Layer{Name: "command line", Kind: OverrideLayer, Values: make(map[string]string)}
+	for _, kv := range overrides {
+		pair := strings.SplitN(kv, "=", 2)
+		if len(pair) != 2 || !isValidKey(normalizeKey(pair[0])) {
+			return nil, errors.Errorf("invalid override %q, expected KEY=VALUE", kv)
+		}
+		key := normalizeKey(pair[0])
+		if _, ok := layer.Values[key]; !ok {
+			layer.Keys = append(layer.Keys, key)
+		}
+		layer.Values[key] = strings.TrimSpace(pair[1])
+	}
+	return layer, nil
+}
+
+// LoadEnvironmentResolver creates the layered configuration of the environment at envDir:
+// if0.env < environment *.env files in IF0_ENV_FILES order < process environment < command line overrides
+func LoadEnvironmentResolver(envDir string, overrides []string) (*Resolver, error) {
+	var layers []*Layer
+	if0Layer, err := NewFileLayer(common.If0Default, GlobalLayer)
+	if err == nil {
+		layers = append(layers, if0Layer)
+	}
+	processLayer := NewProcessEnvLayer()
+	order := defaultEnvFilesOrder
+	if val, ok := NewResolver(layers...).Lookup(EnvFilesOrderKey); ok {
+		order = strings.Split(val, ",")
+	}
+	if val, ok := processLayer.Values[EnvFilesOrderKey]; ok {
+		order = strings.Split(val, ",")
+	}
+	envLayers, err := loadEnvFileLayers(envDir, order)
+	if err != nil {
+		return nil, err
+	}
+	layers = append(layers, envLayers...)
+	layers = append(layers, processLayer)
+	if len(overrides) > 0 {
+		overrideLayer, err := NewOverrideLayer(overrides)
+		if err != nil {
+			return nil, err
+		}
+		layers = append(layers, overrideLayer)
+	}
+	return NewResolver(layers...), nil
+}
+
+// loadEnvFileLayers reads all *.env files of an environment directory.
+// files listed in order come first, the remaining files follow in alphabetical order.
+func loadEnvFileLayers(envDir string, order []string) ([]*Layer, error) {
+	files, err := ioutil.ReadDir(envDir)
+	if err != nil {
+		return nil, err
+	}
+	rank := make(map[string]int)
+	for i, name := range order {
+		name = strings.TrimSpace(name)
+		if _, ok := rank[name]; !ok && name != "" {
+			rank[name] = i
+		}
+	}
+	var names []string
+	for _, file := range files {
+		if !file.IsDir() && filepath.Ext(file.Name()) == ".env" {
+			names = append(names, file.Name())
+		}
+	}
+	sort.SliceStable(names, func(i, j int) bool {
+		ri, iDeclared := rank[names[i]]
+		rj, jDeclared := rank[names[j]]
+		if iDeclared && jDeclared {
+			return ri < rj
+		}
+		if iDeclared != jDeclared {
+			return iDeclared
+		}
+		return names[i] < names[j]
+	})
+	var layers []*Layer
+	for _, name := range names {
+		layer, err := NewFileLayer(filepath.Join(envDir, name), EnvFileLayer)
+		if err != nil {
+			return nil, err
+		}
+		layers = append(layers, layer)
+	}
+	if len(layers) == 0 {
+		return nil, errors.New("no .env files found")
+	}
+	return layers, nil
+}
+
+// Explain returns the resolved value of key together with the layer it came from
+// and the lower layers it shadows
+func (r *Resolver) Explain(key string) Explanation {
+	e := Explanation{Key: key}
+	for i := len(r.layers) - 1; i >= 0; i-- {
+		val, ok := r.layers[i].Values[key]
+		if !ok {
+			continue
+		}
+		if e.Origin == nil {
+			e.Origin = r.layers[i]
+			e.Raw = val
+		} else {
+			e.Shadowed = append(e.Shadowed, Origin{Layer: r.layers[i], Value: val})
+		}
+	}
+	e.Value, e.Err = r.Resolve(key)
+	return e
+}
+
+// Keys returns the keys defined by layers of the given kinds, in layer order.
+// without kinds, all keys except the ones only defined by the process environment are returned.
+func (r *Resolver) Keys(kinds ...string) []string {
+	if len(kinds) == 0 {
+		kinds = []string{GlobalLayer, EnvFileLayer, OverrideLayer}
+	}
+	var keys []string
+	seen := make(map[string]bool)
+	for _, layer := range r.layers {
+		if !containsString(kinds, layer.Kind) {
+			continue
+		}
+		for _, key := range layer.Keys {
+			if !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+	}
+	return keys
+}
+
+// only returns a resolver over the layers of the given kinds
+func (r *Resolver) only(kinds ...string) *Resolver {
+	var layers []*Layer
+	for _, layer := range r.layers {
+		if containsString(kinds, layer.Kind) {
+			layers = append(layers, layer)
+		}
+	}
+	return NewResolver(layers...)
+}
+
+// Environment returns the resolved KEY=VALUE pairs of the environment files and command line overrides
+// that differ from the raw environment files: values of the process environment and the command line,
+// expanded ${VAR} references, decrypted secrets and fetched secret references.
+// a process reading the environment files itself needs these pairs only.
+func (r *Resolver) Environment() ([]string, error) {
+	var env []string
+	for _, key := range r.Keys(EnvFileLayer, OverrideLayer) {
+		e := r.Explain(key)
+		if e.Err != nil {
+			return nil, errors.Wrapf(e.Err, "resolving %s", key)
+		}
+		if e.Origin.Kind == EnvFileLayer && !e.Origin.Literal[key] && e.Value == e.Raw {
+			continue
+		}
+		env = append(env, key+"="+e.Value)
+	}
+	return env, nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
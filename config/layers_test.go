@@ -0,0 +1,80 @@
+package config
+
+import (
+	"github.com/stretchr/testify/assert"
+	"if0/common"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func setupLayersTest(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "if0-layers")
+	assert.Nil(t, err)
+	common.If0Default = filepath.Join(dir, "if0.env")
+	_ = ioutil.WriteFile(common.If0Default, []byte("KEY=if0\nGLOBAL=yes\n"), 0644)
+	envDir := filepath.Join(dir, "env")
+	_ = os.MkdirAll(envDir, 0755)
+	_ = ioutil.WriteFile(filepath.Join(envDir, "zero.env"), []byte("KEY=zero\nZERO=yes\n"), 0644)
+	_ = ioutil.WriteFile(filepath.Join(envDir, "dash1.env"), []byte("KEY=dash1\n"), 0644)
+	_ = ioutil.WriteFile(filepath.Join(envDir, "a.env"), []byte("KEY=a\n"), 0644)
+	return envDir, func() { _ = os.RemoveAll(dir) }
+}
+
+func TestEnvFileLayerOrder(t *testing.T) {
+	envDir, cleanup := setupLayersTest(t)
+	defer cleanup()
+
+	r, err := LoadEnvironmentResolver(envDir, nil)
+	assert.Nil(t, err)
+	var names []string
+	for _, layer := range r.Layers() {
+		names = append(names, layer.Name)
+	}
+	assert.Equal(t, []string{"if0.env", "zero.env", "dash1.env", "a.env", "process environment"}, names)
+	val, _ := r.Lookup("KEY")
+	assert.Equal(t, "a", val)
+
+	_ = os.Setenv(EnvFilesOrderKey, "a.env,zero.env")
+	defer os.Unsetenv(EnvFilesOrderKey)
+	r, err = LoadEnvironmentResolver(envDir, nil)
+	assert.Nil(t, err)
+	val, _ = r.Lookup("KEY")
+	assert.Equal(t, "dash1", val)
+}
+
+func TestExplain(t *testing.T) {
+	envDir, cleanup := setupLayersTest(t)
+	defer cleanup()
+
+	r, err := LoadEnvironmentResolver(envDir, []string{"key=cli"})
+	assert.Nil(t, err)
+	e := r.Explain("KEY")
+	assert.Equal(t, "cli", e.Value)
+	assert.Equal(t, OverrideLayer, e.Origin.Kind)
+	var shadowed []string
+	for _, o := range e.Shadowed {
+		shadowed = append(shadowed, o.Layer.Name+"="+o.Value)
+	}
+	assert.Equal(t, []string{"a.env=a", "dash1.env=dash1", "zero.env=zero", "if0.env=if0"}, shadowed)
+
+	e = r.Explain("GLOBAL")
+	assert.Equal(t, GlobalLayer, e.Origin.Kind)
+	assert.Empty(t, e.Shadowed)
+
+	assert.Equal(t, []string{"KEY", "GLOBAL", "ZERO"}, r.Keys())
+	env, err := r.Environment()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"KEY=cli"}, env, "the raw values of the environment files are left out")
+
+	_ = ioutil.WriteFile(filepath.Join(envDir, "zero.env"), []byte("KEY=zero\nZERO=${GLOBAL}\n"), 0644)
+	r, err = LoadEnvironmentResolver(envDir, []string{"key=cli"})
+	assert.Nil(t, err)
+	env, err = r.Environment()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"KEY=cli", "ZERO=yes"}, env, "expanded values are passed")
+
+	_, err = LoadEnvironmentResolver(envDir, []string{"KEY"})
+	assert.EqualError(t, err, `invalid override "KEY", expected KEY=VALUE`)
+}
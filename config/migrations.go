@@ -0,0 +1,493 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/pkg/errors"
+	"if0/common"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// EnvVersionKey records the configuration version of an environment in its zero.env.
+// if0.env records its version in IF0_VERSION.
+const EnvVersionKey = "IF0_ENV_VERSION"
+
+// baseVersion is the version of configuration files without a version key
+const baseVersion = 1
+
+// migrations upgrade the configuration from Migration.Version to Migration.Version+1.
+// they are ordered by version, without gaps, starting at baseVersion.
+var migrations = []Migration{
+	{Version: 1, Description: "AWS access key", Steps: []MigrationStep{
+		// AWS and terraform name the access key AWS_ACCESS_KEY_ID
+		RenameKey{Key: "AWS_SECRET_KEY_ID", NewKey: "AWS_ACCESS_KEY_ID"},
+	}},
+}
+
+// Migration upgrades if0.env and the environments from Version to Version+1
+type Migration struct {
+	Version     int
+	Description string
+	Steps       []MigrationStep
+}
+
+// MigrationStep is a single change of a migration, e.g. renaming a key
+type MigrationStep interface {
+	String() string
+	Apply(ws *Workspace) error
+}
+
+// Workspace holds the configuration files a migration step operates on:
+// if0.env and the environments that are at the version being migrated
+type Workspace struct {
+	// Global is nil if if0.env is not migrated by the current migration
+	Global       *ConfigFile
+	Environments []*EnvironmentConfig
+	// id rebinds encrypted values of keys which are renamed or moved, it is read when first needed
+	id *Identity
+}
+
+// ConfigFile is a parsed configuration file
+type ConfigFile struct {
+	Path    string
+	Doc     *Document
+	version int
+	written []byte
+}
+
+// EnvironmentConfig holds the *.env files of an environment
+type EnvironmentConfig struct {
+	Dir     string
+	Files   []*ConfigFile
+	version int
+}
+
+// MigrationChange lists the key changes a migration step makes to a file
+type MigrationChange struct {
+	Version int
+	Step    string
+	File    string
+	Changes []KeyChange
+}
+
+// CurrentVersion returns the configuration version written by this if0 release
+func CurrentVersion() int {
+	return baseVersion + len(migrations)
+}
+
+// Migrate upgrades if0.env and every environment under .environments to the current version.
+// every file is snapshotted before a step changes it. with dryRun, nothing is written
+// and the planned changes are returned.
+func Migrate(dryRun bool) ([]MigrationChange, error) {
+	if dryRun {
+		return runMigrations(migrations, dryRun)
+	}
+	unlock, err := LockConfig()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	return runMigrations(migrations, dryRun)
+}
+
+func runMigrations(list []Migration, dryRun bool) ([]MigrationChange, error) {
+	if len(list) == 0 {
+		return nil, nil
+	}
+	current := baseVersion + len(list)
+	for i, m := range list {
+		if m.Version != baseVersion+i {
+			return nil, fmt.Errorf("migration %q has version %d, expected %d", m.Description, m.Version, baseVersion+i)
+		}
+	}
+	global, err := loadGlobalConfigFile()
+	if err != nil {
+		return nil, err
+	}
+	envs, err := loadEnvironmentConfigs()
+	if err != nil {
+		return nil, err
+	}
+	if global != nil && global.version > current {
+		return nil, fmt.Errorf("%s has version %d, this if0 supports up to version %d",
+			global.Path, global.version, current)
+	}
+	for _, env := range envs {
+		if env.version > current {
+			return nil, fmt.Errorf("environment %s has version %d, this if0 supports up to version %d",
+				env.Dir, env.version, current)
+		}
+	}
+
+	var changes []MigrationChange
+	for _, m := range list {
+		ws := &Workspace{}
+		if global != nil && global.version == m.Version {
+			ws.Global = global
+		}
+		for _, env := range envs {
+			if env.version == m.Version {
+				ws.Environments = append(ws.Environments, env)
+			}
+		}
+		if ws.Global == nil && len(ws.Environments) == 0 {
+			continue
+		}
+		steps := append(append([]MigrationStep{}, m.Steps...), setVersionStep{version: m.Version + 1})
+		for _, step := range steps {
+			stepChanges, err := applyStep(ws, step, dryRun)
+			if err != nil {
+				return changes, errors.Wrapf(err, "migration to version %d: %s", m.Version+1, step)
+			}
+			for i := range stepChanges {
+				stepChanges[i].Version = m.Version + 1
+			}
+			changes = append(changes, stepChanges...)
+		}
+		if ws.Global != nil {
+			ws.Global.version = m.Version + 1
+		}
+		for _, env := range ws.Environments {
+			env.version = m.Version + 1
+		}
+	}
+	return changes, nil
+}
+
+// applyStep applies a step to the workspace, then snapshots and writes every file it changed
+func applyStep(ws *Workspace, step MigrationStep, dryRun bool) ([]MigrationChange, error) {
+	before := make(map[*ConfigFile]*Document)
+	for _, f := range ws.files() {
+		before[f] = ParseDocument(f.Doc.Render())
+	}
+	err := step.Apply(ws)
+	if err != nil {
+		return nil, err
+	}
+	var changes []MigrationChange
+	for _, f := range ws.files() {
+		rendered := f.Doc.Render()
+		if f.written != nil && bytes.Equal(rendered, f.written) {
+			continue
+		}
+		old, ok := before[f]
+		if !ok {
+			old = NewDocument()
+		}
+		keyChanges := diffDocuments(old, f.Doc)
+		if len(keyChanges) == 0 {
+			continue
+		}
+		changes = append(changes, MigrationChange{Step: step.String(), File: f.Path, Changes: keyChanges})
+		if dryRun {
+			f.written = rendered
+			continue
+		}
+		if isFilePresent(f.Path) {
+			_, err = TakeSnapshot(f.Path)
+			if err != nil {
+				return nil, errors.Wrapf(err, "snapshot of %s", f.Path)
+			}
+		}
+		err = f.Doc.WriteFile(f.Path, 0644)
+		if err != nil {
+			return nil, err
+		}
+		f.written = rendered
+	}
+	return changes, nil
+}
+
+// rebind binds the value of key in from to newKey in to, encrypted values are bound to their file and key
+func (ws *Workspace) rebind(val string, from *ConfigFile, key string, to *ConfigFile, newKey string) (string, error) {
+	if !IsEncrypted(val) {
+		return val, nil
+	}
+	if ws.id == nil {
+		id, err := LoadIdentity()
+		if err != nil {
+			return "", errors.Wrapf(err, "reading identity to move the encrypted %s", key)
+		}
+		ws.id = id
+	}
+	rebound, err := RebindValue(val, filepath.Base(from.Path), key, filepath.Base(to.Path), newKey, ws.id)
+	if err != nil {
+		return "", errors.Wrapf(err, "moving %s in %s", key, from.Path)
+	}
+	return rebound, nil
+}
+
+func (ws *Workspace) files() []*ConfigFile {
+	var files []*ConfigFile
+	if ws.Global != nil {
+		files = append(files, ws.Global)
+	}
+	for _, env := range ws.Environments {
+		files = append(files, env.Files...)
+	}
+	return files
+}
+
+// File returns the configuration file of the environment with the given name,
+// an empty file is added if it does not exist yet
+func (e *EnvironmentConfig) File(name string) *ConfigFile {
+	for _, f := range e.Files {
+		if filepath.Base(f.Path) == name {
+			return f
+		}
+	}
+	f := &ConfigFile{Path: filepath.Join(e.Dir, name), Doc: NewDocument()}
+	e.Files = append(e.Files, f)
+	return f
+}
+
+func loadGlobalConfigFile() (*ConfigFile, error) {
+	doc, err := ReadDocument(common.If0Default)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	version, err := parseVersion(doc, common.IF0_VERSION)
+	if err != nil {
+		return nil, errors.Wrap(err, common.If0Default)
+	}
+	return &ConfigFile{Path: common.If0Default, Doc: doc, version: version, written: doc.Render()}, nil
+}
+
+// loadEnvironmentConfigs reads the *.env files of all environments, i.e. directories with a zero.env file
+func loadEnvironmentConfigs() ([]*EnvironmentConfig, error) {
+	var envs []*EnvironmentConfig
+	err := filepath.Walk(common.EnvDir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && p == common.EnvDir {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if !info.IsDir() {
+			return nil
+		}
+		if info.Name() == ".git" || info.Name() == ".ssh" {
+			return filepath.SkipDir
+		}
+		if !isFilePresent(filepath.Join(p, common.ZeroEnvFile)) {
+			return nil
+		}
+		env, err := loadEnvironmentConfig(p)
+		if err != nil {
+			return err
+		}
+		envs = append(envs, env)
+		return nil
+	})
+	return envs, err
+}
+
+func loadEnvironmentConfig(envDir string) (*EnvironmentConfig, error) {
+	names, err := filepath.Glob(filepath.Join(envDir, "*.env"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+	env := &EnvironmentConfig{Dir: envDir}
+	for _, name := range names {
+		doc, err := ReadDocument(name)
+		if err != nil {
+			return nil, err
+		}
+		env.Files = append(env.Files, &ConfigFile{Path: name, Doc: doc, written: doc.Render()})
+	}
+	env.version, err = parseVersion(env.File(common.ZeroEnvFile).Doc, EnvVersionKey)
+	if err != nil {
+		return nil, errors.Wrap(err, filepath.Join(envDir, common.ZeroEnvFile))
+	}
+	return env, nil
+}
+
+func parseVersion(doc *Document, key string) (int, error) {
+	val, ok := doc.Get(key)
+	if !ok || strings.TrimSpace(val) == "" {
+		return baseVersion, nil
+	}
+	version, err := strconv.Atoi(strings.TrimSpace(val))
+	if err != nil || version < baseVersion {
+		return 0, fmt.Errorf("invalid %s %q", key, val)
+	}
+	return version, nil
+}
+
+// setVersionStep records the version reached by a migration
+type setVersionStep struct {
+	version int
+}
+
+func (s setVersionStep) String() string {
+	return fmt.Sprintf("set version %d", s.version)
+}
+
+func (s setVersionStep) Apply(ws *Workspace) error {
+	v := strconv.Itoa(s.version)
+	if ws.Global != nil {
+		ws.Global.Doc.Set(common.IF0_VERSION, v)
+	}
+	for _, env := range ws.Environments {
+		env.File(common.ZeroEnvFile).Doc.Set(EnvVersionKey, v)
+	}
+	return nil
+}
+
+// RenameKey renames a key in if0.env and in the files of every environment
+type RenameKey struct {
+	Key    string
+	NewKey string
+}
+
+func (s RenameKey) String() string {
+	return fmt.Sprintf("rename %s to %s", s.Key, s.NewKey)
+}
+
+func (s RenameKey) Apply(ws *Workspace) error {
+	for _, f := range ws.files() {
+		val, ok := f.Doc.Get(s.Key)
+		if !ok || f.Doc.Has(s.NewKey) {
+			f.Doc.Rename(s.Key, s.NewKey)
+			continue
+		}
+		rebound, err := ws.rebind(val, f, s.Key, f, s.NewKey)
+		if err != nil {
+			return err
+		}
+		f.Doc.Rename(s.Key, s.NewKey)
+		if rebound != val {
+			f.Doc.Set(s.NewKey, rebound)
+		}
+	}
+	return nil
+}
+
+// SplitKey splits the value of a key at Separator into the keys NewKeys.
+// missing parts leave the remaining keys empty, extra parts are an error.
+type SplitKey struct {
+	Key       string
+	Separator string
+	NewKeys   []string
+}
+
+func (s SplitKey) String() string {
+	return fmt.Sprintf("split %s into %s", s.Key, strings.Join(s.NewKeys, ", "))
+}
+
+func (s SplitKey) Apply(ws *Workspace) error {
+	for _, f := range ws.files() {
+		val, ok := f.Doc.Get(s.Key)
+		if !ok {
+			continue
+		}
+		parts := strings.Split(val, s.Separator)
+		if len(parts) > len(s.NewKeys) {
+			return fmt.Errorf("%s in %s has %d parts, expected at most %d", s.Key, f.Path, len(parts), len(s.NewKeys))
+		}
+		for i, key := range s.NewKeys {
+			part := ""
+			if i < len(parts) {
+				part = strings.TrimSpace(parts[i])
+			}
+			f.Doc.Set(key, part)
+		}
+		f.Doc.Unset(s.Key)
+	}
+	return nil
+}
+
+// ChangeDefault replaces the old default value of a key with the new one.
+// values changed by the user are left untouched.
+type ChangeDefault struct {
+	Key        string
+	OldDefault string
+	NewDefault string
+}
+
+func (s ChangeDefault) String() string {
+	return fmt.Sprintf("change default of %s from %q to %q", s.Key, s.OldDefault, s.NewDefault)
+}
+
+func (s ChangeDefault) Apply(ws *Workspace) error {
+	for _, f := range ws.files() {
+		if val, ok := f.Doc.Get(s.Key); ok && val == s.OldDefault {
+			f.Doc.Set(s.Key, s.NewDefault)
+		}
+	}
+	return nil
+}
+
+// MoveKey moves a key from if0.env to the file File of every environment,
+// or with ToGlobal, from the environment files to if0.env.
+// moving to if0.env fails if the environments define different values.
+type MoveKey struct {
+	Key      string
+	File     string
+	ToGlobal bool
+}
+
+func (s MoveKey) String() string {
+	if s.ToGlobal {
+		return fmt.Sprintf("move %s from the environments to if0.env", s.Key)
+	}
+	return fmt.Sprintf("move %s from if0.env to %s of every environment", s.Key, s.File)
+}
+
+func (s MoveKey) Apply(ws *Workspace) error {
+	if ws.Global == nil {
+		return nil
+	}
+	if !s.ToGlobal {
+		val, ok := ws.Global.Doc.Get(s.Key)
+		if !ok {
+			return nil
+		}
+		for _, env := range ws.Environments {
+			f := env.File(s.File)
+			rebound, err := ws.rebind(val, ws.Global, s.Key, f, s.Key)
+			if err != nil {
+				return err
+			}
+			f.Doc.SetDefault(s.Key, rebound)
+		}
+		ws.Global.Doc.Unset(s.Key)
+		return nil
+	}
+	var value string
+	var found *ConfigFile
+	for _, env := range ws.Environments {
+		for _, f := range env.Files {
+			val, ok := f.Doc.Get(s.Key)
+			if !ok {
+				continue
+			}
+			if found != nil && val != value {
+				return fmt.Errorf("%s differs in %s and %s", s.Key, found.Path, f.Path)
+			}
+			value, found = val, f
+		}
+	}
+	if found == nil {
+		return nil
+	}
+	value, err := ws.rebind(value, found, s.Key, ws.Global, s.Key)
+	if err != nil {
+		return err
+	}
+	ws.Global.Doc.SetDefault(s.Key, value)
+	for _, env := range ws.Environments {
+		for _, f := range env.Files {
+			f.Doc.Unset(s.Key)
+		}
+	}
+	return nil
+}
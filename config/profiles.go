@@ -0,0 +1,153 @@
+package config
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"if0/common"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Profiles separate the configuration of different customers or GitLab instances.
+// The default profile lives directly in the if0 home directory (~/.if0 or IF0_HOME),
+// named profiles in <home>/profiles/<name>. Every profile has its own if0.env,
+// .environments and .snapshots.
+const (
+	DefaultProfile = "default"
+	// ProfileKey selects the profile from the process environment
+	ProfileKey = "IF0_PROFILE"
+
+	profilesDir       = "profiles"
+	activeProfileFile = "profile"
+)
+
+var profileNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9._-]*$`)
+
+// selectedProfile is the profile the paths of the common package point to
+var selectedProfile = DefaultProfile
+
+// Profile is a named set of if0 configuration
+type Profile struct {
+	Name string
+	Dir  string
+}
+
+// ProfileDir returns the directory of a profile
+func ProfileDir(name string) string {
+	if name == "" || name == DefaultProfile {
+		return common.If0Home
+	}
+	return filepath.Join(common.If0Home, profilesDir, name)
+}
+
+// ValidateProfileName checks that name can be used as a directory name
+func ValidateProfileName(name string) error {
+	if !profileNameRegexp.MatchString(name) {
+		return fmt.Errorf("invalid profile name %q, use letters, digits, '.', '_' and '-'", name)
+	}
+	return nil
+}
+
+// ListProfiles returns the default profile and the named profiles in alphabetical order
+func ListProfiles() ([]Profile, error) {
+	profiles := []Profile{{Name: DefaultProfile, Dir: ProfileDir(DefaultProfile)}}
+	files, err := ioutil.ReadDir(filepath.Join(common.If0Home, profilesDir))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	var names []string
+	for _, f := range files {
+		if f.IsDir() && ValidateProfileName(f.Name()) == nil && f.Name() != DefaultProfile {
+			names = append(names, f.Name())
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		profiles = append(profiles, Profile{Name: name, Dir: ProfileDir(name)})
+	}
+	return profiles, nil
+}
+
+// ProfileExists reports whether a profile has been created. the default profile always exists.
+func ProfileExists(name string) bool {
+	if name == DefaultProfile {
+		return true
+	}
+	info, err := os.Stat(ProfileDir(name))
+	return err == nil && info.IsDir()
+}
+
+// CreateProfile creates the directories of a new profile
+func CreateProfile(name string) (*Profile, error) {
+	if err := ValidateProfileName(name); err != nil {
+		return nil, err
+	}
+	if ProfileExists(name) {
+		return nil, fmt.Errorf("profile %s already exists", name)
+	}
+	dir := ProfileDir(name)
+	for _, d := range []string{".environments", ".snapshots"} {
+		if err := os.MkdirAll(filepath.Join(dir, d), 0755); err != nil {
+			return nil, errors.Wrapf(err, "creating profile %s", name)
+		}
+	}
+	return &Profile{Name: name, Dir: dir}, nil
+}
+
+// ActiveProfile returns the profile chosen with UseProfile, the default profile if none has been chosen
+func ActiveProfile() string {
+	data, err := ioutil.ReadFile(filepath.Join(common.If0Home, activeProfileFile))
+	if err != nil {
+		return DefaultProfile
+	}
+	name := strings.TrimSpace(string(data))
+	if name == "" {
+		return DefaultProfile
+	}
+	return name
+}
+
+// UseProfile makes name the active profile of later if0 runs
+func UseProfile(name string) error {
+	if !ProfileExists(name) {
+		return fmt.Errorf("profile %s not found, create it with `if0 profile create %s`", name, name)
+	}
+	err := os.MkdirAll(common.If0Home, 0755)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(common.If0Home, activeProfileFile), []byte(name+"\n"), 0644)
+}
+
+// ResolveProfile returns the profile of this run: the --profile flag, IF0_PROFILE or the active profile
+func ResolveProfile(flag string) string {
+	if flag != "" {
+		return flag
+	}
+	if name := os.Getenv(ProfileKey); name != "" {
+		return name
+	}
+	return ActiveProfile()
+}
+
+// SelectProfile points the paths of the common package, and so every package, to the profile
+func SelectProfile(name string) error {
+	if err := ValidateProfileName(name); err != nil {
+		return err
+	}
+	if !ProfileExists(name) {
+		return fmt.Errorf("profile %s not found, create it with `if0 profile create %s`", name, name)
+	}
+	common.SetIf0Dir(ProfileDir(name))
+	selectedProfile = name
+	return nil
+}
+
+// CurrentProfile returns the profile selected for this run
+func CurrentProfile() string {
+	return selectedProfile
+}
@@ -0,0 +1,87 @@
+package config
+
+import (
+	"github.com/stretchr/testify/assert"
+	"if0/common"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func setupProfileTest(t *testing.T) (string, func()) {
+	home, err := ioutil.TempDir("", "if0-home")
+	assert.Nil(t, err)
+	common.If0Home = home
+	common.SetIf0Dir(home)
+	return home, func() {
+		_ = os.RemoveAll(home)
+		selectedProfile = DefaultProfile
+	}
+}
+
+func TestCreateAndSelectProfile(t *testing.T) {
+	home, cleanup := setupProfileTest(t)
+	defer cleanup()
+
+	p, err := CreateProfile("acme")
+	assert.Nil(t, err)
+	assert.Equal(t, filepath.Join(home, "profiles", "acme"), p.Dir)
+	assert.DirExists(t, filepath.Join(p.Dir, ".environments"))
+	_, err = CreateProfile("acme")
+	assert.EqualError(t, err, "profile acme already exists")
+	_, err = CreateProfile("../acme")
+	assert.Error(t, err)
+
+	assert.Nil(t, SelectProfile("acme"))
+	assert.Equal(t, "acme", CurrentProfile())
+	assert.Equal(t, filepath.Join(p.Dir, "if0.env"), common.If0Default)
+	assert.Equal(t, filepath.Join(p.Dir, ".environments"), common.EnvDir)
+	assert.Equal(t, filepath.Join(p.Dir, ".snapshots"), common.SnapshotsDir)
+
+	assert.Nil(t, SelectProfile(DefaultProfile))
+	assert.Equal(t, filepath.Join(home, "if0.env"), common.If0Default)
+	assert.Error(t, SelectProfile("unknown"))
+}
+
+func TestUseProfile(t *testing.T) {
+	_, cleanup := setupProfileTest(t)
+	defer cleanup()
+
+	assert.Equal(t, DefaultProfile, ActiveProfile())
+	assert.Error(t, UseProfile("acme"))
+	_, _ = CreateProfile("acme")
+	_, _ = CreateProfile("beta")
+	assert.Nil(t, UseProfile("acme"))
+	assert.Equal(t, "acme", ActiveProfile())
+	assert.Equal(t, "acme", ResolveProfile(""))
+	assert.Equal(t, "beta", ResolveProfile("beta"))
+
+	_ = os.Setenv(ProfileKey, "beta")
+	defer os.Unsetenv(ProfileKey)
+	assert.Equal(t, "beta", ResolveProfile(""))
+
+	profiles, err := ListProfiles()
+	assert.Nil(t, err)
+	var names []string
+	for _, p := range profiles {
+		names = append(names, p.Name)
+	}
+	assert.Equal(t, []string{DefaultProfile, "acme", "beta"}, names)
+}
+
+func TestProfilesHaveSeparateConfig(t *testing.T) {
+	_, cleanup := setupProfileTest(t)
+	defer cleanup()
+	_, _ = CreateProfile("acme")
+
+	assert.Nil(t, SetConfigValue(common.If0Default, "IF0_REGISTRY_URL", "https://gitlab.com"))
+	assert.Nil(t, SelectProfile("acme"))
+	assert.Nil(t, SetConfigValue(common.If0Default, "IF0_REGISTRY_URL", "https://git.acme.com"))
+
+	val, _ := GetConfigValue(common.If0Default, "IF0_REGISTRY_URL")
+	assert.Equal(t, "https://git.acme.com", val)
+	assert.Nil(t, SelectProfile(DefaultProfile))
+	val, _ = GetConfigValue(common.If0Default, "IF0_REGISTRY_URL")
+	assert.Equal(t, "https://gitlab.com", val)
+}
@@ -0,0 +1,128 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Resolver expands ${VAR} and ${VAR:-default} references in configuration values.
+// References are looked up in the layers, a later layer takes precedence over an earlier one.
+// `$$` is an escaped `$`, as in the htpasswd hashes of zero.env,
+// and a `$` that does not start a reference is kept as it is.
+// Literal values of a layer, e.g. decrypted secrets, are not expanded.
+type Resolver struct {
+	layers []*Layer
+}
+
+// NewResolver creates a resolver over layers, ordered from the lowest to the highest precedence
+func NewResolver(layers ...*Layer) *Resolver {
+	return &Resolver{layers: layers}
+}
+
+// Layers returns the layers of the resolver, ordered from the lowest to the highest precedence
+func (r *Resolver) Layers() []*Layer {
+	return r.layers
+}
+
+// Lookup returns the raw, unexpanded value of key from the layer with the highest precedence
+func (r *Resolver) Lookup(key string) (string, bool) {
+	for i := len(r.layers) - 1; i >= 0; i-- {
+		if val, ok := r.layers[i].Values[key]; ok {
+			return val, true
+		}
+	}
+	return "", false
+}
+
+// Resolve returns the expanded value of key
+func (r *Resolver) Resolve(key string) (string, error) {
+	return r.resolve(key, nil)
+}
+
+// Expand expands all references in value
+func (r *Resolver) Expand(value string) (string, error) {
+	return r.expand(value, nil)
+}
+
+func (r *Resolver) resolve(key string, stack []string) (string, error) {
+	for _, k := range stack {
+		if k == key {
+			return "", fmt.Errorf("reference cycle: %s -> %s", strings.Join(stack, " -> "), key)
+		}
+	}
+	for i := len(r.layers) - 1; i >= 0; i-- {
+		layer := r.layers[i]
+		if val, ok := layer.Values[key]; ok {
+			if layer.Literal[key] {
+				return val, nil
+			}
+			return r.expand(val, append(stack, key))
+		}
+	}
+	return "", nil
+}
+
+func (r *Resolver) expand(value string, stack []string) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] != '$' || i == len(value)-1 {
+			b.WriteByte(value[i])
+			continue
+		}
+		switch value[i+1] {
+		case '$':
+			b.WriteByte('$')
+			i++
+		case '{':
+			end := closingBrace(value, i+1)
+			if end < 0 {
+				return "", fmt.Errorf("unterminated reference in %q", value)
+			}
+			expanded, err := r.expandReference(value[i+2:end], stack)
+			if err != nil {
+				return "", err
+			}
+			b.WriteString(expanded)
+			i = end
+		default:
+			b.WriteByte('$')
+		}
+	}
+	return b.String(), nil
+}
+
+// expandReference expands the inside of ${...}: VAR or VAR:-default
+func (r *Resolver) expandReference(ref string, stack []string) (string, error) {
+	key, def, hasDefault := ref, "", false
+	if i := strings.Index(ref, ":-"); i >= 0 {
+		key, def, hasDefault = ref[:i], ref[i+2:], true
+	}
+	if !isValidKey(key) {
+		return "", fmt.Errorf("invalid reference ${%s}", ref)
+	}
+	val, err := r.resolve(key, stack)
+	if err != nil {
+		return "", err
+	}
+	if val == "" && hasDefault {
+		return r.expand(def, stack)
+	}
+	return val, nil
+}
+
+// closingBrace returns the index of the brace closing the one at start, or -1
+func closingBrace(s string, start int) int {
+	depth := 0
+	for i := start; i < len(s); i++ {
+		switch s[i] {
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
@@ -0,0 +1,100 @@
+package config
+
+import (
+	"github.com/stretchr/testify/assert"
+	"if0/common"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func testLayer(name string, values map[string]string) *Layer {
+	layer := &Layer{Name: name, Source: name, Values: values}
+	for k := range values {
+		layer.Keys = append(layer.Keys, k)
+	}
+	return layer
+}
+
+func TestResolverExpand(t *testing.T) {
+	if0 := testLayer("if0.env", map[string]string{
+		"DOMAIN":   "example.com",
+		"REGISTRY": "registry.${DOMAIN}",
+	})
+	zero := testLayer("zero.env", map[string]string{
+		"ZERO_BASE_DOMAIN":         "${DOMAIN}",
+		"ZERO_ADMIN_PASSWORD_HASH": "$$2y$$05$$abc",
+		"NODES":                    "${ZERO_NODES_MANAGER:-10.0.0.1}",
+		"IMAGE":                    "${REGISTRY}/zero:${TAG:-${DEFAULT_TAG:-latest}}",
+		"PRICE":                    "5$",
+	})
+	env := testLayer("process environment", map[string]string{"DOMAIN": "override.io"})
+	r := NewResolver(if0, zero, env)
+
+	cases := map[string]string{
+		"ZERO_BASE_DOMAIN":         "override.io",
+		"ZERO_ADMIN_PASSWORD_HASH": "$2y$05$abc",
+		"NODES":                    "10.0.0.1",
+		"IMAGE":                    "registry.override.io/zero:latest",
+		"PRICE":                    "5$",
+		"MISSING":                  "",
+	}
+	for key, expected := range cases {
+		val, err := r.Resolve(key)
+		assert.Nil(t, err)
+		assert.Equal(t, expected, val, key)
+	}
+	raw, ok := r.Lookup("ZERO_BASE_DOMAIN")
+	assert.True(t, ok)
+	assert.Equal(t, "${DOMAIN}", raw)
+}
+
+func TestResolverErrors(t *testing.T) {
+	r := NewResolver(testLayer("zero.env", map[string]string{
+		"A":     "${B}",
+		"B":     "x-${C}",
+		"C":     "${A}",
+		"OPEN":  "${A",
+		"BAD":   "${not valid}",
+		"SELF":  "${SELF:-x}",
+		"OTHER": "${A:-fallback}",
+	}))
+	_, err := r.Resolve("A")
+	assert.EqualError(t, err, "reference cycle: A -> B -> C -> A")
+	_, err = r.Resolve("SELF")
+	assert.EqualError(t, err, "reference cycle: SELF -> SELF")
+	_, err = r.Resolve("OTHER")
+	assert.Error(t, err)
+	_, err = r.Resolve("OPEN")
+	assert.Error(t, err)
+	_, err = r.Resolve("BAD")
+	assert.Error(t, err)
+}
+
+func TestLoadEnvironmentResolver(t *testing.T) {
+	dir, err := ioutil.TempDir("", "if0-resolve")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	common.If0Default = filepath.Join(dir, "if0.env")
+	_ = ioutil.WriteFile(common.If0Default, []byte("IF0_REGISTRY_URL=https://gitlab.com\n"), 0644)
+	envDir := filepath.Join(dir, "env")
+	_ = os.MkdirAll(envDir, 0755)
+
+	_, err = LoadEnvironmentResolver(envDir, nil)
+	assert.EqualError(t, err, "no .env files found")
+
+	_ = ioutil.WriteFile(filepath.Join(envDir, "zero.env"), []byte("IF0_ENVIRONMENT=sample-repo\n"), 0644)
+	_ = ioutil.WriteFile(filepath.Join(envDir, "dash1.env"), []byte("REPO=${IF0_REGISTRY_URL}/${IF0_ENVIRONMENT}\n"), 0644)
+	r, err := LoadEnvironmentResolver(envDir, nil)
+	assert.Nil(t, err)
+	val, err := r.Resolve("REPO")
+	assert.Nil(t, err)
+	assert.Equal(t, "https://gitlab.com/sample-repo", val)
+
+	_ = os.Setenv("IF0_ENVIRONMENT", "from-process")
+	defer os.Unsetenv("IF0_ENVIRONMENT")
+	r, _ = LoadEnvironmentResolver(envDir, nil)
+	val, _ = r.Resolve("REPO")
+	assert.Equal(t, "https://gitlab.com/from-process", val)
+}
@@ -0,0 +1,351 @@
+package config
+
+import (
+	"fmt"
+	"if0/common"
+	"if0/common/sync"
+	"net"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Key types of the configuration schema
+const (
+	TypeString   = "string"
+	TypeBool     = "bool"
+	TypeInt      = "int"
+	TypeDuration = "duration"
+	TypeURL      = "url"
+	TypeIPList   = "iplist"
+	TypeDomain   = "domain"
+	TypeEnum     = "enum"
+)
+
+// Scopes of the configuration schema: keys of ~/.if0/if0.env and keys of an environment's *.env files
+const (
+	GlobalScope      = "global"
+	EnvironmentScope = "environment"
+)
+
+// NoModule stands for environments without DASH1_MODULE in KeySpec.RequiredFor,
+// i.e. environments whose nodes are not provisioned by dash1
+const NoModule = ""
+
+// Dash1ModuleKey selects the dash1 provider module of an environment
+const Dash1ModuleKey = "DASH1_MODULE"
+
+// KeySpec declares a known configuration key
+type KeySpec struct {
+	Key   string
+	Scope string
+	Type  string
+	// Values allowed for TypeEnum
+	Values []string
+	// Required keys must be set to a non-empty value
+	Required bool
+	// RequiredFor lists the DASH1_MODULE values the key is required for, NoModule included
+	RequiredFor []string
+	// Secret values are encrypted by `if0 secrets encrypt`
+	Secret bool
+	// Pattern restricts the values of TypeString keys
+	Pattern *regexp.Regexp
+	// Deprecated keys are reported as warnings, ReplacedBy names the key to use instead
+	Deprecated  bool
+	ReplacedBy  string
+	Description string
+}
+
+// Issue is a problem found by the schema validation
+type Issue struct {
+	Key    string
+	Source string
+	Msg    string
+	// Warning issues, such as deprecations, do not fail the validation
+	Warning bool
+}
+
+func (i Issue) String() string {
+	level := "Error"
+	if i.Warning {
+		level = "Warning"
+	}
+	if i.Source == "" {
+		return fmt.Sprintf("%s: %s %s", level, i.Key, i.Msg)
+	}
+	return fmt.Sprintf("%s: %s %s (%s)", level, i.Key, i.Msg, i.Source)
+}
+
+var schema = make(map[string]*KeySpec)
+
+var domainRegexp = regexp.MustCompile(`^([a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?\.)+[a-zA-Z]{2,63}\.?$`)
+
+func init() {
+	for _, spec := range []KeySpec{
+		{Key: common.IF0_VERSION, Scope: GlobalScope, Type: TypeInt, Required: true,
+			Description: "version of the if0 configuration"},
+		{Key: "SHIPMATE_WORKFLOW_URL", Scope: GlobalScope, Type: TypeURL,
+			Description: "shipmate workflow included in the .gitlab-ci.yml of new environments"},
+		{Key: "GL_TOKEN", Scope: GlobalScope, Type: TypeString, Secret: true,
+			Description: "GitLab token used to create environment repositories"},
+		{Key: "GITLAB_HOST", Scope: GlobalScope, Type: TypeString,
+			Description: "host of a self-hosted GitLab, GL_TOKEN is only sent to gitlab.com and this host"},
+		{Key: "GIT_TOKEN", Scope: GlobalScope, Type: TypeString, Secret: true,
+			Description: "HTTP token for the git remotes on GIT_TOKEN_HOST, if GL_TOKEN is not used"},
+		{Key: "GIT_TOKEN_HOST", Scope: GlobalScope, Type: TypeString,
+			Description: "comma separated hosts GIT_TOKEN is sent to"},
+		{Key: "GIT_USERNAME", Scope: GlobalScope, Type: TypeString,
+			Description: "user name sent with GL_TOKEN or GIT_TOKEN"},
+		{Key: "IF0_GIT_SSH_KEY", Scope: GlobalScope, Type: TypeString,
+			Description: "SSH key for git remotes instead of the keys in ~/.ssh"},
+		{Key: "IF0_GIT_SSH_PASSPHRASE", Scope: GlobalScope, Type: TypeString, Secret: true,
+			Description: "passphrase of the SSH key for git remotes"},
+		{Key: sync.HostKeyPolicyKey, Scope: GlobalScope, Type: TypeEnum, Values: sync.HostKeyPolicies,
+			Description: "verification of the host keys of git remotes (default tofu)"},
+		{Key: "IF0_REGISTRY_URL", Scope: GlobalScope, Type: TypeURL,
+			Description: "GitLab instance hosting the environment repositories"},
+		{Key: "IF0_REGISTRY_USER", Scope: GlobalScope, Type: TypeString},
+		{Key: "IF0_REGISTRY_GROUP", Scope: GlobalScope, Type: TypeString},
+		{Key: "GC_AUTO", Scope: GlobalScope, Type: TypeBool},
+		{Key: "GC_PERIOD", Scope: GlobalScope, Type: TypeInt},
+		{Key: "GC_KEEP_LAST", Scope: GlobalScope, Type: TypeInt},
+		{Key: "GC_MAX_SIZE", Scope: GlobalScope, Type: TypeString},
+		{Key: "IF0_ADMIN_PASSWORD_LENGTH", Scope: GlobalScope, Type: TypeInt,
+			Description: "length of generated admin passwords"},
+		{Key: "IF0_ADMIN_PASSWORD_ALPHABET", Scope: GlobalScope, Type: TypeString,
+			Description: "characters of generated admin passwords"},
+		{Key: "IF0_SSH_KEY_TYPE", Scope: GlobalScope, Type: TypeEnum, Values: []string{"ed25519", "ecdsa", "rsa"},
+			Description: "type of the SSH keys of new environments"},
+		{Key: "IF0_SSH_KEY_BITS", Scope: GlobalScope, Type: TypeInt,
+			Description: "bits of the SSH keys of new environments, rsa and ecdsa only"},
+		{Key: "IF0_CURRENT_ENV", Scope: GlobalScope, Type: TypeString,
+			Description: "environment used by commands without an environment argument, set by `if0 use`"},
+
+		{Key: "IF0_ENVIRONMENT", Scope: EnvironmentScope, Type: TypeString, Required: true,
+			Description: "name of the environment"},
+		{Key: EnvVersionKey, Scope: EnvironmentScope, Type: TypeInt,
+			Description: "version of the environment configuration"},
+		{Key: "ZERO_ADMIN_USER", Scope: EnvironmentScope, Type: TypeString, Required: true},
+		{Key: "ZERO_ADMIN_PASSWORD", Scope: EnvironmentScope, Type: TypeString, Required: true, Secret: true},
+		{Key: "ZERO_ADMIN_PASSWORD_HASH", Scope: EnvironmentScope, Type: TypeString, Required: true},
+		{Key: "ZERO_BASE_DOMAIN", Scope: EnvironmentScope, Type: TypeDomain},
+		{Key: "ZERO_NODES_MANAGER", Scope: EnvironmentScope, Type: TypeIPList, RequiredFor: []string{NoModule},
+			Description: "IPs of the manager nodes, if the nodes are not provisioned by dash1"},
+		// the values of DASH1_MODULE and the keys of the providers are declared with RegisterModule
+		{Key: Dash1ModuleKey, Scope: EnvironmentScope, Type: TypeEnum},
+		{Key: "AWS_SECRET_KEY_ID", Scope: EnvironmentScope, Type: TypeString, Secret: true,
+			Deprecated: true, ReplacedBy: "AWS_ACCESS_KEY_ID"},
+	} {
+		RegisterKey(spec)
+	}
+}
+
+// RegisterKey adds a key to the schema, replacing an existing declaration of the same key
+func RegisterKey(spec KeySpec) {
+	s := spec
+	schema[s.Key] = &s
+}
+
+// RegisterModule declares a DASH1_MODULE value and the keys of its provider.
+// keys marked Required are only required for this module, keys shared by several modules are required for each of them.
+func RegisterModule(module string, keys ...KeySpec) {
+	moduleSpec := schema[Dash1ModuleKey]
+	if !containsString(moduleSpec.Values, module) {
+		moduleSpec.Values = append(moduleSpec.Values, module)
+	}
+	for _, spec := range keys {
+		if spec.Scope == "" {
+			spec.Scope = EnvironmentScope
+		}
+		var requiredFor []string
+		if existing, ok := schema[spec.Key]; ok {
+			requiredFor = append(requiredFor, existing.RequiredFor...)
+		}
+		if spec.Required && !containsString(requiredFor, module) {
+			requiredFor = append(requiredFor, module)
+		}
+		spec.Required = false
+		spec.RequiredFor = requiredFor
+		RegisterKey(spec)
+	}
+}
+
+// LookupKey returns the declaration of a key
+func LookupKey(key string) (*KeySpec, bool) {
+	spec, ok := schema[key]
+	return spec, ok
+}
+
+// SchemaKeys returns the declarations of the given scope, sorted by key
+func SchemaKeys(scope string) []*KeySpec {
+	var specs []*KeySpec
+	for _, spec := range schema {
+		if spec.Scope == scope {
+			specs = append(specs, spec)
+		}
+	}
+	sort.Slice(specs, func(i, j int) bool {
+		return specs[i].Key < specs[j].Key
+	})
+	return specs
+}
+
+// Validate checks the configuration of the given scope against the schema.
+// values are validated after ${VAR} references are resolved, unknown keys are ignored.
+func (r *Resolver) Validate(scope string) []Issue {
+	kinds := []string{EnvFileLayer, OverrideLayer}
+	if scope == GlobalScope {
+		kinds = []string{GlobalLayer}
+	}
+	module, _ := r.Resolve(Dash1ModuleKey)
+	var issues []Issue
+	defined := make(map[string]bool)
+	for _, key := range r.Keys(kinds...) {
+		defined[key] = true
+		spec, ok := LookupKey(key)
+		if !ok || spec.Scope != scope {
+			continue
+		}
+		e := r.Explain(key)
+		source := layerSource(e.Origin)
+		if spec.Deprecated {
+			msg := "is deprecated"
+			if spec.ReplacedBy != "" {
+				msg += ", use " + spec.ReplacedBy + " instead"
+			}
+			issues = append(issues, Issue{Key: key, Source: source, Msg: msg, Warning: true})
+		}
+		if e.Err != nil {
+			issues = append(issues, Issue{Key: key, Source: source, Msg: e.Err.Error()})
+			continue
+		}
+		if e.Value == "" {
+			if spec.required(module) {
+				issues = append(issues, Issue{Key: key, Source: source, Msg: "is required" + spec.requiredFor(module)})
+			}
+			continue
+		}
+		if IsEncrypted(e.Value) || IsSecretRef(e.Value) {
+			continue
+		}
+		if err := spec.Check(e.Value); err != nil {
+			issues = append(issues, Issue{Key: key, Source: source, Msg: err.Error()})
+		}
+	}
+	for _, spec := range SchemaKeys(scope) {
+		if defined[spec.Key] || !spec.required(module) {
+			continue
+		}
+		if val, _ := r.Resolve(spec.Key); val != "" {
+			continue
+		}
+		issues = append(issues, Issue{Key: spec.Key, Msg: "is required" + spec.requiredFor(module)})
+	}
+	return issues
+}
+
+// HasErrors reports whether issues contains anything but warnings
+func HasErrors(issues []Issue) bool {
+	for _, issue := range issues {
+		if !issue.Warning {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateEnvironment validates the layered configuration of the environment at envDir
+func ValidateEnvironment(envDir string, overrides []string) ([]Issue, error) {
+	resolver, err := LoadEnvironmentResolver(envDir, overrides)
+	if err != nil {
+		return nil, err
+	}
+	return resolver.Validate(EnvironmentScope), nil
+}
+
+// ValidateGlobal validates ~/.if0/if0.env
+func ValidateGlobal(configFile string) ([]Issue, error) {
+	layer, err := NewFileLayer(configFile, GlobalLayer)
+	if err != nil {
+		return nil, err
+	}
+	return NewResolver(layer).Validate(GlobalScope), nil
+}
+
+func (s *KeySpec) required(module string) bool {
+	if s.Required {
+		return true
+	}
+	return containsString(s.RequiredFor, module)
+}
+
+func (s *KeySpec) requiredFor(module string) string {
+	if s.Required {
+		return ""
+	}
+	if module == NoModule {
+		return " without " + Dash1ModuleKey
+	}
+	return " for " + Dash1ModuleKey + "=" + module
+}
+
+// Check validates a non-empty value against the type of the key
+func (s *KeySpec) Check(value string) error {
+	switch s.Type {
+	case TypeBool:
+		if _, err := parseGcAutoStrict(value); err != nil {
+			return fmt.Errorf("must be a boolean, got %q", value)
+		}
+	case TypeInt:
+		if _, err := strconv.Atoi(strings.TrimSpace(value)); err != nil {
+			return fmt.Errorf("must be a number, got %q", value)
+		}
+	case TypeDuration:
+		if _, err := time.ParseDuration(strings.TrimSpace(value)); err != nil {
+			return fmt.Errorf("must be a duration such as 90s or 1h30m, got %q", value)
+		}
+	case TypeURL:
+		u, err := url.Parse(strings.TrimSpace(value))
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("must be an absolute URL, got %q", value)
+		}
+	case TypeIPList:
+		for _, ip := range splitList(value) {
+			if net.ParseIP(ip) == nil {
+				return fmt.Errorf("must be a list of IP addresses, %q is not an IP address", ip)
+			}
+		}
+	case TypeDomain:
+		if !domainRegexp.MatchString(strings.TrimSpace(value)) {
+			return fmt.Errorf("must be a domain name, got %q", value)
+		}
+	case TypeEnum:
+		if !containsString(s.Values, strings.TrimSpace(value)) {
+			return fmt.Errorf("must be one of %s, got %q", strings.Join(s.Values, ", "), value)
+		}
+	}
+	if s.Pattern != nil && !s.Pattern.MatchString(strings.TrimSpace(value)) {
+		return fmt.Errorf("must match %s, got %q", s.Pattern, value)
+	}
+	return nil
+}
+
+// splitList splits comma or whitespace separated values
+func splitList(value string) []string {
+	return strings.FieldsFunc(value, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t'
+	})
+}
+
+func layerSource(layer *Layer) string {
+	if layer == nil {
+		return ""
+	}
+	if layer.Source == "" {
+		return layer.Name
+	}
+	return layer.Source
+}
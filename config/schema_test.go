@@ -0,0 +1,106 @@
+package config
+
+import (
+	"github.com/stretchr/testify/assert"
+	"regexp"
+	"testing"
+)
+
+func init() {
+	// the providers are registered by the environments package
+	RegisterModule("hcloud", KeySpec{Key: "HCLOUD_TOKEN", Type: TypeString, Required: true, Secret: true})
+}
+
+func envResolver(values map[string]string) *Resolver {
+	layer := testLayer("zero.env", values)
+	layer.Kind = EnvFileLayer
+	return NewResolver(layer)
+}
+
+func issueStrings(issues []Issue) []string {
+	var out []string
+	for _, issue := range issues {
+		out = append(out, issue.String())
+	}
+	return out
+}
+
+func TestKeySpecCheck(t *testing.T) {
+	tests := []struct {
+		spec  KeySpec
+		value string
+		valid bool
+	}{
+		{KeySpec{Type: TypeBool}, "yes", true},
+		{KeySpec{Type: TypeBool}, "maybe", false},
+		{KeySpec{Type: TypeInt}, "30", true},
+		{KeySpec{Type: TypeInt}, "thirty", false},
+		{KeySpec{Type: TypeDuration}, "1h30m", true},
+		{KeySpec{Type: TypeDuration}, "90", false},
+		{KeySpec{Type: TypeURL}, "https://gitlab.com", true},
+		{KeySpec{Type: TypeURL}, "gitlab.com", false},
+		{KeySpec{Type: TypeIPList}, "10.0.0.1, 10.0.0.2 ::1", true},
+		{KeySpec{Type: TypeIPList}, "10.0.0.1,node-2", false},
+		{KeySpec{Type: TypeDomain}, "zero.example.com", true},
+		{KeySpec{Type: TypeDomain}, "https://example.com", false},
+		{KeySpec{Type: TypeEnum, Values: []string{"hcloud", "aws"}}, "aws", true},
+		{KeySpec{Type: TypeEnum, Values: []string{"hcloud", "aws"}}, "gcp", false},
+		{KeySpec{Type: TypeString}, "anything", true},
+	}
+	for _, test := range tests {
+		err := test.spec.Check(test.value)
+		assert.Equal(t, test.valid, err == nil, "%s %q", test.spec.Type, test.value)
+	}
+}
+
+func TestValidateRequiredPerModule(t *testing.T) {
+	base := map[string]string{
+		"IF0_ENVIRONMENT":          "env",
+		"ZERO_ADMIN_USER":          "admin",
+		"ZERO_ADMIN_PASSWORD":      "secret",
+		"ZERO_ADMIN_PASSWORD_HASH": "$$2y$$05$$abc",
+	}
+	issues := envResolver(base).Validate(EnvironmentScope)
+	assert.Equal(t, []string{"Error: ZERO_NODES_MANAGER is required without DASH1_MODULE"}, issueStrings(issues))
+
+	base["DASH1_MODULE"] = "hcloud"
+	base["HCLOUD_TOKEN"] = ""
+	issues = envResolver(base).Validate(EnvironmentScope)
+	assert.Equal(t, []string{"Error: HCLOUD_TOKEN is required for DASH1_MODULE=hcloud (zero.env)"}, issueStrings(issues))
+
+	base["HCLOUD_TOKEN"] = "token"
+	base["ZERO_BASE_DOMAIN"] = "not a domain"
+	issues = envResolver(base).Validate(EnvironmentScope)
+	assert.Equal(t, []string{`Error: ZERO_BASE_DOMAIN must be a domain name, got "not a domain" (zero.env)`}, issueStrings(issues))
+	assert.True(t, HasErrors(issues))
+}
+
+func TestValidateDeprecated(t *testing.T) {
+	RegisterKey(KeySpec{Key: "TEST_OLD_KEY", Scope: GlobalScope, Type: TypeInt, Deprecated: true, ReplacedBy: "TEST_NEW_KEY"})
+	defer delete(schema, "TEST_OLD_KEY")
+	layer := testLayer("if0.env", map[string]string{"IF0_VERSION": "1", "TEST_OLD_KEY": "1", "UNKNOWN": "x"})
+	layer.Kind = GlobalLayer
+	issues := NewResolver(layer).Validate(GlobalScope)
+	assert.Equal(t, []string{"Warning: TEST_OLD_KEY is deprecated, use TEST_NEW_KEY instead (if0.env)"}, issueStrings(issues))
+	assert.False(t, HasErrors(issues))
+}
+
+func TestRegisterModule(t *testing.T) {
+	RegisterModule("test-a", KeySpec{Key: "TEST_SHARED_KEY", Type: TypeString, Required: true,
+		Pattern: regexp.MustCompile(`^[a-z]+$`)})
+	RegisterModule("test-b", KeySpec{Key: "TEST_SHARED_KEY", Type: TypeString, Required: true,
+		Pattern: regexp.MustCompile(`^[a-z]+$`)})
+	defer delete(schema, "TEST_SHARED_KEY")
+	moduleSpec, _ := LookupKey(Dash1ModuleKey)
+	defer func() {
+		moduleSpec.Values = moduleSpec.Values[:len(moduleSpec.Values)-2]
+	}()
+
+	assert.Nil(t, moduleSpec.Check("test-b"))
+	spec, ok := LookupKey("TEST_SHARED_KEY")
+	assert.True(t, ok)
+	assert.Equal(t, EnvironmentScope, spec.Scope)
+	assert.Equal(t, []string{"test-a", "test-b"}, spec.RequiredFor)
+	assert.False(t, spec.Required)
+	assert.EqualError(t, spec.Check("ABC"), `must match ^[a-z]+$, got "ABC"`)
+}
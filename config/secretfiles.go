@@ -0,0 +1,304 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// EncryptEnvironment encrypts the given keys in all *.env files of an environment
+// for the recipients of the environment. without keys, all keys declared secret in the schema are encrypted.
+// returns the number of values encrypted.
+func EncryptEnvironment(envDir string, keys []string) (int, error) {
+	unlock, err := LockConfig()
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+	id, err := LoadOrCreateIdentity()
+	if err != nil {
+		return 0, errors.Wrap(err, "reading identity")
+	}
+	recipients, err := environmentRecipients(envDir, id)
+	if err != nil {
+		return 0, errors.Wrap(err, "reading recipients")
+	}
+	env, err := loadEnvironmentConfig(envDir)
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for _, f := range env.Files {
+		for _, key := range selectSecretKeys(f.Doc, keys) {
+			val, _ := f.Doc.Get(key)
+			if val == "" || IsEncrypted(val) {
+				continue
+			}
+			enc, err := EncryptValue(val, filepath.Base(f.Path), key, recipients)
+			if err != nil {
+				return count, errors.Wrapf(err, "encrypting %s", key)
+			}
+			f.Doc.Set(key, enc)
+			count++
+		}
+	}
+	// a snapshot would keep the plaintext the values are encrypted to hide
+	return count, writeChangedFiles(env, false)
+}
+
+// EnvironmentRecipients returns the recipients the secrets of an environment are encrypted for, as EncryptEnvironment
+// does. the caller holds the configuration lock.
+func EnvironmentRecipients(envDir string) ([]string, error) {
+	id, err := LoadOrCreateIdentity()
+	if err != nil {
+		return nil, errors.Wrap(err, "reading identity")
+	}
+	return environmentRecipients(envDir, id)
+}
+
+// DecryptEnvironment writes the given keys of an environment back in plaintext,
+// without keys, all encrypted values are decrypted. returns the number of values decrypted.
+func DecryptEnvironment(envDir string, keys []string) (int, error) {
+	unlock, err := LockConfig()
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+	id, err := LoadIdentity()
+	if err != nil {
+		return 0, errors.Wrap(err, "reading identity")
+	}
+	env, err := loadEnvironmentConfig(envDir)
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for _, f := range env.Files {
+		for _, key := range f.Doc.Keys() {
+			val, _ := f.Doc.Get(key)
+			if !IsEncrypted(val) || (len(keys) > 0 && !containsString(keys, key)) {
+				continue
+			}
+			plain, err := DecryptValue(val, filepath.Base(f.Path), key, id)
+			if err != nil {
+				return count, errors.Wrapf(err, "decrypting %s in %s", key, f.Path)
+			}
+			f.Doc.Set(key, plain)
+			count++
+		}
+	}
+	return count, writeChangedFiles(env, true)
+}
+
+// ReencryptEnvironment re-encrypts all encrypted values of an environment for the recipients
+// and writes the recipients file. id must be able to decrypt the current values.
+func ReencryptEnvironment(envDir string, id *Identity, recipients []string) error {
+	return withConfigLock(func() error {
+		return reencryptEnvironment(envDir, id, recipients)
+	})
+}
+
+func reencryptEnvironment(envDir string, id *Identity, recipients []string) error {
+	for _, r := range recipients {
+		if _, err := ParseRecipient(r); err != nil {
+			return err
+		}
+	}
+	env, err := loadEnvironmentConfig(envDir)
+	if err != nil {
+		return err
+	}
+	for _, f := range env.Files {
+		for _, key := range f.Doc.Keys() {
+			val, _ := f.Doc.Get(key)
+			if !IsEncrypted(val) {
+				continue
+			}
+			plain, err := DecryptValue(val, filepath.Base(f.Path), key, id)
+			if err != nil {
+				return errors.Wrapf(err, "decrypting %s in %s", key, f.Path)
+			}
+			enc, err := EncryptValue(plain, filepath.Base(f.Path), key, recipients)
+			if err != nil {
+				return errors.Wrapf(err, "encrypting %s", key)
+			}
+			f.Doc.Set(key, enc)
+		}
+	}
+	err = writeChangedFiles(env, true)
+	if err != nil {
+		return err
+	}
+	return WriteRecipients(envDir, recipients)
+}
+
+// AddRecipient shares the secrets of an environment with the owner of a public key
+func AddRecipient(envDir, recipient string) error {
+	unlock, err := LockConfig()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	id, err := LoadIdentity()
+	if err != nil {
+		return errors.Wrap(err, "reading identity")
+	}
+	recipients, err := environmentRecipients(envDir, id)
+	if err != nil {
+		return err
+	}
+	if containsString(recipients, recipient) {
+		return fmt.Errorf("%s is already a recipient", recipient)
+	}
+	return reencryptEnvironment(envDir, id, append(recipients, recipient))
+}
+
+// RemoveRecipient re-encrypts the secrets of an environment without the given public key.
+// secrets known to the removed recipient should be rotated as well.
+func RemoveRecipient(envDir, recipient string) error {
+	unlock, err := LockConfig()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	id, err := LoadIdentity()
+	if err != nil {
+		return errors.Wrap(err, "reading identity")
+	}
+	recipients, err := ReadRecipients(envDir)
+	if err != nil {
+		return err
+	}
+	var remaining []string
+	for _, r := range recipients {
+		if r != recipient {
+			remaining = append(remaining, r)
+		}
+	}
+	if len(remaining) == len(recipients) {
+		return fmt.Errorf("%s is not a recipient", recipient)
+	}
+	if len(remaining) == 0 {
+		return errors.New("an environment needs at least one recipient")
+	}
+	return reencryptEnvironment(envDir, id, remaining)
+}
+
+// RotateIdentity replaces the identity of the user with a new key pair and re-encrypts
+// the secrets of every environment the old key is a recipient of.
+// the old key is kept as identity.key.old. returns the re-encrypted environments.
+func RotateIdentity() ([]string, error) {
+	unlock, err := LockConfig()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	old, err := LoadIdentity()
+	if err != nil {
+		return nil, errors.Wrap(err, "reading identity")
+	}
+	id, err := GenerateIdentity()
+	if err != nil {
+		return nil, err
+	}
+	envs, err := loadEnvironmentConfigs()
+	if err != nil {
+		return nil, err
+	}
+	var rotated []string
+	for _, env := range envs {
+		recipients, err := ReadRecipients(env.Dir)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return rotated, errors.Wrap(err, env.Dir)
+		}
+		if !containsString(recipients, old.Recipient()) {
+			continue
+		}
+		for i, r := range recipients {
+			if r == old.Recipient() {
+				recipients[i] = id.Recipient()
+			}
+		}
+		err = reencryptEnvironment(env.Dir, old, recipients)
+		if err != nil {
+			return rotated, errors.Wrap(err, env.Dir)
+		}
+		rotated = append(rotated, env.Dir)
+	}
+	data, err := ioutil.ReadFile(IdentityFile())
+	if err != nil {
+		return rotated, err
+	}
+	err = writeFileAtomic(IdentityFile()+".old", data, 0600)
+	if err != nil {
+		return rotated, err
+	}
+	return rotated, SaveIdentity(id)
+}
+
+// DecryptSecrets decrypts the ENC[...] values of all layers with the identity of the user.
+// the identity is only read if there are encrypted values. decrypted values are taken literally,
+// a $ in a password is not expanded.
+func (r *Resolver) DecryptSecrets() error {
+	var id *Identity
+	for _, layer := range r.layers {
+		for key, val := range layer.Values {
+			if !IsEncrypted(val) {
+				continue
+			}
+			if id == nil {
+				var err error
+				id, err = LoadIdentity()
+				if err != nil {
+					return errors.Wrap(err, "reading identity to decrypt secrets")
+				}
+			}
+			plain, err := DecryptValue(val, layer.Name, key, id)
+			if err != nil {
+				return errors.Wrapf(err, "decrypting %s in %s", key, layerSource(layer))
+			}
+			layer.setLiteral(key, plain)
+		}
+	}
+	return nil
+}
+
+// selectSecretKeys returns keys if given, otherwise the keys of doc declared secret in the schema
+func selectSecretKeys(doc *Document, keys []string) []string {
+	if len(keys) > 0 {
+		return keys
+	}
+	var secrets []string
+	for _, key := range doc.Keys() {
+		if spec, ok := LookupKey(key); ok && spec.Secret {
+			secrets = append(secrets, key)
+		}
+	}
+	return secrets
+}
+
+// writeChangedFiles writes the files of an environment that have been changed, with snapshot they are snapshotted first
+func writeChangedFiles(env *EnvironmentConfig, snapshot bool) error {
+	for _, f := range env.Files {
+		rendered := f.Doc.Render()
+		if bytes.Equal(rendered, f.written) || (f.written == nil && len(f.Doc.Keys()) == 0) {
+			continue
+		}
+		if snapshot && isFilePresent(f.Path) {
+			if _, err := TakeSnapshot(f.Path); err != nil {
+				return errors.Wrapf(err, "snapshot of %s", filepath.Base(f.Path))
+			}
+		}
+		if err := f.Doc.WriteFile(f.Path, 0644); err != nil {
+			return err
+		}
+		f.written = rendered
+	}
+	return nil
+}
@@ -0,0 +1,315 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"if0/common"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// secretRefPrefix starts references to secrets kept in an external store:
+// secret://<provider>/<path>[#<field>], e.g. HCLOUD_TOKEN=secret://vault/kv/hcloud#token
+const secretRefPrefix = "secret://"
+
+// SecretProvider fetches secrets referenced by secret:// values
+type SecretProvider interface {
+	// Name is the provider part of a reference, e.g. vault
+	Name() string
+	// Fetch returns the secret at path. field selects a value of secrets holding several values.
+	Fetch(path, field string) (string, error)
+}
+
+// IsSecretRef reports whether value references a secret of a SecretProvider
+func IsSecretRef(value string) bool {
+	return strings.HasPrefix(value, secretRefPrefix)
+}
+
+// ParseSecretRef splits a secret:// reference into provider, path and field
+func ParseSecretRef(value string) (provider, path, field string, err error) {
+	if !IsSecretRef(value) {
+		return "", "", "", fmt.Errorf("%q is not a secret reference", value)
+	}
+	ref := strings.TrimPrefix(value, secretRefPrefix)
+	if i := strings.LastIndex(ref, "#"); i >= 0 {
+		ref, field = ref[:i], ref[i+1:]
+	}
+	parts := strings.SplitN(ref, "/", 2)
+	if len(parts) != 2 || parts[0] == "" || strings.Trim(parts[1], "/") == "" {
+		return "", "", "", fmt.Errorf("invalid secret reference %q, expected secret://provider/path[#field]", value)
+	}
+	return parts[0], strings.Trim(parts[1], "/"), field, nil
+}
+
+// DefaultSecretProviders returns the file, keyring, pass and vault providers, configured by if0.env
+// and the process environment of the resolver:
+//  IF0_SECRETS_DIR  directory of the file store (default: ~/.if0/secrets)
+//  PASS_COMMAND     pass compatible command (default: pass)
+//  VAULT_ADDR, VAULT_TOKEN, VAULT_NAMESPACE, VAULT_KV_VERSION (default: 2)
+// the *.env files of an environment are tracked in git, whoever can push to it must not be able
+// to point the providers to a command or a server of their choice.
+func DefaultSecretProviders(r *Resolver) map[string]SecretProvider {
+	settings := r.only(GlobalLayer, ProcessLayer)
+	get := func(key, def string) string {
+		if val, err := settings.Resolve(key); err == nil && val != "" {
+			return val
+		}
+		return def
+	}
+	providers := make(map[string]SecretProvider)
+	for _, p := range []SecretProvider{
+		&FileSecretProvider{Dir: get("IF0_SECRETS_DIR", filepath.Join(common.If0Dir, "secrets"))},
+		&KeyringSecretProvider{GOOS: runtime.GOOS},
+		&PassSecretProvider{Command: get("PASS_COMMAND", "pass")},
+		&VaultSecretProvider{
+			Address:   get("VAULT_ADDR", ""),
+			Token:     get("VAULT_TOKEN", ""),
+			Namespace: get("VAULT_NAMESPACE", ""),
+			KVVersion: get("VAULT_KV_VERSION", "2"),
+		},
+	} {
+		providers[p.Name()] = p
+	}
+	return providers
+}
+
+// ResolveSecretRefs replaces the secret:// references of all layers with the secrets they reference.
+// secrets are only kept in memory, they are never written back to the *.env files, and not expanded.
+func (r *Resolver) ResolveSecretRefs(providers map[string]SecretProvider) error {
+	for _, layer := range r.layers {
+		if layer.Kind == ProcessLayer {
+			continue
+		}
+		for key, val := range layer.Values {
+			if !IsSecretRef(val) {
+				continue
+			}
+			name, path, field, err := ParseSecretRef(val)
+			if err != nil {
+				return errors.Wrapf(err, "%s in %s", key, layerSource(layer))
+			}
+			provider, ok := providers[name]
+			if !ok {
+				return fmt.Errorf("%s in %s: unknown secret provider %q", key, layerSource(layer), name)
+			}
+			secret, err := provider.Fetch(path, field)
+			if err != nil {
+				return errors.Wrapf(err, "fetching %s from %s", key, name)
+			}
+			layer.setLiteral(key, secret)
+		}
+	}
+	return nil
+}
+
+// FileSecretProvider reads secrets from files below Dir. a file holds a single secret,
+// or with a field, KEY=VALUE pairs in .env format: secret://file/hcloud.env#token
+type FileSecretProvider struct {
+	Dir string
+}
+
+func (p *FileSecretProvider) Name() string {
+	return "file"
+}
+
+func (p *FileSecretProvider) Fetch(path, field string) (string, error) {
+	file := filepath.Join(p.Dir, filepath.FromSlash(path))
+	if rel, err := filepath.Rel(p.Dir, file); err != nil || strings.HasPrefix(rel, "..") {
+		return "", fmt.Errorf("secret %s is outside of %s", path, p.Dir)
+	}
+	if field == "" {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+	}
+	doc, err := ReadDocument(file)
+	if err != nil {
+		return "", err
+	}
+	val, ok := doc.Get(field)
+	if !ok {
+		return "", fmt.Errorf("%s not found in secret %s", field, path)
+	}
+	return val, nil
+}
+
+// PassSecretProvider reads secrets from the pass password store, or any command with the same interface.
+// the secret is the first line of `pass show path`, a field selects a following `field: value` line.
+type PassSecretProvider struct {
+	Command string
+	// run executes the command, it is replaced in tests
+	run func(name string, args ...string) ([]byte, error)
+}
+
+func (p *PassSecretProvider) Name() string {
+	return "pass"
+}
+
+func (p *PassSecretProvider) Fetch(path, field string) (string, error) {
+	run := p.run
+	if run == nil {
+		run = func(name string, args ...string) ([]byte, error) {
+			return exec.Command(name, args...).Output()
+		}
+	}
+	out, err := run(p.Command, "show", path)
+	if err != nil {
+		return "", errors.Wrapf(err, "%s show %s", p.Command, path)
+	}
+	lines := strings.Split(strings.TrimRight(string(out), "\r\n"), "\n")
+	if field == "" {
+		return strings.TrimRight(lines[0], "\r"), nil
+	}
+	for _, line := range lines[1:] {
+		pair := strings.SplitN(line, ":", 2)
+		if len(pair) == 2 && strings.TrimSpace(pair[0]) == field {
+			return strings.TrimSpace(pair[1]), nil
+		}
+	}
+	return "", fmt.Errorf("%s not found in secret %s", field, path)
+}
+
+// KeyringSecretProvider reads secrets from the keyring of the operating system, secret://keyring/service/account
+// is the password of account in service. on linux it is looked up with secret-tool (libsecret), on macOS in the
+// login keychain with security. with a field, the password holds KEY=VALUE pairs in .env format.
+type KeyringSecretProvider struct {
+	GOOS string
+	// run executes the command, it is replaced in tests
+	run func(name string, args ...string) ([]byte, error)
+}
+
+func (p *KeyringSecretProvider) Name() string {
+	return "keyring"
+}
+
+func (p *KeyringSecretProvider) Fetch(path, field string) (string, error) {
+	i := strings.LastIndex(path, "/")
+	if i < 0 {
+		return "", fmt.Errorf("invalid keyring path %q, expected service/account", path)
+	}
+	service, account := path[:i], path[i+1:]
+	var name string
+	var args []string
+	switch p.GOOS {
+	case "linux", "freebsd", "openbsd", "netbsd":
+		name, args = "secret-tool", []string{"lookup", "service", service, "account", account}
+	case "darwin":
+		name, args = "security", []string{"find-generic-password", "-s", service, "-a", account, "-w"}
+	default:
+		return "", fmt.Errorf("the keyring is not supported on %s", p.GOOS)
+	}
+	run := p.run
+	if run == nil {
+		run = func(name string, args ...string) ([]byte, error) {
+			return exec.Command(name, args...).Output()
+		}
+	}
+	out, err := run(name, args...)
+	if err != nil {
+		return "", errors.Wrapf(err, "%s %s", name, strings.Join(args, " "))
+	}
+	secret := strings.TrimRight(string(out), "\r\n")
+	if field == "" {
+		return secret, nil
+	}
+	val, ok := ParseDocument([]byte(secret)).Get(field)
+	if !ok {
+		return "", fmt.Errorf("%s not found in secret %s", field, path)
+	}
+	return val, nil
+}
+
+// VaultSecretProvider reads secrets from a HashiCorp Vault KV secrets engine.
+// the first element of the path is the mount of the engine: secret://vault/kv/hcloud#token
+// reads the field token of the secret hcloud in the engine mounted at kv.
+type VaultSecretProvider struct {
+	Address   string
+	Token     string
+	Namespace string
+	// KVVersion of the secrets engine, 1 or 2
+	KVVersion string
+	Client    *http.Client
+}
+
+func (p *VaultSecretProvider) Name() string {
+	return "vault"
+}
+
+func (p *VaultSecretProvider) Fetch(path, field string) (string, error) {
+	if p.Address == "" || p.Token == "" {
+		return "", errors.New("VAULT_ADDR and VAULT_TOKEN have to be set")
+	}
+	parts := strings.SplitN(path, "/", 2)
+	if len(parts) != 2 {
+		return "", fmt.Errorf("invalid vault path %q, expected mount/path", path)
+	}
+	if field == "" {
+		field = "value"
+	}
+	apiPath := parts[0] + "/" + parts[1]
+	if p.KVVersion != "1" {
+		apiPath = parts[0] + "/data/" + parts[1]
+	}
+	u, err := url.Parse(strings.TrimRight(p.Address, "/") + "/v1/" + apiPath)
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", p.Token)
+	if p.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", p.Namespace)
+	}
+	client := p.Client
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Second}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("vault returned %s for %s", resp.Status, path)
+	}
+	var body struct {
+		Data json.RawMessage `json:"data"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&body)
+	if err != nil {
+		return "", errors.Wrap(err, "decoding vault response")
+	}
+	data := body.Data
+	if p.KVVersion != "1" {
+		var v2 struct {
+			Data json.RawMessage `json:"data"`
+		}
+		if err := json.Unmarshal(data, &v2); err != nil {
+			return "", errors.Wrap(err, "decoding vault response")
+		}
+		data = v2.Data
+	}
+	var values map[string]interface{}
+	if err := json.Unmarshal(data, &values); err != nil {
+		return "", errors.Wrap(err, "decoding vault response")
+	}
+	val, ok := values[field]
+	if !ok {
+		return "", fmt.Errorf("%s not found in secret %s", field, path)
+	}
+	if s, ok := val.(string); ok {
+		return s, nil
+	}
+	return fmt.Sprint(val), nil
+}
@@ -0,0 +1,144 @@
+package config
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseSecretRef(t *testing.T) {
+	provider, path, field, err := ParseSecretRef("secret://vault/kv/hcloud#token")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"vault", "kv/hcloud", "token"}, []string{provider, path, field})
+	_, path, field, err = ParseSecretRef("secret://file/do-token")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"do-token", ""}, []string{path, field})
+	_, _, _, err = ParseSecretRef("secret://vault")
+	assert.Error(t, err)
+}
+
+func TestFileSecretProvider(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "if0-secrets")
+	defer os.RemoveAll(dir)
+	_ = ioutil.WriteFile(filepath.Join(dir, "do-token"), []byte("abc\n"), 0600)
+	_ = ioutil.WriteFile(filepath.Join(dir, "hcloud.env"), []byte("token=def\n"), 0600)
+	p := &FileSecretProvider{Dir: dir}
+	val, err := p.Fetch("do-token", "")
+	assert.Nil(t, err)
+	assert.Equal(t, "abc", val)
+	val, err = p.Fetch("hcloud.env", "token")
+	assert.Nil(t, err)
+	assert.Equal(t, "def", val)
+	_, err = p.Fetch("../etc/passwd", "")
+	assert.Error(t, err)
+}
+
+func TestPassSecretProvider(t *testing.T) {
+	p := &PassSecretProvider{Command: "pass", run: func(name string, args ...string) ([]byte, error) {
+		assert.Equal(t, []string{"show", "cloud/aws"}, args)
+		return []byte("secret-key\nkey_id: AKIA123\n"), nil
+	}}
+	val, err := p.Fetch("cloud/aws", "")
+	assert.Nil(t, err)
+	assert.Equal(t, "secret-key", val)
+	val, err = p.Fetch("cloud/aws", "key_id")
+	assert.Nil(t, err)
+	assert.Equal(t, "AKIA123", val)
+}
+
+func TestVaultSecretProvider(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "root" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/kv/data/hcloud":
+			_, _ = w.Write([]byte(`{"data": {"data": {"token": "hcloud-token"}, "metadata": {"version": 1}}}`))
+		case "/v1/secret/do":
+			_, _ = w.Write([]byte(`{"data": {"value": "do-token"}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	p := &VaultSecretProvider{Address: server.URL, Token: "root", KVVersion: "2"}
+	val, err := p.Fetch("kv/hcloud", "token")
+	assert.Nil(t, err)
+	assert.Equal(t, "hcloud-token", val)
+	_, err = p.Fetch("kv/missing", "token")
+	assert.Error(t, err)
+
+	v1 := &VaultSecretProvider{Address: server.URL, Token: "root", KVVersion: "1"}
+	val, err = v1.Fetch("secret/do", "")
+	assert.Nil(t, err)
+	assert.Equal(t, "do-token", val)
+
+	wrongToken := &VaultSecretProvider{Address: server.URL, Token: "wrong"}
+	_, err = wrongToken.Fetch("kv/hcloud", "token")
+	assert.EqualError(t, err, "vault returned 403 Forbidden for kv/hcloud")
+
+	layer := testLayer("dash1.env", map[string]string{"HCLOUD_TOKEN": "secret://vault/kv/hcloud#token"})
+	r := NewResolver(layer)
+	err = r.ResolveSecretRefs(map[string]SecretProvider{"vault": p})
+	assert.Nil(t, err)
+	val, _ = r.Resolve("HCLOUD_TOKEN")
+	assert.Equal(t, "hcloud-token", val)
+
+	layer.Values["DO_TOKEN"] = "secret://unknown/x"
+	assert.Error(t, r.ResolveSecretRefs(map[string]SecretProvider{"vault": p}))
+
+	pass := &PassSecretProvider{Command: "pass", run: func(name string, args ...string) ([]byte, error) {
+		return []byte("pa$$w0rd${HOME}x\n"), nil
+	}}
+	r = NewResolver(testLayer("zero.env", map[string]string{"ZERO_ADMIN_PASSWORD": "secret://pass/zero"}))
+	assert.Nil(t, r.ResolveSecretRefs(map[string]SecretProvider{"pass": pass}))
+	val, _ = r.Resolve("ZERO_ADMIN_PASSWORD")
+	assert.Equal(t, "pa$$w0rd${HOME}x", val)
+}
+
+func TestKeyringSecretProvider(t *testing.T) {
+	p := &KeyringSecretProvider{GOOS: "linux", run: func(name string, args ...string) ([]byte, error) {
+		assert.Equal(t, "secret-tool", name)
+		assert.Equal(t, []string{"lookup", "service", "if0/hcloud", "account", "token"}, args)
+		return []byte("abc\n"), nil
+	}}
+	val, err := p.Fetch("if0/hcloud/token", "")
+	assert.Nil(t, err)
+	assert.Equal(t, "abc", val)
+
+	p = &KeyringSecretProvider{GOOS: "darwin", run: func(name string, args ...string) ([]byte, error) {
+		assert.Equal(t, "security", name)
+		assert.Equal(t, []string{"find-generic-password", "-s", "aws", "-a", "ci", "-w"}, args)
+		return []byte("AWS_ACCESS_KEY_ID=AKIA123\n"), nil
+	}}
+	val, err = p.Fetch("aws/ci", "AWS_ACCESS_KEY_ID")
+	assert.Nil(t, err)
+	assert.Equal(t, "AKIA123", val)
+	_, err = p.Fetch("aws", "")
+	assert.Error(t, err)
+	_, err = (&KeyringSecretProvider{GOOS: "plan9"}).Fetch("aws/ci", "")
+	assert.Error(t, err)
+}
+
+func TestDefaultSecretProvidersIgnoreEnvFiles(t *testing.T) {
+	global := &Layer{Name: "if0.env", Kind: GlobalLayer, Values: map[string]string{"VAULT_ADDR": "https://vault.example.com"}}
+	envFile := testLayer("zero.env", map[string]string{
+		"PASS_COMMAND":    "/tmp/evil",
+		"IF0_SECRETS_DIR": "/",
+		"VAULT_ADDR":      "https://attacker.example.com",
+		"VAULT_TOKEN":     "stolen",
+	})
+	envFile.Kind = EnvFileLayer
+	providers := DefaultSecretProviders(NewResolver(global, envFile))
+	assert.Equal(t, "pass", providers["pass"].(*PassSecretProvider).Command)
+	assert.NotEqual(t, "/", providers["file"].(*FileSecretProvider).Dir)
+	assert.Equal(t, "https://vault.example.com", providers["vault"].(*VaultSecretProvider).Address)
+	assert.Equal(t, "", providers["vault"].(*VaultSecretProvider).Token)
+	assert.Contains(t, providers, "keyring")
+}
@@ -0,0 +1,335 @@
+package config
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/hkdf"
+	"if0/common"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Encrypted values are written as ENC[if0.v2,<base64>]. The value is sealed with a random
+// ChaCha20-Poly1305 key, which is wrapped for every recipient with an X25519 key agreement.
+// The name of the file and the key are the associated data of the value, so a value copied to another
+// key does not decrypt.
+// The private key of the user lives in ~/.if0/keys/identity.key, the public keys of everybody
+// sharing an environment are listed in the environment's .if0-recipients file.
+const (
+	encPrefix      = "ENC[if0.v2,"
+	encSuffix      = "]"
+	identityPrefix = "IF0-SECRET-KEY-"
+	recipientLabel = "if0pub1"
+	wrapInfo       = "if0 secret key wrap"
+
+	// RecipientsFile lists the public keys values of an environment are encrypted for
+	RecipientsFile = ".if0-recipients"
+)
+
+// wrappedKeySize is the size of a wrapped value key: ephemeral public key, sealed key and 16 bytes Poly1305 tag
+const wrappedKeySize = curve25519.PointSize + chacha20poly1305.KeySize + 16
+
+// Identity is an X25519 key pair used to decrypt secrets
+type Identity struct {
+	private []byte
+	public  []byte
+}
+
+// IdentityFile returns the location of the private key of the user
+func IdentityFile() string {
+	return filepath.Join(common.If0Dir, "keys", "identity.key")
+}
+
+// IsEncrypted reports whether value is an ENC[...] value
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, encPrefix) && strings.HasSuffix(value, encSuffix)
+}
+
+// GenerateIdentity creates a new random key pair
+func GenerateIdentity() (*Identity, error) {
+	private := make([]byte, curve25519.ScalarSize)
+	if _, err := io.ReadFull(rand.Reader, private); err != nil {
+		return nil, err
+	}
+	return newIdentity(private)
+}
+
+func newIdentity(private []byte) (*Identity, error) {
+	public, err := curve25519.X25519(private, curve25519.Basepoint)
+	if err != nil {
+		return nil, err
+	}
+	return &Identity{private: private, public: public}, nil
+}
+
+// Recipient returns the public key of the identity, as it is listed in recipients files
+func (id *Identity) Recipient() string {
+	return recipientLabel + base64.RawURLEncoding.EncodeToString(id.public)
+}
+
+func (id *Identity) String() string {
+	return identityPrefix + base64.RawURLEncoding.EncodeToString(id.private)
+}
+
+// ParseIdentity parses a private key written by Identity.String
+func ParseIdentity(s string) (*Identity, error) {
+	s = strings.TrimSpace(s)
+	if !strings.HasPrefix(s, identityPrefix) {
+		return nil, errors.New("invalid identity")
+	}
+	private, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(s, identityPrefix))
+	if err != nil || len(private) != curve25519.ScalarSize {
+		return nil, errors.New("invalid identity")
+	}
+	return newIdentity(private)
+}
+
+// ParseRecipient parses a public key written by Identity.Recipient
+func ParseRecipient(s string) ([]byte, error) {
+	s = strings.TrimSpace(s)
+	if !strings.HasPrefix(s, recipientLabel) {
+		return nil, fmt.Errorf("invalid recipient %q", s)
+	}
+	public, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(s, recipientLabel))
+	if err != nil || len(public) != curve25519.PointSize {
+		return nil, fmt.Errorf("invalid recipient %q", s)
+	}
+	return public, nil
+}
+
+// LoadIdentity reads the identity from ~/.if0/keys/identity.key
+func LoadIdentity() (*Identity, error) {
+	data, err := ioutil.ReadFile(IdentityFile())
+	if err != nil {
+		return nil, err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "#") {
+			return ParseIdentity(line)
+		}
+	}
+	return nil, fmt.Errorf("no identity found in %s", IdentityFile())
+}
+
+// LoadOrCreateIdentity reads the identity of the user, a new one is created if there is none yet
+func LoadOrCreateIdentity() (*Identity, error) {
+	id, err := LoadIdentity()
+	if err == nil || !os.IsNotExist(err) {
+		return id, err
+	}
+	id, err = GenerateIdentity()
+	if err != nil {
+		return nil, err
+	}
+	fmt.Println("Creating identity for encrypted secrets", IdentityFile())
+	return id, SaveIdentity(id)
+}
+
+// SaveIdentity writes the identity to ~/.if0/keys/identity.key, readable by the user only
+func SaveIdentity(id *Identity) error {
+	err := os.MkdirAll(filepath.Dir(IdentityFile()), 0700)
+	if err != nil {
+		return err
+	}
+	content := fmt.Sprintf("# public key: %s\n%s\n", id.Recipient(), id)
+	return writeFileAtomic(IdentityFile(), []byte(content), 0600)
+}
+
+// ReadRecipients returns the public keys listed in the recipients file of an environment
+func ReadRecipients(envDir string) ([]string, error) {
+	f, err := os.Open(filepath.Join(envDir, RecipientsFile))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var recipients []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if _, err := ParseRecipient(line); err != nil {
+			return nil, err
+		}
+		recipients = append(recipients, line)
+	}
+	return recipients, scanner.Err()
+}
+
+// WriteRecipients replaces the recipients file of an environment
+func WriteRecipients(envDir string, recipients []string) error {
+	var b strings.Builder
+	b.WriteString("# public keys the secrets of this environment are encrypted for, see `if0 secrets`\n")
+	for _, r := range recipients {
+		b.WriteString(r + "\n")
+	}
+	return writeFileAtomic(filepath.Join(envDir, RecipientsFile), []byte(b.String()), 0644)
+}
+
+// environmentRecipients returns the recipients of an environment.
+// without a recipients file, secrets are encrypted for the user only and the file is created.
+func environmentRecipients(envDir string, id *Identity) ([]string, error) {
+	recipients, err := ReadRecipients(envDir)
+	if err == nil {
+		return recipients, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+	recipients = []string{id.Recipient()}
+	return recipients, WriteRecipients(envDir, recipients)
+}
+
+// EncryptValue encrypts the value of key in file for all recipients, file is the name of the file, e.g. zero.env
+func EncryptValue(value, file, key string, recipients []string) (string, error) {
+	if len(recipients) == 0 {
+		return "", errors.New("no recipients to encrypt for")
+	}
+	if len(recipients) > 255 {
+		return "", errors.New("too many recipients")
+	}
+	valueKey := make([]byte, chacha20poly1305.KeySize)
+	if _, err := io.ReadFull(rand.Reader, valueKey); err != nil {
+		return "", err
+	}
+	var header bytes.Buffer
+	header.WriteByte(byte(len(recipients)))
+	for _, r := range recipients {
+		public, err := ParseRecipient(r)
+		if err != nil {
+			return "", err
+		}
+		wrapped, err := wrapKey(valueKey, public)
+		if err != nil {
+			return "", err
+		}
+		header.Write(wrapped)
+	}
+	return sealValue(header.Bytes(), valueKey, []byte(value), file, key)
+}
+
+// DecryptValue decrypts the ENC[...] value of key in file. Values that are not encrypted are returned as they are.
+func DecryptValue(value, file, key string, id *Identity) (string, error) {
+	if !IsEncrypted(value) {
+		return value, nil
+	}
+	plain, _, _, err := openValue(value, file, key, id)
+	return string(plain), err
+}
+
+// RebindValue binds the ENC[...] value of key in file to newKey in newFile, e.g. when a migration
+// renames or moves a key. the recipients of the value are kept, id must be one of them.
+func RebindValue(value, file, key, newFile, newKey string, id *Identity) (string, error) {
+	if !IsEncrypted(value) {
+		return value, nil
+	}
+	plain, valueKey, header, err := openValue(value, file, key, id)
+	if err != nil {
+		return "", err
+	}
+	return sealValue(header, valueKey, plain, newFile, newKey)
+}
+
+// associatedData binds a value to the file and the key it is stored in
+func associatedData(file, key string) []byte {
+	return []byte(file + "\x00" + key)
+}
+
+// sealValue encrypts plain with valueKey, header holds the number of recipients and the wrapped value keys
+func sealValue(header, valueKey, plain []byte, file, key string) (string, error) {
+	aead, err := chacha20poly1305.New(valueKey)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	buf.Write(header)
+	buf.Write(nonce)
+	buf.Write(aead.Seal(nil, nonce, plain, associatedData(file, key)))
+	return encPrefix + base64.StdEncoding.EncodeToString(buf.Bytes()) + encSuffix, nil
+}
+
+// openValue decrypts an ENC[...] value, it returns the plaintext, the value key and the header of the value
+func openValue(value, file, key string, id *Identity) (plain, valueKey, header []byte, err error) {
+	data, err := base64.StdEncoding.DecodeString(strings.TrimSuffix(strings.TrimPrefix(value, encPrefix), encSuffix))
+	if err != nil || len(data) < 1 {
+		return nil, nil, nil, errors.New("malformed encrypted value")
+	}
+	n := int(data[0])
+	if len(data) < 1+n*wrappedKeySize+chacha20poly1305.NonceSize {
+		return nil, nil, nil, errors.New("malformed encrypted value")
+	}
+	header, data = data[:1+n*wrappedKeySize], data[1+n*wrappedKeySize:]
+	for i := 0; i < n && valueKey == nil; i++ {
+		valueKey, _ = unwrapKey(header[1+i*wrappedKeySize:1+(i+1)*wrappedKeySize], id)
+	}
+	if valueKey == nil {
+		return nil, nil, nil, errors.New("the value is not encrypted for your key, ask a teammate to add " + id.Recipient())
+	}
+	aead, err := chacha20poly1305.New(valueKey)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	plain, err = aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], associatedData(file, key))
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("encrypted value of %s in %s has been tampered with or belongs to another key", key, file)
+	}
+	return plain, valueKey, header, nil
+}
+
+// wrapKey encrypts the value key for a recipient with an ephemeral key pair
+func wrapKey(valueKey, recipient []byte) ([]byte, error) {
+	ephemeral, err := GenerateIdentity()
+	if err != nil {
+		return nil, err
+	}
+	shared, err := curve25519.X25519(ephemeral.private, recipient)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := wrapAEAD(shared, ephemeral.public, recipient)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	return append(ephemeral.public, aead.Seal(nil, nonce, valueKey, nil)...), nil
+}
+
+func unwrapKey(wrapped []byte, id *Identity) ([]byte, error) {
+	ephemeral := wrapped[:curve25519.PointSize]
+	shared, err := curve25519.X25519(id.private, ephemeral)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := wrapAEAD(shared, ephemeral, id.public)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	return aead.Open(nil, nonce, wrapped[curve25519.PointSize:], nil)
+}
+
+func wrapAEAD(shared, ephemeral, recipient []byte) (cipher.AEAD, error) {
+	salt := append(append([]byte{}, ephemeral...), recipient...)
+	key := make([]byte, chacha20poly1305.KeySize)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, shared, salt, []byte(wrapInfo)), key); err != nil {
+		return nil, err
+	}
+	return chacha20poly1305.New(key)
+}
@@ -0,0 +1,122 @@
+package config
+
+import (
+	"github.com/stretchr/testify/assert"
+	"if0/common"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestEncryptValue(t *testing.T) {
+	alice, _ := GenerateIdentity()
+	bob, _ := GenerateIdentity()
+	eve, _ := GenerateIdentity()
+
+	enc, err := EncryptValue("s3cr$t value", "zero.env", "ZERO_ADMIN_PASSWORD", []string{alice.Recipient(), bob.Recipient()})
+	assert.Nil(t, err)
+	assert.True(t, IsEncrypted(enc))
+	assert.NotContains(t, enc, "s3cr")
+
+	for _, id := range []*Identity{alice, bob} {
+		plain, err := DecryptValue(enc, "zero.env", "ZERO_ADMIN_PASSWORD", id)
+		assert.Nil(t, err)
+		assert.Equal(t, "s3cr$t value", plain)
+	}
+	_, err = DecryptValue(enc, "zero.env", "ZERO_ADMIN_PASSWORD", eve)
+	assert.Error(t, err)
+
+	tampered := enc[:len(enc)-3] + "A=]"
+	_, err = DecryptValue(tampered, "zero.env", "ZERO_ADMIN_PASSWORD", alice)
+	assert.Error(t, err)
+
+	// a value copied to another key or file does not decrypt
+	_, err = DecryptValue(enc, "zero.env", "ZERO_BASE_DOMAIN", alice)
+	assert.EqualError(t, err, "encrypted value of ZERO_BASE_DOMAIN in zero.env has been tampered with or belongs to another key")
+	_, err = DecryptValue(enc, "dash1.env", "ZERO_ADMIN_PASSWORD", alice)
+	assert.Error(t, err)
+
+	// rebinding keeps the recipients
+	rebound, err := RebindValue(enc, "zero.env", "ZERO_ADMIN_PASSWORD", "dash1.env", "ADMIN_PASSWORD", alice)
+	assert.Nil(t, err)
+	plain, err := DecryptValue(rebound, "dash1.env", "ADMIN_PASSWORD", bob)
+	assert.Nil(t, err)
+	assert.Equal(t, "s3cr$t value", plain)
+
+	plain, err = DecryptValue("plain", "zero.env", "ZERO_ADMIN_PASSWORD", alice)
+	assert.Nil(t, err)
+	assert.Equal(t, "plain", plain)
+
+	parsed, err := ParseIdentity(alice.String())
+	assert.Nil(t, err)
+	assert.Equal(t, alice.Recipient(), parsed.Recipient())
+	_, err = ParseRecipient("if0pub1abc")
+	assert.Error(t, err)
+}
+
+func TestEncryptEnvironment(t *testing.T) {
+	_, cleanup := setupSnapshotTest(t)
+	defer cleanup()
+	envDir := filepath.Join(common.EnvDir, "env")
+	_ = os.MkdirAll(envDir, 0755)
+	zeroFile := filepath.Join(envDir, "zero.env")
+	_ = ioutil.WriteFile(zeroFile, []byte("IF0_ENVIRONMENT=env\nZERO_ADMIN_PASSWORD=secret\n"), 0644)
+	_ = ioutil.WriteFile(filepath.Join(envDir, "dash1.env"), []byte("DASH1_MODULE=hcloud\nHCLOUD_TOKEN=token\n"), 0644)
+
+	count, err := EncryptEnvironment(envDir, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, count)
+	snapshots, _ := ListSnapshots()
+	assert.Empty(t, snapshots, "no plaintext copy is kept")
+	assert.FileExists(t, IdentityFile())
+	zero, _ := ioutil.ReadFile(zeroFile)
+	assert.Contains(t, string(zero), "IF0_ENVIRONMENT=env\nZERO_ADMIN_PASSWORD=ENC[if0.v2,")
+	assert.NotContains(t, string(zero), "secret")
+
+	r, err := LoadEnvironmentResolver(envDir, nil)
+	assert.Nil(t, err)
+	assert.Nil(t, r.DecryptSecrets())
+	val, _ := r.Resolve("HCLOUD_TOKEN")
+	assert.Equal(t, "token", val)
+
+	// share with a teammate, then rotate the own key
+	bob, _ := GenerateIdentity()
+	assert.Nil(t, AddRecipient(envDir, bob.Recipient()))
+	rotated, err := RotateIdentity()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{envDir}, rotated)
+	recipients, _ := ReadRecipients(envDir)
+	id, _ := LoadIdentity()
+	assert.Equal(t, []string{id.Recipient(), bob.Recipient()}, recipients)
+	zero, _ = ioutil.ReadFile(zeroFile)
+	doc := ParseDocument(zero)
+	enc, _ := doc.Get("ZERO_ADMIN_PASSWORD")
+	plain, err := DecryptValue(enc, "zero.env", "ZERO_ADMIN_PASSWORD", bob)
+	assert.Nil(t, err)
+	assert.Equal(t, "secret", plain)
+
+	assert.Nil(t, RemoveRecipient(envDir, bob.Recipient()))
+	count, err = DecryptEnvironment(envDir, []string{"ZERO_ADMIN_PASSWORD"})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, count)
+	zero, _ = ioutil.ReadFile(zeroFile)
+	assert.True(t, strings.HasSuffix(string(zero), "ZERO_ADMIN_PASSWORD=secret\n"))
+}
+
+func TestDecryptSecretsAreNotExpanded(t *testing.T) {
+	_, cleanup := setupSnapshotTest(t)
+	defer cleanup()
+	id, _ := GenerateIdentity()
+	assert.Nil(t, SaveIdentity(id))
+	enc, err := EncryptValue("pa$$w0rd${HOME}x", "dash1.env", "HCLOUD_TOKEN", []string{id.Recipient()})
+	assert.Nil(t, err)
+
+	r := NewResolver(testLayer("dash1.env", map[string]string{"HCLOUD_TOKEN": enc, "TF_VAR_token": "${HCLOUD_TOKEN}"}))
+	assert.Nil(t, r.DecryptSecrets())
+	val, _ := r.Resolve("HCLOUD_TOKEN")
+	assert.Equal(t, "pa$$w0rd${HOME}x", val)
+	val, _ = r.Resolve("TF_VAR_token")
+	assert.Equal(t, "pa$$w0rd${HOME}x", val)
+}
@@ -0,0 +1,123 @@
+package config
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"github.com/pkg/errors"
+	"if0/common"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Environment bundles are signed with an ed25519 key of the user in ~/.if0/keys/signing.key.
+// Bundles are only imported if they are signed by the user or by a key listed in ~/.if0/keys/trusted-signers.
+const (
+	signingKeyPrefix = "IF0-SIGNING-KEY-"
+	signerLabel      = "if0sig1"
+)
+
+// SigningKeyFile returns the location of the signing key of the user
+func SigningKeyFile() string {
+	return filepath.Join(common.If0Dir, "keys", "signing.key")
+}
+
+// TrustedSignersFile returns the location of the public keys whose bundles are imported
+func TrustedSignersFile() string {
+	return filepath.Join(common.If0Dir, "keys", "trusted-signers")
+}
+
+// SignerKey returns the public key of a signing key, as it is listed in the trusted signers file
+func SignerKey(key ed25519.PrivateKey) string {
+	return signerLabel + base64.RawURLEncoding.EncodeToString(key.Public().(ed25519.PublicKey))
+}
+
+// ParseSignerKey parses a public key written by SignerKey
+func ParseSignerKey(s string) (ed25519.PublicKey, error) {
+	s = strings.TrimSpace(s)
+	public, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(s, signerLabel))
+	if !strings.HasPrefix(s, signerLabel) || err != nil || len(public) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("invalid signer key %q", s)
+	}
+	return public, nil
+}
+
+// LoadOrCreateSigningKey reads the signing key of the user, a new one is created if there is none yet
+func LoadOrCreateSigningKey() (ed25519.PrivateKey, error) {
+	data, err := ioutil.ReadFile(SigningKeyFile())
+	if err == nil {
+		for _, line := range strings.Split(string(data), "\n") {
+			line = strings.TrimSpace(line)
+			if !strings.HasPrefix(line, signingKeyPrefix) {
+				continue
+			}
+			seed, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(line, signingKeyPrefix))
+			if err != nil || len(seed) != ed25519.SeedSize {
+				return nil, errors.New("invalid signing key")
+			}
+			return ed25519.NewKeyFromSeed(seed), nil
+		}
+		return nil, fmt.Errorf("no signing key found in %s", SigningKeyFile())
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Println("Creating signing key for environment bundles", SigningKeyFile())
+	err = os.MkdirAll(filepath.Dir(SigningKeyFile()), 0700)
+	if err != nil {
+		return nil, err
+	}
+	content := fmt.Sprintf("# public key: %s\n%s%s\n", SignerKey(key), signingKeyPrefix,
+		base64.RawURLEncoding.EncodeToString(key.Seed()))
+	return key, writeFileAtomic(SigningKeyFile(), []byte(content), 0600)
+}
+
+// ReadTrustedSigners returns the public keys of the trusted signers file
+func ReadTrustedSigners() ([]string, error) {
+	data, err := ioutil.ReadFile(TrustedSignersFile())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var signers []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if _, err := ParseSignerKey(line); err != nil {
+			return nil, err
+		}
+		signers = append(signers, line)
+	}
+	return signers, nil
+}
+
+// TrustSigner adds a public key to the trusted signers file
+func TrustSigner(signer string) error {
+	if _, err := ParseSignerKey(signer); err != nil {
+		return err
+	}
+	return withConfigLock(func() error {
+		signers, err := ReadTrustedSigners()
+		if err != nil || containsString(signers, signer) {
+			return err
+		}
+		err = os.MkdirAll(filepath.Dir(TrustedSignersFile()), 0700)
+		if err != nil {
+			return err
+		}
+		content := "# public keys of signers whose environment bundles are imported, see `if0 env import`\n" +
+			strings.Join(append(signers, signer), "\n") + "\n"
+		return writeFileAtomic(TrustedSignersFile(), []byte(content), 0644)
+	})
+}
@@ -0,0 +1,375 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"if0/common"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	snapshotTimeFormat = "02012006_150405"
+	snapshotExt        = ".env"
+	snapshotMetaExt    = ".json"
+
+	// RunningSnapshot refers to the current content of a snapshot's source file in DiffSnapshots
+	RunningSnapshot = "running"
+)
+
+// Snapshot is a backup of a configuration file in the ~/.if0/.snapshots directory.
+// The content is stored in <ID>.env, the metadata in <ID>.json next to it.
+type Snapshot struct {
+	ID      string    `json:"id"`
+	Source  string    `json:"source"`
+	Created time.Time `json:"created"`
+	Size    int64     `json:"size"`
+	Tags    []string  `json:"tags,omitempty"`
+	Pinned  bool      `json:"pinned,omitempty"`
+}
+
+// KeyChange is a key-level difference between two configuration files
+type KeyChange struct {
+	Key      string
+	OldValue string
+	NewValue string
+	Added    bool
+	Removed  bool
+}
+
+// Protected reports whether the snapshot is pinned or tagged and must not be garbage collected
+func (s *Snapshot) Protected() bool {
+	return s.Pinned || len(s.Tags) > 0
+}
+
+// Path returns the location of the snapshot content
+func (s *Snapshot) Path() string {
+	return filepath.Join(common.SnapshotsDir, s.ID+snapshotExt)
+}
+
+func (s *Snapshot) metaPath() string {
+	return filepath.Join(common.SnapshotsDir, s.ID+snapshotMetaExt)
+}
+
+func (s *Snapshot) writeMeta() error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.metaPath(), data, 0644)
+}
+
+// TakeSnapshot copies the configuration file to the ~/.if0/.snapshots directory
+// and records the file it was taken from.
+func TakeSnapshot(fileName string) (*Snapshot, error) {
+	if _, err := os.Stat(common.SnapshotsDir); os.IsNotExist(err) {
+		fmt.Println("Directory does not exist, creating dir for snapshots")
+		err = os.MkdirAll(common.SnapshotsDir, 0755)
+		if err != nil {
+			return nil, err
+		}
+	}
+	source, err := filepath.Abs(fileName)
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadFile(source)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	snapshot := &Snapshot{
+		ID:      newSnapshotID(source, now),
+		Source:  source,
+		Created: now,
+		Size:    int64(len(data)),
+	}
+	err = writeFileAtomic(snapshot.Path(), data, 0644)
+	if err != nil {
+		return nil, err
+	}
+	err = snapshot.writeMeta()
+	if err != nil {
+		_ = os.Remove(snapshot.Path())
+		return nil, err
+	}
+	return snapshot, nil
+}
+
+// newSnapshotID builds an ID out of the source file and the timestamp.
+// files inside an environment are prefixed with the environment path, so that
+// zero.env of two environments do not end up in the same namespace:
+// if0-02042020_170240, gitlab.com_group_env_zero-02042020_170240
+func newSnapshotID(source string, t time.Time) string {
+	name := strings.TrimSuffix(filepath.Base(source), filepath.Ext(source))
+	if envDir, err := filepath.Abs(common.EnvDir); err == nil {
+		if rel, err := filepath.Rel(envDir, source); err == nil && !strings.HasPrefix(rel, "..") {
+			rel = strings.TrimSuffix(rel, filepath.Ext(rel))
+			name = strings.Replace(rel, string(os.PathSeparator), "_", -1)
+		}
+	}
+	id := name + "-" + t.Format(snapshotTimeFormat)
+	unique := id
+	for i := 2; isFilePresent(filepath.Join(common.SnapshotsDir, unique+snapshotExt)); i++ {
+		unique = id + "-" + strconv.Itoa(i)
+	}
+	return unique
+}
+
+// ListSnapshots returns all snapshots, oldest first.
+// snapshots taken before metadata was recorded are listed with an empty source
+func ListSnapshots() ([]*Snapshot, error) {
+	files, err := ioutil.ReadDir(common.SnapshotsDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var snapshots []*Snapshot
+	for _, f := range files {
+		if f.IsDir() || filepath.Ext(f.Name()) != snapshotExt {
+			continue
+		}
+		snapshot, err := loadSnapshot(strings.TrimSuffix(f.Name(), snapshotExt), f)
+		if err != nil {
+			fmt.Printf("Error: Reading snapshot %s - %s\n", f.Name(), err)
+			continue
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+	sort.SliceStable(snapshots, func(i, j int) bool {
+		return snapshots[i].Created.Before(snapshots[j].Created)
+	})
+	return snapshots, nil
+}
+
+func loadSnapshot(id string, info os.FileInfo) (*Snapshot, error) {
+	snapshot := &Snapshot{ID: id}
+	data, err := ioutil.ReadFile(snapshot.metaPath())
+	if err == nil {
+		err = json.Unmarshal(data, snapshot)
+		snapshot.ID = id
+		return snapshot, err
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+	// legacy snapshot without metadata: if0-02042020_170240.env
+	snapshot.Created = info.ModTime()
+	snapshot.Size = info.Size()
+	if i := strings.LastIndex(id, "-"); i > 0 {
+		if t, err := time.ParseInLocation(snapshotTimeFormat, id[i+1:], time.Local); err == nil {
+			snapshot.Created = t
+		}
+		if id[:i] == strings.TrimSuffix(filepath.Base(common.If0Default), snapshotExt) {
+			snapshot.Source = common.If0Default
+		}
+	}
+	return snapshot, nil
+}
+
+// GetSnapshot looks up a snapshot by its ID or by one of its tags
+func GetSnapshot(ref string) (*Snapshot, error) {
+	snapshots, err := ListSnapshots()
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range snapshots {
+		if s.ID == ref {
+			return s, nil
+		}
+	}
+	for _, s := range snapshots {
+		for _, tag := range s.Tags {
+			if tag == ref {
+				return s, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("snapshot %s not found", ref)
+}
+
+// DeleteSnapshot removes the content and the metadata of a snapshot
+func DeleteSnapshot(snapshot *Snapshot) error {
+	err := os.Remove(snapshot.Path())
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	err = os.Remove(snapshot.metaPath())
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// TagSnapshot adds a tag to a snapshot. Tags are unique, an existing tag is moved to the snapshot.
+// Tagged snapshots are never garbage collected.
+func TagSnapshot(ref, tag string) error {
+	if tag == "" || tag == RunningSnapshot {
+		return fmt.Errorf("invalid tag %q", tag)
+	}
+	unlock, err := LockConfig()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	snapshot, err := GetSnapshot(ref)
+	if err != nil {
+		return err
+	}
+	snapshots, err := ListSnapshots()
+	if err != nil {
+		return err
+	}
+	for _, s := range snapshots {
+		if s.ID != snapshot.ID && removeTag(s, tag) {
+			err = s.writeMeta()
+			if err != nil {
+				return err
+			}
+		}
+	}
+	for _, t := range snapshot.Tags {
+		if t == tag {
+			return nil
+		}
+	}
+	snapshot.Tags = append(snapshot.Tags, tag)
+	return snapshot.writeMeta()
+}
+
+// UntagSnapshot removes a tag from a snapshot
+func UntagSnapshot(ref, tag string) error {
+	unlock, err := LockConfig()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	snapshot, err := GetSnapshot(ref)
+	if err != nil {
+		return err
+	}
+	if !removeTag(snapshot, tag) {
+		return fmt.Errorf("snapshot %s is not tagged %s", snapshot.ID, tag)
+	}
+	return snapshot.writeMeta()
+}
+
+func removeTag(snapshot *Snapshot, tag string) bool {
+	var tags []string
+	for _, t := range snapshot.Tags {
+		if t != tag {
+			tags = append(tags, t)
+		}
+	}
+	removed := len(tags) != len(snapshot.Tags)
+	snapshot.Tags = tags
+	return removed
+}
+
+// PinSnapshot pins or unpins a snapshot. Pinned snapshots are never garbage collected.
+func PinSnapshot(ref string, pinned bool) error {
+	unlock, err := LockConfig()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	snapshot, err := GetSnapshot(ref)
+	if err != nil {
+		return err
+	}
+	snapshot.Pinned = pinned
+	return snapshot.writeMeta()
+}
+
+// DiffSnapshots compares two snapshots key by key.
+// if b is empty or "running", a is compared with the current content of its source file.
+func DiffSnapshots(a, b string) ([]KeyChange, error) {
+	snapshotA, err := GetSnapshot(a)
+	if err != nil {
+		return nil, err
+	}
+	docA, err := ReadDocument(snapshotA.Path())
+	if err != nil {
+		return nil, err
+	}
+	var pathB string
+	if b == "" || b == RunningSnapshot {
+		if snapshotA.Source == "" {
+			return nil, fmt.Errorf("the source file of snapshot %s is unknown", snapshotA.ID)
+		}
+		pathB = snapshotA.Source
+	} else {
+		snapshotB, err := GetSnapshot(b)
+		if err != nil {
+			return nil, err
+		}
+		pathB = snapshotB.Path()
+	}
+	docB, err := ReadDocument(pathB)
+	if err != nil {
+		return nil, err
+	}
+	return diffDocuments(docA, docB), nil
+}
+
+// diffDocuments returns the key-level changes from old to new, in the key order of both files
+func diffDocuments(old, new *Document) []KeyChange {
+	var changes []KeyChange
+	oldValues := old.Map()
+	newValues := new.Map()
+	for _, key := range old.Keys() {
+		newVal, ok := newValues[key]
+		if !ok {
+			changes = append(changes, KeyChange{Key: key, OldValue: oldValues[key], Removed: true})
+		} else if newVal != oldValues[key] {
+			changes = append(changes, KeyChange{Key: key, OldValue: oldValues[key], NewValue: newVal})
+		}
+	}
+	for _, key := range new.Keys() {
+		if _, ok := oldValues[key]; !ok {
+			changes = append(changes, KeyChange{Key: key, NewValue: newValues[key], Added: true})
+		}
+	}
+	return changes
+}
+
+// RestoreSnapshot writes the content of a snapshot back to its source file.
+// The current source file is snapshotted first, so a restore can be undone.
+func RestoreSnapshot(ref string) (*Snapshot, error) {
+	unlock, err := LockConfig()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	snapshot, err := GetSnapshot(ref)
+	if err != nil {
+		return nil, err
+	}
+	if snapshot.Source == "" {
+		return nil, fmt.Errorf("the source file of snapshot %s is unknown", snapshot.ID)
+	}
+	data, err := ioutil.ReadFile(snapshot.Path())
+	if err != nil {
+		return nil, err
+	}
+	var backup *Snapshot
+	if isFilePresent(snapshot.Source) {
+		backup, err = TakeSnapshot(snapshot.Source)
+		if err != nil {
+			return nil, errors.Wrap(err, "backup of the current config failed")
+		}
+	}
+	err = writeFileAtomic(snapshot.Source, data, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return backup, nil
+}
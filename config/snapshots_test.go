@@ -0,0 +1,113 @@
+package config
+
+import (
+	"github.com/stretchr/testify/assert"
+	"if0/common"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func setupSnapshotTest(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "if0-snapshots")
+	assert.Nil(t, err)
+	common.If0Dir = dir
+	common.If0Default = filepath.Join(dir, "if0.env")
+	common.EnvDir = filepath.Join(dir, ".environments")
+	common.SnapshotsDir = filepath.Join(dir, ".snapshots")
+	return dir, func() { _ = os.RemoveAll(dir) }
+}
+
+func TestTakeSnapshotRecordsSource(t *testing.T) {
+	_, cleanup := setupSnapshotTest(t)
+	defer cleanup()
+	envFile := filepath.Join(common.EnvDir, "gitlab.com", "group", "env", "zero.env")
+	_ = os.MkdirAll(filepath.Dir(envFile), 0755)
+	_ = ioutil.WriteFile(envFile, []byte("A=1\n"), 0644)
+	_ = ioutil.WriteFile(common.If0Default, []byte("IF0_VERSION=1\n"), 0644)
+
+	envSnapshot, err := TakeSnapshot(envFile)
+	assert.Nil(t, err)
+	if0Snapshot, err := TakeSnapshot(common.If0Default)
+	assert.Nil(t, err)
+	second, err := TakeSnapshot(common.If0Default)
+	assert.Nil(t, err)
+
+	assert.Contains(t, envSnapshot.ID, "gitlab.com_group_env_zero-")
+	assert.Contains(t, if0Snapshot.ID, "if0-")
+	assert.NotEqual(t, if0Snapshot.ID, second.ID)
+
+	snapshots, err := ListSnapshots()
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(snapshots))
+	s, err := GetSnapshot(envSnapshot.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, envSnapshot.Source, s.Source)
+}
+
+func TestDiffAndRestoreSnapshot(t *testing.T) {
+	_, cleanup := setupSnapshotTest(t)
+	defer cleanup()
+	_ = ioutil.WriteFile(common.If0Default, []byte("A=1\nB=2\n"), 0644)
+	snapshot, err := TakeSnapshot(common.If0Default)
+	assert.Nil(t, err)
+	_ = ioutil.WriteFile(common.If0Default, []byte("A=10\nC=3\n"), 0644)
+
+	changes, err := DiffSnapshots(snapshot.ID, RunningSnapshot)
+	assert.Nil(t, err)
+	assert.Equal(t, []KeyChange{
+		{Key: "A", OldValue: "1", NewValue: "10"},
+		{Key: "B", OldValue: "2", Removed: true},
+		{Key: "C", NewValue: "3", Added: true},
+	}, changes)
+
+	backup, err := RestoreSnapshot(snapshot.ID)
+	assert.Nil(t, err)
+	content, _ := ioutil.ReadFile(common.If0Default)
+	assert.Equal(t, "A=1\nB=2\n", string(content))
+	backupContent, _ := ioutil.ReadFile(backup.Path())
+	assert.Equal(t, "A=10\nC=3\n", string(backupContent))
+
+	changes, err = DiffSnapshots(snapshot.ID, backup.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(changes))
+}
+
+func TestTagAndPinSnapshot(t *testing.T) {
+	_, cleanup := setupSnapshotTest(t)
+	defer cleanup()
+	_ = ioutil.WriteFile(common.If0Default, []byte("A=1\n"), 0644)
+	first, _ := TakeSnapshot(common.If0Default)
+	second, _ := TakeSnapshot(common.If0Default)
+	third, _ := TakeSnapshot(common.If0Default)
+
+	assert.Nil(t, TagSnapshot(first.ID, "stable"))
+	assert.Nil(t, TagSnapshot(second.ID, "stable"))
+	s, err := GetSnapshot("stable")
+	assert.Nil(t, err)
+	assert.Equal(t, second.ID, s.ID)
+	assert.Nil(t, PinSnapshot(third.ID, true))
+	assert.Error(t, UntagSnapshot(first.ID, "stable"))
+
+	SetEnvVariable("GC_AUTO", "yes")
+	SetEnvVariable("GC_PERIOD", "0")
+	GarbageCollection()
+	snapshots, _ := ListSnapshots()
+	assert.Equal(t, 2, len(snapshots))
+	for _, s := range snapshots {
+		assert.True(t, s.Protected())
+	}
+}
+
+func TestListLegacySnapshot(t *testing.T) {
+	_, cleanup := setupSnapshotTest(t)
+	defer cleanup()
+	_ = os.MkdirAll(common.SnapshotsDir, 0755)
+	_ = ioutil.WriteFile(filepath.Join(common.SnapshotsDir, "if0-02042020_170240.env"), []byte("A=1\n"), 0644)
+	snapshots, err := ListSnapshots()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(snapshots))
+	assert.Equal(t, common.If0Default, snapshots[0].Source)
+	assert.Equal(t, 2020, snapshots[0].Created.Year())
+}
@@ -0,0 +1,133 @@
+package config
+
+import (
+	"os"
+	"strings"
+	"sync"
+)
+
+// Store is a configuration file with the process environment layered on top of it.
+// Unlike the global viper state it replaces, several stores can be used at the same time.
+// Updates take the configuration lock and replace the file atomically.
+type Store struct {
+	path string
+	mu   sync.RWMutex
+	doc  *Document
+}
+
+// NewStore creates a store for the configuration file at path, the file is read by Load
+func NewStore(path string) *Store {
+	return &Store{path: path, doc: NewDocument()}
+}
+
+// LoadStore creates a store and reads the configuration file. a missing file is treated as empty.
+func LoadStore(path string) (*Store, error) {
+	s := NewStore(path)
+	return s, s.Load()
+}
+
+// Path returns the location of the configuration file
+func (s *Store) Path() string {
+	return s.path
+}
+
+// Load (re-)reads the configuration file
+func (s *Store) Load() error {
+	doc, err := readOrCreateDocument(s.path)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.doc = doc
+	s.mu.Unlock()
+	return nil
+}
+
+// Lookup returns the value of key. a non-empty process environment variable takes precedence
+// over the file, keys are case-insensitive.
+func (s *Store) Lookup(key string) (string, bool) {
+	key = normalizeKey(key)
+	if val, ok := os.LookupEnv(key); ok && val != "" {
+		return val, true
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if val, ok := s.doc.Get(key); ok {
+		return val, true
+	}
+	for _, k := range s.doc.Keys() {
+		if strings.EqualFold(k, key) {
+			return s.doc.Get(k)
+		}
+	}
+	return "", false
+}
+
+// Get returns the value of key, or an empty string
+func (s *Store) Get(key string) string {
+	val, _ := s.Lookup(key)
+	return val
+}
+
+// IsSet reports whether key is defined in the file or the process environment
+func (s *Store) IsSet(key string) bool {
+	_, ok := s.Lookup(key)
+	return ok
+}
+
+// Keys returns the keys of the file in the order they are defined
+func (s *Store) Keys() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.doc.Keys()
+}
+
+// Set writes key to the configuration file, other lines of the file are left untouched
+func (s *Store) Set(key, value string) error {
+	return s.update(func(doc *Document) error {
+		doc.Set(normalizeKey(key), value)
+		return nil
+	})
+}
+
+// Merge writes the keys of src to the configuration file in one update, other lines of the file are left untouched
+func (s *Store) Merge(src *Document) error {
+	return s.update(func(doc *Document) error {
+		doc.Merge(src)
+		return nil
+	})
+}
+
+// Unset removes key from the configuration file
+func (s *Store) Unset(key string) error {
+	return s.update(func(doc *Document) error {
+		key = normalizeKey(key)
+		if !doc.Unset(key) {
+			return errNotSet(key, s.path)
+		}
+		return nil
+	})
+}
+
+// update re-reads the file under the configuration lock, applies fn and writes the file,
+// so that concurrent updates of other processes are not lost
+func (s *Store) update(fn func(doc *Document) error) error {
+	return withConfigLock(func() error {
+		doc, err := readOrCreateDocument(s.path)
+		if err != nil {
+			return err
+		}
+		err = fn(doc)
+		if err != nil {
+			return err
+		}
+		err = doc.WriteFile(s.path, 0644)
+		if err != nil {
+			return err
+		}
+		s.mu.Lock()
+		s.doc = doc
+		s.mu.Unlock()
+		return nil
+	})
+}
@@ -0,0 +1,117 @@
+package config
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"if0/common"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestStoreConcurrentSet(t *testing.T) {
+	_, cleanup := setupSnapshotTest(t)
+	defer cleanup()
+	_ = ioutil.WriteFile(common.If0Default, []byte("# running config\nIF0_VERSION=1\n"), 0644)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			// every goroutine uses its own store, like separate if0 processes
+			assert.Nil(t, NewStore(common.If0Default).Set(fmt.Sprintf("KEY_%d", i), fmt.Sprint(i)))
+		}(i)
+	}
+	wg.Wait()
+
+	store, err := LoadStore(common.If0Default)
+	assert.Nil(t, err)
+	assert.Equal(t, 21, len(store.Keys()))
+	assert.Equal(t, "7", store.Get("key_7"))
+	data, _ := ioutil.ReadFile(common.If0Default)
+	assert.Contains(t, string(data), "# running config\n")
+}
+
+func TestStoreProcessEnvironmentWins(t *testing.T) {
+	_, cleanup := setupSnapshotTest(t)
+	defer cleanup()
+	_ = ioutil.WriteFile(common.If0Default, []byte("IF0_STORE_TEST=file\n"), 0644)
+	store, err := LoadStore(common.If0Default)
+	assert.Nil(t, err)
+	assert.Equal(t, "file", store.Get("IF0_STORE_TEST"))
+
+	_ = os.Setenv("IF0_STORE_TEST", "env")
+	defer os.Unsetenv("IF0_STORE_TEST")
+	assert.Equal(t, "env", store.Get("if0_store_test"))
+	assert.False(t, store.IsSet("IF0_STORE_MISSING"))
+	assert.Error(t, store.Unset("IF0_STORE_MISSING"))
+}
+
+func TestStoresAreIndependent(t *testing.T) {
+	dir, cleanup := setupSnapshotTest(t)
+	defer cleanup()
+	a := NewStore(dir + "/a.env")
+	b := NewStore(dir + "/b.env")
+	assert.Nil(t, a.Set("K", "a"))
+	assert.Nil(t, b.Set("K", "b"))
+	assert.Equal(t, "a", a.Get("K"))
+	assert.Equal(t, "b", b.Get("K"))
+}
+
+func TestStoreMerge(t *testing.T) {
+	dir, cleanup := setupSnapshotTest(t)
+	defer cleanup()
+	file := dir + "/zero.env"
+	_ = ioutil.WriteFile(file, []byte("# zero\nIF0_ENVIRONMENT=env\nZERO_BASE_DOMAIN=old\n"), 0644)
+	src := NewDocument()
+	src.Set("ZERO_BASE_DOMAIN", "example.com")
+	src.Set("ZERO_NODES_MANAGER", "10.0.0.1 # first node")
+	assert.Nil(t, NewStore(file).Merge(src))
+	store, _ := LoadStore(file)
+	assert.Equal(t, "example.com", store.Get("ZERO_BASE_DOMAIN"))
+	assert.Equal(t, "10.0.0.1 # first node", store.Get("ZERO_NODES_MANAGER"))
+	data, _ := ioutil.ReadFile(file)
+	assert.True(t, strings.HasPrefix(string(data), "# zero\nIF0_ENVIRONMENT=env\n"))
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir, cleanup := setupSnapshotTest(t)
+	defer cleanup()
+	file := dir + "/identity.key"
+	assert.Nil(t, writeFileAtomic(file, []byte("one"), 0600))
+	assert.Nil(t, writeFileAtomic(file, []byte("two"), 0644))
+
+	data, _ := ioutil.ReadFile(file)
+	assert.Equal(t, "two", string(data))
+	info, _ := os.Stat(file)
+	// the permissions of an existing file are kept
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	files, _ := ioutil.ReadDir(dir)
+	assert.Equal(t, 1, len(files), "temporary files are removed")
+}
+
+func TestLockConfigSerializesUpdates(t *testing.T) {
+	_, cleanup := setupSnapshotTest(t)
+	defer cleanup()
+	unlock, err := LockConfig()
+	assert.Nil(t, err)
+
+	done := make(chan error)
+	go func() {
+		done <- NewStore(common.If0Default).Set("K", "v")
+	}()
+	select {
+	case <-done:
+		t.Fatal("update did not wait for the lock")
+	case <-time.After(100 * time.Millisecond):
+	}
+	unlock()
+	assert.Nil(t, <-done)
+	val, err := GetConfigValue(common.If0Default, "K")
+	assert.Nil(t, err)
+	assert.Equal(t, "v", val)
+}
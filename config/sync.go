@@ -17,6 +17,7 @@ var (
 	GitRepoSync          = GitSync
 	repoUrl              = getRepoUrl
 	checkForLocalChanges = localChanges
+	mergeRemoteChanges   = mergeRemote
 )
 
 // RepoSync is used to synchronize the if0 configuration files with a remote git repository
@@ -46,13 +47,18 @@ func GitSync(syncObj sync.SyncOps, repo string, dir string) error {
 	// get authorization
 	// if the git sync is via HTTPS, then fetch username-password credentials
 	// if the git sync is via SSH, then parse .ppk file
-	authObj := sync.Auth{}
+	authObj := sync.Auth{EnvDir: dir, Lookup: GetEnvVariable}
 	auth, err := sync.GetSyncAuth(&authObj, repo)
 	if err != nil {
 		fmt.Println("Authentication Error - ", err)
 		return err
 	}
 
+	resolve, err := conflictResolver()
+	if err != nil {
+		return err
+	}
+
 	auto, manual, err := checkForLocalChanges(syncObj, r)
 	if err != nil {
 		return err
@@ -63,9 +69,26 @@ func GitSync(syncObj sync.SyncOps, repo string, dir string) error {
 		return errors.New("add/commit the local changes before sync")
 	}
 
+	// local changes are committed before pulling, so that they are merged with the remote changes
+	if auto {
+		err = commitChanges(syncObj, r)
+		if err != nil {
+			return err
+		}
+	}
+
 	pullOptions := &git.PullOptions{Auth: auth, RemoteName: "origin", Force: false}
 	_, err = syncObj.Pull(repo, r, pullOptions)
-	if err != nil {
+	merged := false
+	if err == git.ErrNonFastForwardUpdate {
+		fmt.Println("Local and remote changes diverged, merging the remote changes")
+		err = mergeRemoteChanges(syncObj, r, resolve)
+		if err != nil {
+			fmt.Println("Error: Merging remote changes - ", err)
+			return err
+		}
+		merged = true
+	} else if err != nil {
 		if err == git.NoErrAlreadyUpToDate || err.Error() == "remote repository is empty" {
 			fmt.Println("Pull status: ", err)
 		} else {
@@ -74,7 +97,7 @@ func GitSync(syncObj sync.SyncOps, repo string, dir string) error {
 		}
 	}
 
-	if auto {
+	if auto || merged {
 		err = syncChanges(syncObj, r, auth)
 		if err != nil {
 			return err
@@ -110,19 +133,25 @@ func GetRepository(syncObj sync.SyncOps, repo string, dir string) (*git.Reposito
 	return r, nil
 }
 
-func syncChanges(syncObj sync.SyncOps, r *git.Repository, auth transport.AuthMethod) error {
-	fmt.Println("Pushing the local changes")
+func commitChanges(syncObj sync.SyncOps, r *git.Repository) error {
 	w, err := syncObj.GetWorktree(r)
 	if err != nil {
 		fmt.Println("Worktree Error: ", err)
+		return err
 	}
 	// git commit
 	err = syncObj.Commit(w)
 	if err != nil {
 		fmt.Println("Error: Committing changes - ", err)
+		return err
 	}
+	return nil
+}
+
+func syncChanges(syncObj sync.SyncOps, r *git.Repository, auth transport.AuthMethod) error {
+	fmt.Println("Pushing the local changes")
 	// git push
-	err = syncObj.Push(auth, r)
+	err := syncObj.Push(auth, r)
 	if err != nil {
 		fmt.Println("Error: Pushing changes - ", err)
 		return err
@@ -155,8 +184,8 @@ func localChanges(syncObj sync.SyncOps, r *git.Repository) (bool, bool, error) {
 	if len(status) > 0 {
 		// prompt the user if they want to add/commit/push changes
 		fmt.Println("Following changes were found. " +
-			"If the repository is not up-to-date, pulling in changes would delete the unstaged changes. \n" +
-			"Other changes would be overwritten by the remote changes.")
+			"They are committed and merged with the remote changes, .env files key by key. \n" +
+			"Keys and files changed on both sides are resolved with --ours or --theirs, or asked for.")
 		fmt.Println(status)
 		fmt.Println("Proceed? [Y/n]")
 		reader := bufio.NewReader(os.Stdin)
@@ -3,11 +3,13 @@ package config
 import (
 	"errors"
 	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/go-git/go-git/v5/plumbing/transport"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"if0/common"
 	"if0/common/sync"
+	"io/ioutil"
 	"os"
 	"testing"
 )
@@ -61,6 +63,11 @@ func (m *mockSync) Commit(w *git.Worktree) error {
 	return args.Error(0)
 }
 
+func (m *mockSync) CommitMerge(w *git.Worktree, r *git.Repository, theirs plumbing.Hash, msg string) error {
+	args := m.Called()
+	return args.Error(0)
+}
+
 func (m *mockSync) Push(auth transport.AuthMethod, r *git.Repository) error {
 	args := m.Called()
 	return args.Error(0)
@@ -83,7 +90,7 @@ func TestGitSyncAuthError(t *testing.T) {
 
 func TestGitSyncInitError(t *testing.T) {
 	testSyncObj := new(mockSync)
-	common.If0Dir = "config"
+	defer useTempIf0Dir(t)()
 	sync.GetSyncAuth = func(authObj sync.AuthOps, remoteStorage string) (transport.AuthMethod, error) {
 		return nil, nil
 	}
@@ -97,8 +104,7 @@ func TestGitSyncInitError(t *testing.T) {
 
 func TestGitSyncRemoteError(t *testing.T) {
 	testSyncObj := new(mockSync)
-	common.If0Dir = "config"
-	_ = os.RemoveAll(common.If0Dir)
+	defer useTempIf0Dir(t)()
 	testSyncObj.On("GitInit").Return(&git.Repository{}, nil)
 	testSyncObj.On("AddRemote").Return(errors.New("test-remote-error"))
 	err := GitSync(testSyncObj, "http://sample-storage", "dir")
@@ -111,7 +117,22 @@ func TestRepoSyncNoRemoteStorage(t *testing.T) {
 	assert.EqualError(t, err, "REMOTE_STORAGE is not set.")
 }
 
+// useTempIf0Dir points the if0 paths to a temporary directory, the returned function restores them
+func useTempIf0Dir(t *testing.T) func() {
+	dir, err := ioutil.TempDir("", "if0-sync")
+	assert.Nil(t, err)
+	if0Dir, envDir, snapshotsDir, archiveDir, if0Default :=
+		common.If0Dir, common.EnvDir, common.SnapshotsDir, common.ArchiveDir, common.If0Default
+	common.SetIf0Dir(dir)
+	return func() {
+		_ = os.RemoveAll(dir)
+		common.If0Dir, common.EnvDir, common.SnapshotsDir, common.ArchiveDir, common.If0Default =
+			if0Dir, envDir, snapshotsDir, archiveDir, if0Default
+	}
+}
+
 func TestRepoSyncError(t *testing.T) {
+	defer useTempIf0Dir(t)()
 	SetEnvVariable("REMOTE_STORAGE", "http://sample-storage")
 	GitRepoSync = func(syncObj sync.SyncOps, repo string, dir string) error {
 		return errors.New("test-repo-sync-error")
@@ -130,7 +151,7 @@ func TestGitSync(t *testing.T) {
 	checkForLocalChanges = func(syncObj sync.SyncOps, r *git.Repository) (bool, bool, error) {
 		return true, false, nil
 	}
-	common.If0Dir = "config"
+	defer useTempIf0Dir(t)()
 	testSyncObj := new(mockSync)
 	testSyncObj.On("GitInit").Return(&git.Repository{}, nil)
 	testSyncObj.On("AddRemote").Return(nil)
@@ -143,4 +164,38 @@ func TestGitSync(t *testing.T) {
 	testSyncObj.On("Push").Return(nil)
 	err := GitSync(testSyncObj, "http://sample-storage", "dir")
 	assert.Nil(t, err)
-}
\ No newline at end of file
+}
+func TestGitSyncMergesDivergedChanges(t *testing.T) {
+	sync.GetSyncAuth = func(authObj sync.AuthOps, remoteStorage string) (transport.AuthMethod, error) {
+		return nil, nil
+	}
+	repoUrl = func(r *git.Repository) string {
+		return "sample-url"
+	}
+	checkForLocalChanges = func(syncObj sync.SyncOps, r *git.Repository) (bool, bool, error) {
+		return false, false, nil
+	}
+	defer func() { mergeRemoteChanges = mergeRemote }()
+	merged := false
+	mergeRemoteChanges = func(syncObj sync.SyncOps, r *git.Repository, resolve ConflictResolver) error {
+		merged = true
+		return nil
+	}
+	defer useTempIf0Dir(t)()
+	testSyncObj := new(mockSync)
+	testSyncObj.On("GitInit").Return(&git.Repository{}, nil)
+	testSyncObj.On("AddRemote").Return(nil)
+	testSyncObj.On("Open").Return(&git.Repository{}, nil)
+	testSyncObj.On("Pull").Return(&git.Worktree{}, git.ErrNonFastForwardUpdate)
+	testSyncObj.On("Push").Return(nil)
+	err := GitSync(testSyncObj, "http://sample-storage", "dir")
+	assert.Nil(t, err)
+	assert.True(t, merged)
+	testSyncObj.AssertCalled(t, "Push")
+
+	mergeRemoteChanges = func(syncObj sync.SyncOps, r *git.Repository, resolve ConflictResolver) error {
+		return errors.New("test-merge-error")
+	}
+	err = GitSync(testSyncObj, "http://sample-storage", "dir")
+	assert.EqualError(t, err, "test-merge-error")
+}
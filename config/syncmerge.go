@@ -0,0 +1,251 @@
+package config
+
+import (
+	"fmt"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh/terminal"
+	"if0/common/sync"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+)
+
+// mergedFile is the result of merging a file, removed if the merge removes it
+type mergedFile struct {
+	content []byte
+	mode    os.FileMode
+	removed bool
+}
+
+// conflictResolver returns the resolver of the conflicts of a sync: the MergeStrategy, else a prompt on a
+// terminal. without terminal it returns nil, conflicts fail the sync.
+func conflictResolver() (ConflictResolver, error) {
+	switch MergeStrategy {
+	case MergeOurs, MergeTheirs:
+		return strategyResolver(MergeStrategy), nil
+	case "":
+	default:
+		return nil, fmt.Errorf("invalid merge strategy %s, expected %s or %s", MergeStrategy, MergeOurs, MergeTheirs)
+	}
+	if terminal.IsTerminal(int(syscall.Stdin)) {
+		return promptResolver(os.Stdin, os.Stdout), nil
+	}
+	return nil, nil
+}
+
+// mergeRemote merges the remote branch into HEAD when both have new commits. files changed on one side
+// take that side, .env files changed on both sides are merged key by key (see MergeEnv), other files changed
+// on both sides are conflicts. conflicts are resolved by resolve, if it is nil they fail the merge.
+// the merge is committed, nothing is written if it fails.
+func mergeRemote(syncObj sync.SyncOps, r *git.Repository, resolve ConflictResolver) error {
+	head, err := r.Head()
+	if err != nil {
+		return err
+	}
+	if !head.Name().IsBranch() {
+		return errors.New("HEAD is detached, check out a branch to merge the remote changes")
+	}
+	remoteName := plumbing.NewRemoteReferenceName("origin", head.Name().Short())
+	remote, err := r.Reference(remoteName, true)
+	if err != nil {
+		return errors.Wrapf(err, "reading %s", remoteName.Short())
+	}
+	ours, err := r.CommitObject(head.Hash())
+	if err != nil {
+		return err
+	}
+	theirs, err := r.CommitObject(remote.Hash())
+	if err != nil {
+		return err
+	}
+	bases, err := ours.MergeBase(theirs)
+	if err != nil {
+		return err
+	}
+	if len(bases) == 0 {
+		return fmt.Errorf("%s and %s have no common history", head.Name().Short(), remoteName.Short())
+	}
+	var unresolved []string
+	if resolve == nil {
+		resolve = func(c SyncConflict) (string, error) {
+			unresolved = append(unresolved, c.String())
+			return MergeOurs, nil
+		}
+	}
+	// without identity, encrypted values are compared as they are
+	id, err := LoadIdentity()
+	if err != nil {
+		id = nil
+	}
+	merged, err := mergeCommits(bases[0], ours, theirs, id, resolve)
+	if err != nil {
+		return err
+	}
+	if len(unresolved) > 0 {
+		return fmt.Errorf("conflicting changes of %s, sync on a terminal or with --ours or --theirs",
+			strings.Join(unresolved, ", "))
+	}
+
+	w, err := syncObj.GetWorktree(r)
+	if err != nil {
+		return err
+	}
+	for _, file := range sortedFiles(merged) {
+		m := merged[file]
+		if m.removed {
+			fmt.Println("Removing", file)
+			if _, err := w.Remove(file); err != nil {
+				return err
+			}
+			continue
+		}
+		fmt.Println("Merging", file)
+		path := filepath.Join(w.Filesystem.Root(), filepath.FromSlash(file))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+		if err := writeFileAtomic(path, m.content, m.mode); err != nil {
+			return err
+		}
+		if err := syncObj.AddFile(w, file); err != nil {
+			return err
+		}
+	}
+	msg := fmt.Sprintf("merge: syncing %s with %s", head.Name().Short(), remoteName.Short())
+	return syncObj.CommitMerge(w, r, theirs.Hash, msg)
+}
+
+// mergeCommits returns the files of ours which have to change to merge theirs
+func mergeCommits(base, ours, theirs *object.Commit, id *Identity, resolve ConflictResolver) (map[string]*mergedFile, error) {
+	baseFiles, err := commitFiles(base)
+	if err != nil {
+		return nil, err
+	}
+	ourFiles, err := commitFiles(ours)
+	if err != nil {
+		return nil, err
+	}
+	theirFiles, err := commitFiles(theirs)
+	if err != nil {
+		return nil, err
+	}
+	paths := make(map[string]*mergedFile)
+	for _, files := range []map[string]*object.File{baseFiles, ourFiles, theirFiles} {
+		for name := range files {
+			paths[name] = nil
+		}
+	}
+	merged := make(map[string]*mergedFile)
+	for _, name := range sortedFiles(paths) {
+		b, o, t := baseFiles[name], ourFiles[name], theirFiles[name]
+		var m *mergedFile
+		switch {
+		case sameFile(o, t), sameFile(t, b):
+		case sameFile(o, b):
+			m, err = fileOf(t)
+		case isEnvFile(name) && o != nil && t != nil:
+			m, err = mergeEnvFiles(name, b, o, t, id, resolve)
+		default:
+			var side string
+			side, err = resolve(SyncConflict{File: name, OursRemoved: o == nil, TheirsRemoved: t == nil})
+			if err == nil && side == MergeTheirs {
+				m, err = fileOf(t)
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		if m != nil {
+			merged[name] = m
+		}
+	}
+	return merged, nil
+}
+
+// mergeEnvFiles merges a .env file changed on both sides, base is nil if both added it
+func mergeEnvFiles(name string, base, ours, theirs *object.File, id *Identity, resolve ConflictResolver) (*mergedFile, error) {
+	baseDoc := NewDocument()
+	if base != nil {
+		content, err := base.Contents()
+		if err != nil {
+			return nil, err
+		}
+		baseDoc = ParseDocument([]byte(content))
+	}
+	ourContent, err := ours.Contents()
+	if err != nil {
+		return nil, err
+	}
+	theirContent, err := theirs.Contents()
+	if err != nil {
+		return nil, err
+	}
+	doc, err := MergeEnv(name, baseDoc, ParseDocument([]byte(ourContent)), ParseDocument([]byte(theirContent)), id, resolve)
+	if err != nil {
+		return nil, err
+	}
+	content := doc.Render()
+	if string(content) == ourContent {
+		return nil, nil
+	}
+	mode, err := ours.Mode.ToOSFileMode()
+	if err != nil {
+		return nil, err
+	}
+	return &mergedFile{content: content, mode: mode.Perm()}, nil
+}
+
+// commitFiles returns the files of the tree of c by path
+func commitFiles(c *object.Commit) (map[string]*object.File, error) {
+	tree, err := c.Tree()
+	if err != nil {
+		return nil, err
+	}
+	files := make(map[string]*object.File)
+	err = tree.Files().ForEach(func(f *object.File) error {
+		files[f.Name] = f
+		return nil
+	})
+	return files, err
+}
+
+// fileOf returns f as merge result, nil removes the file
+func fileOf(f *object.File) (*mergedFile, error) {
+	if f == nil {
+		return &mergedFile{removed: true}, nil
+	}
+	content, err := f.Contents()
+	if err != nil {
+		return nil, err
+	}
+	mode, err := f.Mode.ToOSFileMode()
+	if err != nil {
+		return nil, err
+	}
+	return &mergedFile{content: []byte(content), mode: mode.Perm()}, nil
+}
+
+func sameFile(a, b *object.File) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Hash == b.Hash && a.Mode == b.Mode
+}
+
+func isEnvFile(name string) bool {
+	return strings.HasSuffix(name, ".env")
+}
+
+func sortedFiles(files map[string]*mergedFile) []string {
+	var names []string
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,108 @@
+package config
+
+import (
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/stretchr/testify/assert"
+	"if0/common/sync"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// divergedRepo creates a repository whose master and origin/master changed the files of base differently
+func divergedRepo(t *testing.T, base, ours, theirs map[string]string) (*git.Repository, string) {
+	dir, err := ioutil.TempDir("", "if0-merge")
+	assert.Nil(t, err)
+	r, err := git.PlainInit(dir, false)
+	assert.Nil(t, err)
+	w, err := r.Worktree()
+	assert.Nil(t, err)
+	commit := func(files map[string]string, msg string) plumbing.Hash {
+		for name, content := range files {
+			if content == "" {
+				_, err := w.Remove(name)
+				assert.Nil(t, err)
+				continue
+			}
+			assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+			_, err := w.Add(name)
+			assert.Nil(t, err)
+		}
+		hash, err := w.Commit(msg, &git.CommitOptions{Author: &object.Signature{Name: "if0", When: time.Now()}})
+		assert.Nil(t, err)
+		return hash
+	}
+	baseHash := commit(base, "base")
+	theirHash := commit(theirs, "theirs")
+	remote := plumbing.NewHashReference(plumbing.NewRemoteReferenceName("origin", "master"), theirHash)
+	assert.Nil(t, r.Storer.SetReference(remote))
+	assert.Nil(t, w.Reset(&git.ResetOptions{Commit: baseHash, Mode: git.HardReset}))
+	commit(ours, "ours")
+	return r, dir
+}
+
+func TestMergeRemote(t *testing.T) {
+	base := map[string]string{
+		"zero.env":  "IF0_ENVIRONMENT=env\nZERO_NODES_MANAGER=10.0.0.1\nZERO_BASE_DOMAIN=example.com\n",
+		"dash1.env": "DASH1_MODULE=hcloud\n",
+		"README.md": "env\n",
+	}
+	ours := map[string]string{
+		"zero.env":  "IF0_ENVIRONMENT=env\nZERO_NODES_MANAGER=10.0.0.2\nZERO_BASE_DOMAIN=example.com\n",
+		"README.md": "our env\n",
+	}
+	theirs := map[string]string{
+		"zero.env":  "IF0_ENVIRONMENT=env\nZERO_NODES_MANAGER=10.0.0.1\nZERO_BASE_DOMAIN=example.org\n",
+		"dash1.env": "",
+		"notes.txt": "theirs\n",
+	}
+	r, dir := divergedRepo(t, base, ours, theirs)
+	defer os.RemoveAll(dir)
+	head, _ := r.Head()
+	remote, _ := r.Reference(plumbing.NewRemoteReferenceName("origin", "master"), true)
+
+	assert.Nil(t, mergeRemote(&sync.Sync{}, r, nil))
+	zero, _ := ioutil.ReadFile(filepath.Join(dir, "zero.env"))
+	assert.Equal(t, "IF0_ENVIRONMENT=env\nZERO_NODES_MANAGER=10.0.0.2\nZERO_BASE_DOMAIN=example.org\n", string(zero))
+	assert.NoFileExists(t, filepath.Join(dir, "dash1.env"))
+	notes, _ := ioutil.ReadFile(filepath.Join(dir, "notes.txt"))
+	assert.Equal(t, "theirs\n", string(notes))
+	readme, _ := ioutil.ReadFile(filepath.Join(dir, "README.md"))
+	assert.Equal(t, "our env\n", string(readme))
+
+	// the merge is committed with both parents and leaves a clean worktree
+	merge, _ := r.Head()
+	commit, err := r.CommitObject(merge.Hash())
+	assert.Nil(t, err)
+	assert.Equal(t, []plumbing.Hash{head.Hash(), remote.Hash()}, commit.ParentHashes)
+	w, _ := r.Worktree()
+	status, _ := w.Status()
+	assert.True(t, status.IsClean(), status.String())
+}
+
+func TestMergeRemoteConflicts(t *testing.T) {
+	base := map[string]string{"zero.env": "A=1\nB=1\n", "logo.png": "logo"}
+	ours := map[string]string{"zero.env": "A=2\nB=1\n", "logo.png": "our logo"}
+	theirs := map[string]string{"zero.env": "A=3\nB=3\n", "logo.png": "their logo"}
+	r, dir := divergedRepo(t, base, ours, theirs)
+	defer os.RemoveAll(dir)
+	head, _ := r.Head()
+
+	// without resolver nothing is written
+	err := mergeRemote(&sync.Sync{}, r, nil)
+	assert.EqualError(t, err, "conflicting changes of logo.png, zero.env: A, sync on a terminal or with --ours or --theirs")
+	after, _ := r.Head()
+	assert.Equal(t, head.Hash(), after.Hash())
+	zero, _ := ioutil.ReadFile(filepath.Join(dir, "zero.env"))
+	assert.Equal(t, "A=2\nB=1\n", string(zero))
+
+	assert.Nil(t, mergeRemote(&sync.Sync{}, r, strategyResolver(MergeTheirs)))
+	zero, _ = ioutil.ReadFile(filepath.Join(dir, "zero.env"))
+	assert.Equal(t, "A=3\nB=3\n", string(zero))
+	logo, _ := ioutil.ReadFile(filepath.Join(dir, "logo.png"))
+	assert.Equal(t, "their logo", string(logo))
+}
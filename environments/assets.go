@@ -0,0 +1,191 @@
+package environments
+
+import (
+	"bytes"
+	"fmt"
+	"if0/common"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"text/template"
+	"time"
+)
+
+//go:generate go run gen_assets.go
+
+// Assets of the default template. they are read from the asset cache, which `if0 assets refresh`
+// updates when online, or else from the copies built into if0, so creating an environment needs no network.
+// only RefreshAssets uses the network. the logo is not built in yet, environments get it once it is cached.
+const (
+	LogoAsset = "logo.png"
+	// CIAsset is the template of .gitlab-ci.yml, {{ .ShipmateURL }} is SHIPMATE_WORKFLOW_URL
+	CIAsset = "gitlab-ci.yml"
+)
+
+// Sources of the asset cache
+const (
+	CachedAsset   = "cache"
+	EmbeddedAsset = "built-in"
+	MissingAsset  = "missing"
+)
+
+// assetURLs are downloaded by RefreshAssets, assets without url are only built in or placed in the cache by hand
+var assetURLs = map[string]string{
+	LogoAsset: "https://gitlab.com/peter.saarland/scratch/-/raw/master/logo.png?inline=false",
+}
+
+var assetClient = &http.Client{Timeout: 30 * time.Second}
+
+// AssetInfo describes an asset and where it is read from
+type AssetInfo struct {
+	Name   string
+	Source string
+	URL    string
+}
+
+// AssetsDir returns the asset cache, shared by all profiles
+func AssetsDir() string {
+	return filepath.Join(common.If0Home, "assets")
+}
+
+// ReadAsset returns the content of an asset and its source, CachedAsset or EmbeddedAsset
+func ReadAsset(name string) ([]byte, string, error) {
+	data, err := ioutil.ReadFile(filepath.Join(AssetsDir(), name))
+	if err == nil {
+		return data, CachedAsset, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, "", err
+	}
+	data, ok := embeddedAssets[name]
+	if !ok {
+		if _, ok := assetURLs[name]; ok {
+			return nil, "", fmt.Errorf("asset %s is not built in, download it with if0 assets refresh", name)
+		}
+		return nil, "", fmt.Errorf("unknown asset %s", name)
+	}
+	return data, EmbeddedAsset, nil
+}
+
+// Assets lists the assets built into if0 or downloaded and their current source
+func Assets() []AssetInfo {
+	names := make(map[string]bool)
+	for name := range embeddedAssets {
+		names[name] = true
+	}
+	for name := range assetURLs {
+		names[name] = true
+	}
+	var infos []AssetInfo
+	for name := range names {
+		source := MissingAsset
+		if _, ok := embeddedAssets[name]; ok {
+			source = EmbeddedAsset
+		}
+		if _, err := os.Stat(filepath.Join(AssetsDir(), name)); err == nil {
+			source = CachedAsset
+		}
+		infos = append(infos, AssetInfo{Name: name, Source: source, URL: assetURLs[name]})
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Name < infos[j].Name
+	})
+	return infos
+}
+
+// RefreshAssets downloads the assets with an url to the asset cache. an asset is only replaced once
+// it has been downloaded completely. returns the refreshed assets and the first error.
+func RefreshAssets() ([]string, error) {
+	err := os.MkdirAll(AssetsDir(), 0755)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for name := range assetURLs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var refreshed []string
+	var firstErr error
+	for _, name := range names {
+		err := downloadAsset(name, assetURLs[name])
+		if err != nil {
+			fmt.Printf("Error: Refreshing %s - %s\n", name, err)
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		refreshed = append(refreshed, name)
+	}
+	return refreshed, firstErr
+}
+
+func downloadAsset(name, url string) error {
+	resp, err := assetClient.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("bad status: %s", resp.Status)
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if len(data) == 0 {
+		return fmt.Errorf("empty response from %s", url)
+	}
+	tmp, err := ioutil.TempFile(AssetsDir(), "."+name+".tmp")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), filepath.Join(AssetsDir(), name))
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+	}
+	return err
+}
+
+// writeAsset writes an asset to target, unless target exists
+func writeAsset(name, target string) error {
+	if _, err := os.Stat(target); err == nil {
+		return nil
+	}
+	data, _, err := ReadAsset(name)
+	if err != nil {
+		return err
+	}
+	fmt.Println("Creating file", target)
+	return ioutil.WriteFile(target, data, 0644)
+}
+
+// renderAsset writes an asset rendered with text/template to target, unless target exists
+func renderAsset(name, target string, data interface{}) error {
+	if _, err := os.Stat(target); err == nil {
+		return nil
+	}
+	text, _, err := ReadAsset(name)
+	if err != nil {
+		return err
+	}
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(string(text))
+	if err != nil {
+		return err
+	}
+	var out bytes.Buffer
+	if err := tmpl.Execute(&out, data); err != nil {
+		return err
+	}
+	fmt.Println("Creating file", target)
+	return ioutil.WriteFile(target, out.Bytes(), 0644)
+}
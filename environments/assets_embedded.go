@@ -0,0 +1,8 @@
+// Code generated by gen_assets.go; DO NOT EDIT.
+
+package environments
+
+// embeddedAssets are the assets built into if0, see assets.go
+var embeddedAssets = map[string][]byte{
+	"gitlab-ci.yml": []byte("include:\n  - remote: '{{ .ShipmateURL }}'"),
+}
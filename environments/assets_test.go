@@ -0,0 +1,136 @@
+package environments
+
+import (
+	"github.com/stretchr/testify/assert"
+	"if0/common"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestMain keeps the tests offline, assets are only downloaded from test servers
+func TestMain(m *testing.M) {
+	assetClient = &http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		if r.URL.Hostname() != "127.0.0.1" {
+			return nil, os.ErrPermission
+		}
+		return http.DefaultTransport.RoundTrip(r)
+	})}
+	os.Exit(m.Run())
+}
+
+// useTempAssetsDir points the asset cache to a temporary directory, the returned function restores it
+func useTempAssetsDir(t *testing.T) func() {
+	dir, err := ioutil.TempDir("", "if0-assets")
+	assert.Nil(t, err)
+	home := common.If0Home
+	common.If0Home = dir
+	return func() {
+		_ = os.RemoveAll(dir)
+		common.If0Home = home
+	}
+}
+
+func TestReadAsset(t *testing.T) {
+	defer useTempAssetsDir(t)()
+	data, source, err := ReadAsset(CIAsset)
+	assert.Nil(t, err)
+	assert.Equal(t, EmbeddedAsset, source)
+	assert.Equal(t, embeddedAssets[CIAsset], data)
+	// the logo is not built in
+	_, _, err = ReadAsset(LogoAsset)
+	assert.EqualError(t, err, "asset logo.png is not built in, download it with if0 assets refresh")
+	assert.Equal(t, []AssetInfo{
+		{Name: CIAsset, Source: EmbeddedAsset},
+		{Name: LogoAsset, Source: MissingAsset, URL: assetURLs[LogoAsset]},
+	}, Assets())
+
+	assert.Nil(t, os.MkdirAll(AssetsDir(), 0755))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(AssetsDir(), LogoAsset), []byte("cached"), 0644))
+	data, source, err = ReadAsset(LogoAsset)
+	assert.Nil(t, err)
+	assert.Equal(t, CachedAsset, source)
+	assert.Equal(t, "cached", string(data))
+	assert.Equal(t, []AssetInfo{
+		{Name: CIAsset, Source: EmbeddedAsset},
+		{Name: LogoAsset, Source: CachedAsset, URL: assetURLs[LogoAsset]},
+	}, Assets())
+
+	_, _, err = ReadAsset("missing.png")
+	assert.NotNil(t, err)
+}
+
+func TestRefreshAssets(t *testing.T) {
+	defer useTempAssetsDir(t)()
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		if status == http.StatusOK {
+			_, _ = w.Write([]byte("new logo"))
+		}
+	}))
+	defer server.Close()
+	urls := assetURLs
+	assetURLs = map[string]string{LogoAsset: server.URL}
+	defer func() { assetURLs = urls }()
+
+	// a failed download leaves no file behind
+	status = http.StatusNotFound
+	refreshed, err := RefreshAssets()
+	assert.NotNil(t, err)
+	assert.Empty(t, refreshed)
+	files, _ := ioutil.ReadDir(AssetsDir())
+	assert.Empty(t, files)
+
+	status = http.StatusOK
+	refreshed, err = RefreshAssets()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{LogoAsset}, refreshed)
+	data, source, _ := ReadAsset(LogoAsset)
+	assert.Equal(t, CachedAsset, source)
+	assert.Equal(t, "new logo", string(data))
+}
+
+func TestEnvInitOffline(t *testing.T) {
+	defer useTempIf0Dir(t)()
+	defer useTempAssetsDir(t)()
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		_, _ = w.Write([]byte("logo"))
+	}))
+	defer server.Close()
+	urls := assetURLs
+	assetURLs = map[string]string{LogoAsset: server.URL}
+	defer func() { assetURLs = urls }()
+
+	// the logo is neither built in nor cached, the environment is created without it and without network
+	envPath := filepath.Join(common.EnvDir, "offline")
+	_ = os.MkdirAll(envPath, 0755)
+	err := envInit(envPath, NewAnswersPrompter(map[string]string{"provider": "none", "nodes": "10.0.0.1"}, nil), nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, requests)
+	assert.NoFileExists(t, filepath.Join(envPath, "logo.png"))
+	ci, _ := ioutil.ReadFile(filepath.Join(envPath, ".gitlab-ci.yml"))
+	assert.Equal(t, "include:\n  - remote: '"+getShipmateUrl()+"'", string(ci))
+
+	// once refreshed, the cached logo is used
+	_, err = RefreshAssets()
+	assert.Nil(t, err)
+	envPath = filepath.Join(common.EnvDir, "refreshed")
+	_ = os.MkdirAll(envPath, 0755)
+	err = envInit(envPath, NewAnswersPrompter(map[string]string{"provider": "none", "nodes": "10.0.0.1"}, nil), nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, requests)
+	logo, _ := ioutil.ReadFile(filepath.Join(envPath, "logo.png"))
+	assert.Equal(t, "logo", string(logo))
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
@@ -0,0 +1,468 @@
+package environments
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/scrypt"
+	"if0/config"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// A bundle is a compressed tar archive of an environment, for machines without access to its git repository.
+// It holds manifest.json, listing every file with its checksum, the ed25519 signature of the manifest
+// in manifest.sig and the files of the environment below files/.
+const (
+	bundleVersion   = 1
+	bundleManifest  = "manifest.json"
+	bundleSignature = "manifest.sig"
+	bundleFilesDir  = "files/"
+)
+
+// Handling of the .ssh keys of an environment in a bundle
+const (
+	SSHInclude = "include"
+	SSHExclude = "exclude"
+	// SSHEncrypt encrypts the keys with a passphrase
+	SSHEncrypt = "encrypt"
+)
+
+// zstdCommand compresses *.zst bundles, gzip is used for all other bundles
+var zstdCommand = "zstd"
+
+// BundleManifest describes the content of a bundle
+type BundleManifest struct {
+	Version     int          `json:"version"`
+	Environment string       `json:"environment"`
+	RepoURL     string       `json:"repo_url,omitempty"`
+	Created     time.Time    `json:"created"`
+	Signer      string       `json:"signer"`
+	SSH         string       `json:"ssh"`
+	Salt        string       `json:"salt,omitempty"`
+	Files       []BundleFile `json:"files"`
+}
+
+// BundleFile is a file of a bundle. the checksum is taken of the content as it is stored in the bundle.
+type BundleFile struct {
+	Path      string `json:"path"`
+	Mode      uint32 `json:"mode"`
+	Size      int64  `json:"size"`
+	SHA256    string `json:"sha256"`
+	Encrypted bool   `json:"encrypted,omitempty"`
+}
+
+// ExportOptions control the content of a bundle
+type ExportOptions struct {
+	// SSH is one of SSHInclude (default), SSHExclude and SSHEncrypt
+	SSH string
+	// Passphrase encrypts the .ssh keys with SSHEncrypt
+	Passphrase []byte
+}
+
+// ImportOptions control how a bundle is imported
+type ImportOptions struct {
+	// Name of the imported environment, by default the location derived from the repository url of the bundle
+	Name string
+	// Signer is a public key to trust in addition to the trusted signers, it is added to them
+	Signer string
+	// Passphrase returns the passphrase of encrypted .ssh keys, it is only called if the bundle has encrypted keys
+	Passphrase func() ([]byte, error)
+}
+
+// ExportEnv writes the environment name to a signed bundle. the bundle is compressed with zstd
+// if output ends with .zst, with gzip otherwise. git history and .terraform are not exported.
+func ExportEnv(name, output string, opts ExportOptions) (*BundleManifest, error) {
+	name, err := resolveLocalEnv(name)
+	if err != nil {
+		return nil, err
+	}
+	if opts.SSH == "" {
+		opts.SSH = SSHInclude
+	}
+	if opts.SSH != SSHInclude && opts.SSH != SSHExclude && opts.SSH != SSHEncrypt {
+		return nil, fmt.Errorf("invalid ssh option %q, expected include, exclude or encrypt", opts.SSH)
+	}
+	key, err := config.LoadOrCreateSigningKey()
+	if err != nil {
+		return nil, errors.Wrap(err, "reading signing key")
+	}
+	envDir := EnvDirOf(name)
+	manifest := &BundleManifest{
+		Version:     bundleVersion,
+		Environment: name,
+		RepoURL:     getRepoUrl(envDir),
+		Created:     time.Now().UTC(),
+		Signer:      config.SignerKey(key),
+		SSH:         opts.SSH,
+	}
+	var sshKey []byte
+	if opts.SSH == SSHEncrypt {
+		salt := make([]byte, 16)
+		if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+			return nil, err
+		}
+		manifest.Salt = base64.StdEncoding.EncodeToString(salt)
+		sshKey, err = bundleKey(opts.Passphrase, salt)
+		if err != nil {
+			return nil, err
+		}
+	}
+	contents := make(map[string][]byte)
+	err = filepath.Walk(envDir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(envDir, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if info.IsDir() {
+			if info.Name() == ".git" || info.Name() == ".terraform" || (rel == ".ssh" && opts.SSH == SSHExclude) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		data, err := ioutil.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		file := BundleFile{Path: rel, Mode: uint32(info.Mode().Perm())}
+		if sshKey != nil && strings.HasPrefix(rel, ".ssh/") {
+			data, err = sealBundleFile(sshKey, rel, data)
+			if err != nil {
+				return err
+			}
+			file.Encrypted = true
+		}
+		sum := sha256.Sum256(data)
+		file.Size = int64(len(data))
+		file.SHA256 = hex.EncodeToString(sum[:])
+		manifest.Files = append(manifest.Files, file)
+		contents[rel] = data
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	manifestData, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	signature := base64.StdEncoding.EncodeToString(ed25519.Sign(key, manifestData))
+
+	var archive bytes.Buffer
+	tw := tar.NewWriter(&archive)
+	err = writeTarFile(tw, bundleManifest, 0644, manifestData)
+	if err == nil {
+		err = writeTarFile(tw, bundleSignature, 0644, []byte(signature+"\n"))
+	}
+	for _, f := range manifest.Files {
+		if err != nil {
+			break
+		}
+		err = writeTarFile(tw, bundleFilesDir+f.Path, os.FileMode(f.Mode), contents[f.Path])
+	}
+	if err == nil {
+		err = tw.Close()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return manifest, writeCompressed(output, archive.Bytes())
+}
+
+// ImportEnv verifies a bundle written by ExportEnv and creates the environment from it. returns the environment directory.
+func ImportEnv(bundle string, opts ImportOptions) (string, error) {
+	manifest, files, err := ReadBundle(bundle, opts.Signer)
+	if err != nil {
+		return "", err
+	}
+	name := opts.Name
+	if name == "" {
+		name = manifest.Environment
+		if manifest.RepoURL != "" {
+			envDir, err := createNestedDirPath(path.Base(manifest.Environment), manifest.RepoURL)
+			if err != nil {
+				return "", err
+			}
+			name = envNameOf(envDir)
+		}
+	}
+	var sshKey []byte
+	for _, f := range manifest.Files {
+		if !f.Encrypted || sshKey != nil {
+			continue
+		}
+		if opts.Passphrase == nil {
+			return "", errors.New("the bundle has encrypted SSH keys, a passphrase is required")
+		}
+		salt, err := base64.StdEncoding.DecodeString(manifest.Salt)
+		if err != nil {
+			return "", errors.New("invalid salt in the bundle manifest")
+		}
+		passphrase, err := opts.Passphrase()
+		if err != nil {
+			return "", err
+		}
+		sshKey, err = bundleKey(passphrase, salt)
+		if err != nil {
+			return "", err
+		}
+	}
+	envDir, err := newEnvDir(name)
+	if err != nil {
+		return "", err
+	}
+	for _, f := range manifest.Files {
+		data := files[f.Path]
+		if f.Encrypted {
+			data, err = openBundleFile(sshKey, f.Path, data)
+		}
+		if err == nil {
+			err = extractFile(bytes.NewReader(data), filepath.Join(envDir, filepath.FromSlash(f.Path)), os.FileMode(f.Mode).Perm())
+		}
+		if err != nil {
+			_ = os.RemoveAll(envDir)
+			return "", err
+		}
+	}
+	if _, err := os.Stat(filepath.Join(envDir, ".ssh")); err == nil {
+		_ = os.Chmod(filepath.Join(envDir, ".ssh"), 0700)
+	}
+	if _, err := os.Stat(filepath.Join(envDir, "zero.env")); err == nil && name != manifest.Environment {
+		err = config.SetConfigValue(filepath.Join(envDir, "zero.env"), "IF0_ENVIRONMENT", envNameOf(envDir))
+		if err != nil {
+			return envDir, err
+		}
+	}
+	return envDir, nil
+}
+
+// ReadBundle reads a bundle, verifies its signature and the checksums of its files and returns
+// the manifest and the content of the files. the bundle has to be signed by the user, a trusted signer or signer.
+func ReadBundle(bundle, signer string) (*BundleManifest, map[string][]byte, error) {
+	data, err := readCompressed(bundle)
+	if err != nil {
+		return nil, nil, err
+	}
+	tr := tar.NewReader(bytes.NewReader(data))
+	entries := make(map[string][]byte)
+	var order []string
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "reading bundle")
+		}
+		if header.Typeflag != tar.TypeReg {
+			return nil, nil, fmt.Errorf("unexpected entry %s in bundle", header.Name)
+		}
+		content, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return nil, nil, err
+		}
+		entries[header.Name] = content
+		order = append(order, header.Name)
+	}
+	manifestData, ok := entries[bundleManifest]
+	if !ok {
+		return nil, nil, errors.New("the bundle has no manifest")
+	}
+	var manifest BundleManifest
+	if err := json.Unmarshal(manifestData, &manifest); err != nil {
+		return nil, nil, errors.Wrap(err, "reading bundle manifest")
+	}
+	if manifest.Version != bundleVersion {
+		return nil, nil, fmt.Errorf("unsupported bundle version %d", manifest.Version)
+	}
+	if err := verifyBundleSignature(&manifest, manifestData, entries[bundleSignature], signer); err != nil {
+		return nil, nil, err
+	}
+	files := make(map[string][]byte)
+	for _, f := range manifest.Files {
+		if f.Path != path.Clean(f.Path) || path.IsAbs(f.Path) || strings.HasPrefix(f.Path, "../") {
+			return nil, nil, fmt.Errorf("invalid path %s in bundle", f.Path)
+		}
+		content, ok := entries[bundleFilesDir+f.Path]
+		if !ok {
+			return nil, nil, fmt.Errorf("%s is missing in the bundle", f.Path)
+		}
+		sum := sha256.Sum256(content)
+		if int64(len(content)) != f.Size || hex.EncodeToString(sum[:]) != f.SHA256 {
+			return nil, nil, fmt.Errorf("checksum mismatch of %s in the bundle", f.Path)
+		}
+		files[f.Path] = content
+	}
+	sort.Strings(order)
+	for _, name := range order {
+		if name == bundleManifest || name == bundleSignature {
+			continue
+		}
+		if _, ok := files[strings.TrimPrefix(name, bundleFilesDir)]; !ok {
+			return nil, nil, fmt.Errorf("%s in the bundle is not listed in the manifest", name)
+		}
+	}
+	return &manifest, files, nil
+}
+
+// verifyBundleSignature checks the signature of the manifest and that the signer is trusted
+func verifyBundleSignature(manifest *BundleManifest, manifestData, signature []byte, signer string) error {
+	public, err := config.ParseSignerKey(manifest.Signer)
+	if err != nil {
+		return err
+	}
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(signature)))
+	if err != nil || !ed25519.Verify(public, manifestData, sig) {
+		return errors.New("invalid bundle signature, the bundle has been modified")
+	}
+	if signer != "" && signer == manifest.Signer {
+		return config.TrustSigner(signer)
+	}
+	key, err := config.LoadOrCreateSigningKey()
+	if err != nil {
+		return err
+	}
+	trusted, err := config.ReadTrustedSigners()
+	if err != nil {
+		return err
+	}
+	if manifest.Signer == config.SignerKey(key) || containsSigner(trusted, manifest.Signer) {
+		return nil
+	}
+	return fmt.Errorf("the bundle is signed by the unknown key %s, "+
+		"verify the key with the sender and import the bundle with --signer %s", manifest.Signer, manifest.Signer)
+}
+
+func containsSigner(signers []string, signer string) bool {
+	for _, s := range signers {
+		if s == signer {
+			return true
+		}
+	}
+	return false
+}
+
+// bundleKey derives the key encrypting the .ssh keys of a bundle from the passphrase
+func bundleKey(passphrase, salt []byte) ([]byte, error) {
+	if len(passphrase) == 0 {
+		return nil, errors.New("an empty passphrase cannot be used to encrypt SSH keys")
+	}
+	return scrypt.Key(passphrase, salt, 1<<15, 8, 1, chacha20poly1305.KeySize)
+}
+
+// sealBundleFile encrypts a file, the path is authenticated so files cannot be swapped
+func sealBundleFile(key []byte, name string, data []byte) ([]byte, error) {
+	aead, err := chacha20poly1305.New(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, data, []byte(name)), nil
+}
+
+func openBundleFile(key []byte, name string, data []byte) ([]byte, error) {
+	aead, err := chacha20poly1305.New(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < aead.NonceSize() {
+		return nil, fmt.Errorf("invalid encrypted file %s", name)
+	}
+	plain, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], []byte(name))
+	if err != nil {
+		return nil, fmt.Errorf("decrypting %s failed, wrong passphrase?", name)
+	}
+	return plain, nil
+}
+
+func writeTarFile(tw *tar.Writer, name string, mode os.FileMode, data []byte) error {
+	err := tw.WriteHeader(&tar.Header{
+		Name:     name,
+		Mode:     int64(mode.Perm()),
+		Size:     int64(len(data)),
+		ModTime:  time.Now(),
+		Typeflag: tar.TypeReg,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = tw.Write(data)
+	return err
+}
+
+// writeCompressed writes data to output, compressed with zstd for *.zst files and gzip otherwise
+func writeCompressed(output string, data []byte) error {
+	f, err := os.OpenFile(output, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if strings.HasSuffix(output, ".zst") {
+		cmd := exec.Command(zstdCommand, "-q", "-c")
+		cmd.Stdin = bytes.NewReader(data)
+		cmd.Stdout = f
+		if err := cmd.Run(); err != nil {
+			return errors.Wrapf(err, "compressing with %s, use a .tar.gz bundle if zstd is not installed", zstdCommand)
+		}
+		return f.Sync()
+	}
+	gz := gzip.NewWriter(f)
+	if _, err := gz.Write(data); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+// readCompressed returns the decompressed content of a bundle, the compression is detected from its first bytes
+func readCompressed(bundle string) ([]byte, error) {
+	data, err := ioutil.ReadFile(bundle)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case bytes.HasPrefix(data, []byte{0x1f, 0x8b}):
+		gz, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		return ioutil.ReadAll(gz)
+	case bytes.HasPrefix(data, []byte{0x28, 0xb5, 0x2f, 0xfd}):
+		cmd := exec.Command(zstdCommand, "-d", "-q", "-c")
+		cmd.Stdin = bytes.NewReader(data)
+		out, err := cmd.Output()
+		if err != nil {
+			return nil, errors.Wrapf(err, "decompressing with %s", zstdCommand)
+		}
+		return out, nil
+	}
+	return nil, fmt.Errorf("%s is not an if0 environment bundle", bundle)
+}
@@ -0,0 +1,142 @@
+package environments
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"github.com/stretchr/testify/assert"
+	"if0/config"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func setupBundleTest(t *testing.T) (string, func()) {
+	restore := setupContextTest(t)
+	envDir := EnvDirOf("gitlab.com/acme/shop")
+	_ = ioutil.WriteFile(filepath.Join(envDir, "zero.env"), []byte("IF0_ENVIRONMENT=gitlab.com/acme/shop\n"), 0644)
+	_ = ioutil.WriteFile(filepath.Join(envDir, "terraform.tfstate"), []byte(`{"version": 4}`), 0644)
+	_ = os.MkdirAll(filepath.Join(envDir, ".ssh"), 0700)
+	_ = ioutil.WriteFile(filepath.Join(envDir, ".ssh", "id_rsa"), []byte("private key"), 0600)
+	_ = os.MkdirAll(filepath.Join(envDir, ".terraform"), 0755)
+	_ = ioutil.WriteFile(filepath.Join(envDir, ".terraform", "plugin"), []byte("binary"), 0755)
+	dir, _ := ioutil.TempDir("", "bundle")
+	return filepath.Join(dir, "shop.tar.gz"), func() {
+		_ = os.RemoveAll(dir)
+		restore()
+	}
+}
+
+func TestExportImportEnv(t *testing.T) {
+	bundle, cleanup := setupBundleTest(t)
+	defer cleanup()
+	manifest, err := ExportEnv("acme/shop", bundle, ExportOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, "gitlab.com/acme/shop", manifest.Environment)
+	var paths []string
+	for _, f := range manifest.Files {
+		paths = append(paths, f.Path)
+	}
+	assert.ElementsMatch(t, []string{".ssh/id_rsa", "terraform.tfstate", "zero.env"}, paths)
+
+	envDir, err := ImportEnv(bundle, ImportOptions{Name: "gitlab.com/acme/imported"})
+	assert.Nil(t, err)
+	assert.Equal(t, EnvDirOf("gitlab.com/acme/imported"), envDir)
+	data, _ := ioutil.ReadFile(filepath.Join(envDir, "terraform.tfstate"))
+	assert.Equal(t, `{"version": 4}`, string(data))
+	info, _ := os.Stat(filepath.Join(envDir, ".ssh", "id_rsa"))
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	name, _ := config.GetConfigValue(filepath.Join(envDir, "zero.env"), "IF0_ENVIRONMENT")
+	assert.Equal(t, "gitlab.com/acme/imported", name)
+
+	_, err = ImportEnv(bundle, ImportOptions{Name: "gitlab.com/acme/imported"})
+	assert.EqualError(t, err, "environment gitlab.com/acme/imported already exists")
+}
+
+func TestExportEnvZstd(t *testing.T) {
+	if _, err := exec.LookPath(zstdCommand); err != nil {
+		t.Skip("zstd is not installed")
+	}
+	bundle, cleanup := setupBundleTest(t)
+	defer cleanup()
+	bundle = bundle[:len(bundle)-len(".tar.gz")] + ".tar.zst"
+	_, err := ExportEnv("acme/shop", bundle, ExportOptions{SSH: SSHExclude})
+	assert.Nil(t, err)
+	manifest, files, err := ReadBundle(bundle, "")
+	assert.Nil(t, err)
+	assert.Equal(t, SSHExclude, manifest.SSH)
+	assert.NotContains(t, files, ".ssh/id_rsa")
+}
+
+func TestImportEnvEncryptedSSH(t *testing.T) {
+	bundle, cleanup := setupBundleTest(t)
+	defer cleanup()
+	passphrase := func() ([]byte, error) { return []byte("correct horse"), nil }
+	_, err := ExportEnv("acme/shop", bundle, ExportOptions{SSH: SSHEncrypt, Passphrase: []byte("correct horse")})
+	assert.Nil(t, err)
+	_, files, err := ReadBundle(bundle, "")
+	assert.Nil(t, err)
+	assert.NotContains(t, string(files[".ssh/id_rsa"]), "private key")
+
+	_, err = ImportEnv(bundle, ImportOptions{Name: "wrong", Passphrase: func() ([]byte, error) { return []byte("wrong"), nil }})
+	assert.Error(t, err)
+	_, err = os.Stat(EnvDirOf("wrong"))
+	assert.True(t, os.IsNotExist(err), "a failed import is removed")
+
+	envDir, err := ImportEnv(bundle, ImportOptions{Name: "decrypted", Passphrase: passphrase})
+	assert.Nil(t, err)
+	data, _ := ioutil.ReadFile(filepath.Join(envDir, ".ssh", "id_rsa"))
+	assert.Equal(t, "private key", string(data))
+}
+
+func TestImportEnvTampered(t *testing.T) {
+	bundle, cleanup := setupBundleTest(t)
+	defer cleanup()
+	_, err := ExportEnv("acme/shop", bundle, ExportOptions{})
+	assert.Nil(t, err)
+
+	// replace the terraform state in the bundle
+	f, _ := os.Open(bundle)
+	gz, _ := gzip.NewReader(f)
+	tr := tar.NewReader(gz)
+	var out bytes.Buffer
+	tw := tar.NewWriter(&out)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		data, _ := ioutil.ReadAll(tr)
+		if header.Name == bundleFilesDir+"terraform.tfstate" {
+			data = []byte(`{"version": 5}`)
+			header.Size = int64(len(data))
+		}
+		_ = tw.WriteHeader(header)
+		_, _ = tw.Write(data)
+	}
+	_ = tw.Close()
+	_ = f.Close()
+	assert.Nil(t, writeCompressed(bundle, out.Bytes()))
+
+	_, err = ImportEnv(bundle, ImportOptions{Name: "tampered"})
+	assert.EqualError(t, err, "checksum mismatch of terraform.tfstate in the bundle")
+}
+
+func TestImportEnvUntrustedSigner(t *testing.T) {
+	bundle, cleanup := setupBundleTest(t)
+	defer cleanup()
+	manifest, err := ExportEnv("acme/shop", bundle, ExportOptions{})
+	assert.Nil(t, err)
+	// a new signing key makes the bundle one of another user
+	assert.Nil(t, os.Remove(config.SigningKeyFile()))
+
+	_, err = ImportEnv(bundle, ImportOptions{Name: "untrusted"})
+	assert.Error(t, err)
+	_, err = ImportEnv(bundle, ImportOptions{Name: "trusted", Signer: manifest.Signer})
+	assert.Nil(t, err)
+	signers, _ := config.ReadTrustedSigners()
+	assert.Equal(t, []string{manifest.Signer}, signers)
+}
@@ -0,0 +1,118 @@
+package environments
+
+import (
+	"fmt"
+	"if0/common"
+	"if0/config"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// CurrentEnvKey holds the environment commands use when no environment is given, like kubectl's current-context.
+// it is kept in the if0.env of the profile, so every profile has its own current environment.
+const CurrentEnvKey = "IF0_CURRENT_ENV"
+
+// EnvNames returns the names of all environments, their paths relative to the environments directory
+// in slash notation, e.g. gitlab.com/group/env
+func EnvNames() ([]string, error) {
+	var names []string
+	err := filepath.Walk(common.EnvDir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && p == common.EnvDir {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if !info.IsDir() {
+			return nil
+		}
+		if info.Name() == ".git" || info.Name() == ".ssh" {
+			return filepath.SkipDir
+		}
+		if p != common.EnvDir && checkForZeroEnv(p) {
+			rel, err := filepath.Rel(common.EnvDir, p)
+			if err != nil {
+				return err
+			}
+			names = append(names, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	sort.Strings(names)
+	return names, err
+}
+
+// ResolveEnvName finds the environment addressed by name. besides the full name (gitlab.com/group/env),
+// trailing path elements (group/env, env) and, if nothing else matches, any part of the name are accepted.
+// an error lists the candidates if name matches several environments.
+func ResolveEnvName(name string) (string, error) {
+	name = strings.Trim(filepath.ToSlash(name), "/")
+	if name == "" {
+		return "", fmt.Errorf("no environment given")
+	}
+	// host and group directories like gitlab.com are no environments
+	if checkForZeroEnv(EnvDirOf(name)) {
+		return name, nil
+	}
+	names, err := EnvNames()
+	if err != nil {
+		return "", err
+	}
+	match, err := matchEnvName(name, names)
+	if err != nil {
+		return "", err
+	}
+	if match == "" {
+		return "", fmt.Errorf("environment %q not found in %s", name, common.EnvDir)
+	}
+	return match, nil
+}
+
+// matchEnvName returns the one of names matching name by trailing path elements or, if none does,
+// by any part of the name. an empty string is returned if there is no match.
+func matchEnvName(name string, names []string) (string, error) {
+	var suffixMatches, partialMatches []string
+	for _, n := range names {
+		if n == name {
+			return n, nil
+		}
+		if strings.HasSuffix(n, "/"+name) {
+			suffixMatches = append(suffixMatches, n)
+		} else if strings.Contains(n, name) {
+			partialMatches = append(partialMatches, n)
+		}
+	}
+	for _, matches := range [][]string{suffixMatches, partialMatches} {
+		switch len(matches) {
+		case 0:
+			continue
+		case 1:
+			return matches[0], nil
+		default:
+			return "", fmt.Errorf("environment %q is ambiguous, it matches: %s", name, strings.Join(matches, ", "))
+		}
+	}
+	return "", nil
+}
+
+// EnvDirOf returns the directory of the environment
+func EnvDirOf(name string) string {
+	return filepath.Join(common.EnvDir, filepath.FromSlash(name))
+}
+
+// CurrentEnv returns the current environment of the profile, an empty string if none is set
+func CurrentEnv() string {
+	store, _ := config.LoadStore(common.If0Default)
+	return store.Get(CurrentEnvKey)
+}
+
+// UseEnv makes the environment addressed by name the current environment and returns its full name
+func UseEnv(name string) (string, error) {
+	resolved, err := ResolveEnvName(name)
+	if err != nil {
+		return "", err
+	}
+	return resolved, config.SetConfigValue(common.If0Default, CurrentEnvKey, resolved)
+}
@@ -0,0 +1,65 @@
+package environments
+
+import (
+	"github.com/stretchr/testify/assert"
+	"if0/common"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func setupContextTest(t *testing.T) func() {
+	restore := useTempIf0Dir(t)
+	for _, name := range []string{"gitlab.com/acme/shop", "gitlab.com/acme/blog", "gitlab.com/beta/shop", "local-env"} {
+		envPath := filepath.Join(common.EnvDir, filepath.FromSlash(name))
+		_ = os.MkdirAll(envPath, 0755)
+		_ = ioutil.WriteFile(filepath.Join(envPath, "zero.env"), []byte("IF0_ENVIRONMENT=x\n"), 0644)
+	}
+	return restore
+}
+
+func TestEnvNames(t *testing.T) {
+	defer setupContextTest(t)()
+	names, err := EnvNames()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"gitlab.com/acme/blog", "gitlab.com/acme/shop", "gitlab.com/beta/shop", "local-env"}, names)
+}
+
+func TestResolveEnvName(t *testing.T) {
+	defer setupContextTest(t)()
+	for name, expected := range map[string]string{
+		"gitlab.com/acme/shop": "gitlab.com/acme/shop",
+		"acme/shop":            "gitlab.com/acme/shop",
+		"blog":                 "gitlab.com/acme/blog",
+		"beta":                 "gitlab.com/beta/shop",
+		"local":                "local-env",
+	} {
+		resolved, err := ResolveEnvName(name)
+		assert.Nil(t, err, name)
+		assert.Equal(t, expected, resolved, name)
+	}
+	_, err := ResolveEnvName("shop")
+	assert.EqualError(t, err, `environment "shop" is ambiguous, it matches: gitlab.com/acme/shop, gitlab.com/beta/shop`)
+	_, err = ResolveEnvName("missing")
+	assert.Error(t, err)
+	// directories above environments are not environments
+	for _, name := range []string{"gitlab.com", "gitlab.com/acme"} {
+		_, err = ResolveEnvName(name)
+		assert.Error(t, err, name)
+	}
+}
+
+func TestUseEnv(t *testing.T) {
+	defer setupContextTest(t)()
+	assert.Equal(t, "", CurrentEnv())
+	name, err := UseEnv("acme/blog")
+	assert.Nil(t, err)
+	assert.Equal(t, "gitlab.com/acme/blog", name)
+	assert.Equal(t, "gitlab.com/acme/blog", CurrentEnv())
+	assert.Equal(t, filepath.Join(common.EnvDir, "gitlab.com", "acme", "blog"), EnvDirOf(CurrentEnv()))
+
+	_, err = UseEnv("shop")
+	assert.Error(t, err)
+	assert.Equal(t, "gitlab.com/acme/blog", CurrentEnv())
+}
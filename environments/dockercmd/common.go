@@ -9,6 +9,7 @@ import (
 	"github.com/docker/docker/api/types/mount"
 	"github.com/docker/docker/client"
 	"if0/common"
+	"if0/config"
 	"io"
 	"os"
 	"path/filepath"
@@ -20,6 +21,7 @@ const (
 	zeroImage           = "registry.gitlab.com/peter.saarland/zero"
 	mountTargetPath     = "/root/.if0/.environments/zero"
 	gitConfigTargetPath = "/root/.gitconfig"
+	environmentKey      = "IF0_ENVIRONMENT"
 )
 
 func addMounts(envName string) []mount.Mount {
@@ -36,6 +38,47 @@ func addMounts(envName string) []mount.Mount {
 	return mounts
 }
 
+// containerEnv returns the layered configuration of the environment as container environment:
+// if0.env < *.env files < process environment < command line overrides.
+// ENC[...] secrets are decrypted and secret:// references are fetched from their store in memory only.
+// the configuration is then validated against the schema, so invalid input fails before a container is started.
+// the container reads the *.env files of the mounted environment itself, only IF0_ENVIRONMENT and the values
+// that differ from them are passed: secrets, expanded ${VAR} references, and values of the process environment
+// or the command line.
+func containerEnv(envName string, overrides []string) ([]string, error) {
+	resolver, err := config.LoadEnvironmentResolver(filepath.Join(common.EnvDir, envName), overrides)
+	if err != nil {
+		return nil, err
+	}
+	err = resolver.DecryptSecrets()
+	if err != nil {
+		return nil, err
+	}
+	err = resolver.ResolveSecretRefs(config.DefaultSecretProviders(resolver))
+	if err != nil {
+		return nil, err
+	}
+	issues := resolver.Validate(config.EnvironmentScope)
+	for _, issue := range issues {
+		fmt.Println(issue)
+	}
+	if config.HasErrors(issues) {
+		return nil, fmt.Errorf("invalid configuration of environment %s, run `if0 config validate --env %s`",
+			envName, envName)
+	}
+	vars, err := resolver.Environment()
+	if err != nil {
+		return nil, err
+	}
+	env := []string{environmentKey + "=" + envName}
+	for _, kv := range vars {
+		if !strings.HasPrefix(kv, environmentKey+"=") {
+			env = append(env, kv)
+		}
+	}
+	return env, nil
+}
+
 func getMountSrcPath(envName string) (string, error) {
 	envDir := filepath.Join(common.EnvDir, envName)
 	if _, err := os.Stat(envDir); os.IsNotExist(err) {
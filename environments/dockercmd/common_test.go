@@ -0,0 +1,51 @@
+package dockercmd
+
+import (
+	"github.com/stretchr/testify/assert"
+	"if0/common"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestContainerEnv(t *testing.T) {
+	dir, err := ioutil.TempDir("", "if0-docker")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	if0Dir, envDir, snapshotsDir, archiveDir, if0Default :=
+		common.If0Dir, common.EnvDir, common.SnapshotsDir, common.ArchiveDir, common.If0Default
+	defer func() {
+		common.If0Dir, common.EnvDir, common.SnapshotsDir, common.ArchiveDir, common.If0Default =
+			if0Dir, envDir, snapshotsDir, archiveDir, if0Default
+	}()
+	common.SetIf0Dir(dir)
+
+	_ = ioutil.WriteFile(common.If0Default, []byte("IF0_VERSION=1\nSHIPMATE_WORKFLOW_URL=https://example.com/ci.yml\n"+
+		"IF0_BASE_DOMAIN=example.com\n"), 0644)
+	_ = os.MkdirAll(filepath.Join(dir, "secrets"), 0700)
+	_ = ioutil.WriteFile(filepath.Join(dir, "secrets", "hcloud"), []byte("pa$$w0rd"), 0600)
+	env := filepath.Join(common.EnvDir, "env")
+	_ = os.MkdirAll(env, 0755)
+	_ = ioutil.WriteFile(filepath.Join(env, "zero.env"),
+		[]byte("IF0_ENVIRONMENT=env\nZERO_ADMIN_USER=admin\nZERO_ADMIN_PASSWORD=secret\n"+
+			"ZERO_ADMIN_PASSWORD_HASH=$$2a$$10$$N9qo8uLOickgx2ZMRZoMyeIjZAgcfl7p92ldGxad68LJZdL17lhWy\n"), 0644)
+	_ = ioutil.WriteFile(filepath.Join(env, "dash1.env"),
+		[]byte("ZERO_NODES_MANAGER=10.0.0.1\nHCLOUD_TOKEN=secret://file/hcloud\n"+
+			"ZERO_BASE_DOMAIN=${IF0_BASE_DOMAIN}\n"), 0644)
+
+	_ = os.Setenv("ZERO_NODES_MANAGER", "10.0.0.2")
+	defer os.Unsetenv("ZERO_NODES_MANAGER")
+
+	vars, err := containerEnv("env", []string{"DASH1_NODE_COUNT=3"})
+	assert.Nil(t, err)
+	// the raw values of the *.env files are read by the container from the mounted environment
+	assert.Equal(t, []string{
+		"IF0_ENVIRONMENT=env",
+		"ZERO_ADMIN_PASSWORD_HASH=$2a$10$N9qo8uLOickgx2ZMRZoMyeIjZAgcfl7p92ldGxad68LJZdL17lhWy",
+		"ZERO_NODES_MANAGER=10.0.0.2",
+		"HCLOUD_TOKEN=pa$$w0rd",
+		"ZERO_BASE_DOMAIN=example.com",
+		"DASH1_NODE_COUNT=3",
+	}, vars)
+}
@@ -10,22 +10,22 @@ import (
 // This function is used to start a dash1 container, and run `make plan` inside the container.
 // In dash1, make plan initializes the necessary Terraform provider modules for
 // the Environment 'envName' and then creates a plan in ~/.if0/.environments/$NAME/dash1.plan`
-func MakePlan(envName string) error {
+func MakePlan(envName string, overrides []string) error {
 	command := []string{"make", "plan"}
-	return dash1make(envName, command)
+	return dash1make(envName, command, overrides)
 }
 
-func MakeInfrastructure(envName string) error {
+func MakeInfrastructure(envName string, overrides []string) error {
 	command := []string{"make", "infrastructure"}
-	return dash1make(envName, command)
+	return dash1make(envName, command, overrides)
 }
 
-func MakeDestroy(envName string) error {
+func MakeDestroy(envName string, overrides []string) error {
 	command := []string{"make", "destroy"}
-	return dash1make(envName, command)
+	return dash1make(envName, command, overrides)
 }
 
-func dash1make(envName string, command []string) error {
+func dash1make(envName string, command []string, overrides []string) error {
 	//binding mounts
 	mounts := addMounts(envName)
 	if mounts == nil {
@@ -34,17 +34,20 @@ func dash1make(envName string, command []string) error {
 		return errors.New(errString)
 	}
 	hostConfig := &container.HostConfig{Mounts: mounts}
+	env, err := containerEnv(envName, overrides)
+	if err != nil {
+		return err
+	}
 
 	containerConfig := &container.Config{
 		Image: dash1Image,
 		Cmd:   command,
 		Tty:   true,
-		Env:   []string{"IF0_ENVIRONMENT=" + envName},
+		Env:   env,
 	}
 	envSplit := strings.Split(envName, "/")
-	env := envSplit[len(envSplit)-1]
-	containerName := "dash1-" + env
-	err := dockerRun(containerConfig, hostConfig, containerName, dash1Image)
+	containerName := "dash1-" + envSplit[len(envSplit)-1]
+	err = dockerRun(containerConfig, hostConfig, containerName, dash1Image)
 	if err != nil {
 		return err
 	}
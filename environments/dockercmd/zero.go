@@ -8,7 +8,7 @@ import (
 )
 
 // This function used to provision the platform
-func MakePlatform(envName string) error {
+func MakePlatform(envName string, overrides []string) error {
 	//binding mounts
 	mounts := addMounts(envName)
 	if mounts == nil {
@@ -17,17 +17,21 @@ func MakePlatform(envName string) error {
 		return errors.New(errString)
 	}
 	hostConfig := &container.HostConfig{Mounts: mounts}
+	env, err := containerEnv(envName, overrides)
+	if err != nil {
+		fmt.Println("Error: MakePlatform - ", err)
+		return err
+	}
 
 	containerConfig := &container.Config{
 		Image: zeroImage,
 		Cmd:   []string{"make", "platform"},
 		Tty:   true,
-		Env:   []string{"IF0_ENVIRONMENT=" + envName},
+		Env:   env,
 	}
 	envSplit := strings.Split(envName, "/")
-	env := envSplit[len(envSplit)-1]
-	containerName := "zero-" + env
-	err := dockerRun(containerConfig, hostConfig, containerName, zeroImage)
+	containerName := "zero-" + envSplit[len(envSplit)-1]
+	err = dockerRun(containerConfig, hostConfig, containerName, zeroImage)
 	if err != nil {
 		fmt.Println("Error: MakePlatform - ", err)
 		return err
@@ -1,152 +1,205 @@
 package environments
 
 import (
-	"bufio"
 	"fmt"
 	"github.com/go-git/go-git/v5"
 	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/pkg/errors"
 	"if0/common"
 	"if0/config"
-	"io"
-	"io/ioutil"
-	"net/http"
 	"os"
 	"path/filepath"
-	"runtime"
+	"strconv"
 	"strings"
 )
 
 // This function checks if the environment directory contains necessary files, if not, creates them.
-func envInit(envPath string) error {
-	err := downloadLogo(envPath)
+// the files of tmpl are created first, nil stands for the default template.
+// the questions about the template inputs and the infrastructure of the environment are answered by p.
+func envInit(envPath string, p Prompter, tmpl *Template) error {
+	if tmpl == nil {
+		tmpl, _ = LoadTemplate(DefaultTemplate)
+	}
+	err := tmpl.confirmHooks(p)
+	if err != nil {
+		return err
+	}
+	inputs, err := tmpl.askInputs(p)
 	if err != nil {
-		fmt.Println("Error: Downloading logo -", err)
+		return err
+	}
+	data := &TemplateData{Name: envNameOf(envPath), Base: filepath.Base(envPath), Inputs: inputs}
+	err = tmpl.render(envPath, data)
+	if err != nil {
+		return errors.Wrapf(err, "template %s", tmpl.Name)
+	}
+	err = initZeroFile(envPath)
+	if err != nil {
+		return err
+	}
+	err = createDash1Env(envPath, p)
+	if err != nil {
+		return err
+	}
+	err = secureEnv(envPath)
+	if err != nil {
+		return err
+	}
+	return tmpl.runHooks(envPath, data)
+}
+
+// initZeroFile creates zero.env. if a template created it, the generated keys it lacks are added to it.
+func initZeroFile(envPath string) error {
+	zeroFile := filepath.Join(envPath, "zero.env")
+	if _, err := os.Stat(zeroFile); os.IsNotExist(err) {
+		return createZeroFile(envPath)
+	}
+	templateFile := zeroFile + templateExt
+	err := os.Rename(zeroFile, templateFile)
+	if err != nil {
+		return err
+	}
+	err = createZeroFile(envPath)
+	if err != nil {
+		_ = os.Rename(templateFile, zeroFile)
+		return err
+	}
+	err = mergeMissingKeys(zeroFile, templateFile)
+	if err != nil {
+		return err
+	}
+	return os.Rename(templateFile, zeroFile)
+}
+
+// secureEnv encrypts the secrets of an environment and creates its SSH key pair if there is none
+func secureEnv(envPath string) error {
+	// secrets are encrypted before the environment is pushed
+	_, err := config.EncryptEnvironment(envPath, nil)
+	if err != nil {
+		fmt.Println("Error: Encrypting secrets -", err)
+		return err
 	}
-	createZeroFile(envPath)
-	createCIFile(envPath)
-	createDash1Env(envPath)
 	sshDir := filepath.Join(envPath, ".ssh")
-	files, direrr := ioutil.ReadDir(sshDir)
-	// .ssh dir not present or present but no keys
-	if _, err := os.Stat(sshDir); os.IsNotExist(err) || (direrr == nil && len(files) < 2) {
-		fmt.Printf("Creating dir %s\n", sshDir)
-		_ = os.Mkdir(sshDir, 0700)
-		err := generateSSHKeyPair(sshDir)
+	// .ssh dir not present or present but without a key pair
+	if !hasSSHKeyPair(sshDir) {
+		if _, err := os.Stat(sshDir); os.IsNotExist(err) {
+			fmt.Printf("Creating dir %s\n", sshDir)
+		}
+		_ = os.MkdirAll(sshDir, 0700)
+		opts, err := sshKeyOptions(envPath)
+		if err != nil {
+			fmt.Println("Error: Generating SSH Key pair - ", err)
+			return err
+		}
+		key, err := generateSSHKeyPair(sshDir, opts)
 		if err != nil {
 			fmt.Println("Error: Generating SSH Key pair - ", err)
 			return err
 		}
+		return recordSSHKey(envPath, key)
 	}
 	return nil
 }
 
-func createZeroFile(envPath string) {
+// generatedZeroKeys are the keys createZeroFile generates for every environment
+var generatedZeroKeys = []string{"IF0_ENVIRONMENT", config.EnvVersionKey, "ZERO_ADMIN_USER", "ZERO_ADMIN_PASSWORD",
+	"ZERO_ADMIN_PASSWORD_HASH"}
+
+func createZeroFile(envPath string) error {
+	pwd, hash, err := generateAdminCredentials()
+	if err != nil {
+		fmt.Println("Error: Could not create admin password -", err)
+		return err
+	}
 	f := createFile(filepath.Join(envPath, "zero.env"))
-	defer f.Close()
-	if f != nil {
-		repoName := strings.Replace(envPath, common.EnvDir+string(os.PathSeparator), "", 1)
-		_, _ = f.WriteString("IF0_ENVIRONMENT=" + repoName + "\n")
-		pwd := generateRandSeq()
-		hash, err := generateHashCmd(pwd)
-		if runtime.GOOS == "windows" || hash == "" || err != nil {
-			hash, err = generateHashDocker(pwd)
-			if err != nil {
-				fmt.Println("Error: Could not create htpasswd hash -", err)
-				return
-			}
-		}
-		_, _ = f.WriteString("ZERO_ADMIN_USER=admin\n")
-		_, _ = f.WriteString("ZERO_ADMIN_PASSWORD=" + pwd + "\n")
-		_, _ = f.WriteString("ZERO_ADMIN_PASSWORD_HASH=" + hash + "\n")
+	if f == nil {
+		return nil
 	}
+	defer f.Close()
+	repoName := strings.Replace(envPath, common.EnvDir+string(os.PathSeparator), "", 1)
+	_, _ = f.WriteString("IF0_ENVIRONMENT=" + repoName + "\n")
+	_, _ = f.WriteString(config.EnvVersionKey + "=" + strconv.Itoa(config.CurrentVersion()) + "\n")
+	_, _ = f.WriteString("ZERO_ADMIN_USER=admin\n")
+	_, _ = f.WriteString("ZERO_ADMIN_PASSWORD=" + pwd + "\n")
+	_, _ = f.WriteString("ZERO_ADMIN_PASSWORD_HASH=" + hash + "\n")
+	return nil
 }
 
-func createCIFile(envPath string) {
-	f := createFile(filepath.Join(envPath, ".gitlab-ci.yml"))
-	defer f.Close()
-	if f != nil {
-		shipmateUrl := getShipmateUrl()
-		dataToWrite := fmt.Sprintf("include:\n  - remote: '%s'", shipmateUrl)
-		_, _ = f.Write([]byte(dataToWrite))
-	}
+// createCIFile creates a .gitlab-ci.yml including the shipmate workflow from the CIAsset
+func createCIFile(envPath string) error {
+	return renderAsset(CIAsset, filepath.Join(envPath, ".gitlab-ci.yml"), struct{ ShipmateURL string }{getShipmateUrl()})
 }
 
-func createDash1Env(envPath string) {
-	dash1File := createFile(filepath.Join(envPath, "dash1.env"))
-	defer dash1File.Close()
-
-	// if dash1.env is already present, do not prompt the user for dash1.env content
-	var skipDash bool
-	if dash1File == nil {
-		skipDash = true
-	}
-
-	var dash1Content []string
-	var zeroContent []string
-
-	fmt.Print("Use Cloud Provider? [Y/n]: ")
-	reader := bufio.NewReader(os.Stdin)
-	useProvider, _ := reader.ReadString('\n')
-	useProvider = strings.TrimSpace(strings.ToLower(useProvider))
-	if (useProvider == "y" || useProvider == "") && !skipDash {
-		dash1Content = useCloudProvider(dash1Content)
-	} else {
-		fmt.Print("Enter IPs:")
-		ips, _ := reader.ReadString('\n')
-		zeroContent = append(zeroContent, "ZERO_NODES_MANAGER="+ips+"\n")
-	}
-
-	fmt.Print("Custom Domain? [y/N]: ")
-	customDomain, _ := reader.ReadString('\n')
-	switch strings.TrimSpace(strings.ToLower(customDomain)) {
-	case "y":
-		fmt.Print("Enter Domain: ")
-		domain, _ := reader.ReadString('\n')
-		zeroContent = append(zeroContent, "ZERO_BASE_DOMAIN="+domain+"\n")
-	case "n", "":
-		fmt.Println("Exiting.")
-	}
-
-	if !skipDash && len(dash1Content) > 0 {
-		for _, line := range dash1Content {
-			_, _ = dash1File.WriteString(line)
-		}
+// createDash1Env asks for the infrastructure of the environment, a cloud provider or the IPs of existing nodes, and its domain.
+// the provider is only asked for if there is no dash1.env yet and zero.env has no nodes.
+func createDash1Env(envPath string, p Prompter) error {
+	dash1Path := filepath.Join(envPath, "dash1.env")
+	_, err := os.Stat(dash1Path)
+	askProvider := os.IsNotExist(err)
+	if zero, err := config.ReadDocument(filepath.Join(envPath, "zero.env")); err == nil && zero.Has("ZERO_NODES_MANAGER") {
+		askProvider = false
 	}
 
-	if len(zeroContent) > 0 {
-		zeroFile, _ := os.OpenFile(filepath.Join(envPath, "zero.env"), os.O_APPEND|os.O_RDWR, 0644)
-		defer zeroFile.Close()
-		for _, line := range zeroContent {
-			_, _ = zeroFile.WriteString(line)
+	dash1 := config.NewDocument()
+	zero := config.NewDocument()
+
+	a := &questionAsker{p: p}
+	useProvider := "n"
+	if askProvider {
+		useProvider = strings.ToLower(a.ask(Question{Key: "use-provider", Text: "Use Cloud Provider? [Y/n]: ", Default: "y"}))
+	}
+	if useProvider == "y" || useProvider == "yes" {
+		useCloudProvider(a, dash1)
+	} else if askProvider {
+		ips := a.ask(Question{Key: "nodes", Text: "Enter IPs:"})
+		zero.Set("ZERO_NODES_MANAGER", ips)
+	}
+
+	customDomain := a.ask(Question{Key: "custom-domain", Text: "Custom Domain? [y/N]: ", Default: "n"})
+	switch strings.ToLower(customDomain) {
+	case "y", "yes":
+		domain := a.ask(Question{Key: "domain", Text: "Enter Domain: "})
+		zero.Set("ZERO_BASE_DOMAIN", domain)
+	}
+	if err := a.done(); err != nil {
+		return err
+	}
+
+	if len(dash1.Keys()) > 0 {
+		fmt.Println("Creating file", dash1Path)
+		err = dash1.WriteFile(dash1Path, 0644)
+		if err != nil {
+			return err
 		}
 	}
+	if len(zero.Keys()) > 0 {
+		return config.NewStore(filepath.Join(envPath, "zero.env")).Merge(zero)
+	}
+	return nil
 }
 
-func useCloudProvider(dash1Content []string) []string {
-	fmt.Print("Cloud Provider [1. HCLOUD, 2. Digital Ocean, 3. AWS]: ")
-	reader := bufio.NewReader(os.Stdin)
-	provider, _ := reader.ReadString('\n')
-	switch strings.TrimSpace(strings.ToLower(provider)) {
-	case "1", "":
-		dash1Content = append(dash1Content, "DASH1_MODULE=hcloud\n")
-		fmt.Print("Enter HCLOUD_TOKEN: ")
-		htoken, _ := reader.ReadString('\n')
-		dash1Content = append(dash1Content, "HCLOUD_TOKEN="+htoken)
-	case "2":
-		dash1Content = append(dash1Content, "DASH1_MODULE=digitalocean\n")
-		fmt.Print("Enter DO_TOKEN: ")
-		doToken, _ := reader.ReadString('\n')
-		dash1Content = append(dash1Content, "DO_TOKEN="+doToken)
-	case "3":
-		dash1Content = append(dash1Content, "DASH1_MODULE=aws\n")
-		fmt.Print("Enter AWS_SECRET_KEY_ID: ")
-		awsSecretKeyId, _ := reader.ReadString('\n')
-		dash1Content = append(dash1Content, "AWS_SECRET_KEY_ID="+awsSecretKeyId)
-		fmt.Print("Enter AWS_SECRET_ACCESS_KEY: ")
-		awsSecretAccessKey, _ := reader.ReadString('\n')
-		dash1Content = append(dash1Content, "AWS_SECRET_ACCESS_KEY="+awsSecretAccessKey)
-	}
-	return dash1Content
+// useCloudProvider asks for the provider and the variables it declares in the provider registry and sets them in dash1.
+// the provider can be answered with its number or its name.
+func useCloudProvider(a *questionAsker, dash1 *config.Document) {
+	var menu []string
+	for i, p := range Providers() {
+		menu = append(menu, fmt.Sprintf("%d. %s", i+1, p.Title))
+	}
+	answer := a.ask(Question{Key: "provider", Text: "Cloud Provider [" + strings.Join(menu, ", ") + "]: ", Default: "1"})
+	provider, ok := LookupProvider(answer)
+	if !ok {
+		if a.err == nil {
+			a.err = fmt.Errorf("unknown cloud provider %q, expected %s", answer, strings.Join(ProviderNames(), ", "))
+		}
+		return
+	}
+	dash1.Set(config.Dash1ModuleKey, provider.Name)
+	for _, v := range provider.Variables {
+		val := a.ask(v.question())
+		if val != "" {
+			dash1.Set(v.Key, val)
+		}
+	}
 }
 
 func pushInitChanges(r *git.Repository, auth transport.AuthMethod) error {
@@ -184,41 +237,12 @@ func createFile(fileName string) *os.File {
 
 func getShipmateUrl() string {
 	// get SHIPMATE_WORKFLOW_URL from if0.env
-	config.ReadConfigFile(common.If0Default)
-	shipmateUrl := config.GetEnvVariable("SHIPMATE_WORKFLOW_URL")
+	store, _ := config.LoadStore(common.If0Default)
+	shipmateUrl := store.Get("SHIPMATE_WORKFLOW_URL")
 	// if not found, add it to if0.env and return the value
 	if shipmateUrl == "" {
-		f, _ := os.OpenFile(common.If0Default, os.O_APPEND, 0644)
-		defer f.Close()
-		_, _ = f.WriteString("SHIPMATE_WORKFLOW_URL=https://gitlab.com/peter.saarland/shipmate/-/raw/master/shipmate.gitlab-ci.yml\n")
-	}
-	config.ReadConfigFile(common.If0Default)
-	return config.GetEnvVariable("SHIPMATE_WORKFLOW_URL")
-}
-
-func downloadLogo(envDir string) error {
-	logoFile := filepath.Join(envDir, "logo.png")
-	url := "https://gitlab.com/peter.saarland/scratch/-/raw/master/logo.png?inline=false"
-
-	out, err := os.Create(logoFile)
-	if err != nil {
-		return err
-	}
-	defer out.Close()
-
-	resp, err := http.Get(url)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("bad status: %s", resp.Status)
+		shipmateUrl = "https://gitlab.com/peter.saarland/shipmate/-/raw/master/shipmate.gitlab-ci.yml"
+		_ = store.Set("SHIPMATE_WORKFLOW_URL", shipmateUrl)
 	}
-
-	_, err = io.Copy(out, resp.Body)
-	if err != nil {
-		return err
-	}
-	return nil
+	return shipmateUrl
 }
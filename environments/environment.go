@@ -1,15 +1,12 @@
 package environments
 
 import (
-	"bufio"
 	"errors"
 	"fmt"
-	"github.com/spf13/viper"
 	"if0/common"
 	"if0/common/sync"
 	"if0/config"
 	"if0/environments/dockercmd"
-	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strings"
@@ -22,32 +19,56 @@ var (
 	clone    = syncObj.Clone
 )
 
-func AddEnv(addEnvArgs []string) error {
+// AddOptions control how AddEnv creates environments
+type AddOptions struct {
+	// Prompter answers the questions about the new environment, by default they are read from the console
+	Prompter Prompter
+	// Template scaffolds the environment, see LoadTemplate. the default template if empty.
+	Template string
+}
+
+// AddEnv adds the environment of addEnvArgs (name and optional repository url)
+func AddEnv(addEnvArgs []string, opts AddOptions) error {
+	p := opts.Prompter
+	if p == nil {
+		p = stdinPrompter()
+	}
+	tmpl, err := LoadTemplate(opts.Template)
+	if err != nil {
+		return err
+	}
+	defer tmpl.Close()
+	// before anything is created
+	err = tmpl.confirmHooks(p)
+	if err != nil {
+		return err
+	}
 	var repoName, repoUrl string
 	if len(addEnvArgs) == 0 {
-		fmt.Print("Env Name?: ")
-		reader := bufio.NewReader(os.Stdin)
-		name, _ := reader.ReadString('\n')
-		repoName = strings.TrimSpace(name)
+		a := &questionAsker{p: p}
+		repoName = a.ask(Question{Key: "name", Text: "Env Name?: "})
+		if err := a.done(); err != nil {
+			return err
+		}
 	} else {
 		repoName = addEnvArgs[0]
 	}
 	if len(addEnvArgs) > 1 {
 		repoUrl = addEnvArgs[1]
 	}
-	config.ReadConfigFile(common.If0Default)
-	gitlabToken := config.GetEnvVariable("GL_TOKEN")
+	store, _ := config.LoadStore(common.If0Default)
+	gitlabToken := store.Get("GL_TOKEN")
 	if gitlabToken == "" {
 		// adding environment locally (to sync with later)
 		// or syncing a local environment that has already been added
-		err := createLocalEnv(repoName, repoUrl)
+		err := createLocalEnv(repoName, repoUrl, p, tmpl)
 		if err != nil {
 			return err
 		}
 	} else {
 		// TODO: check if the API is reachable
 		// adding environment using GitLab token
-		err := createGLProject(repoName, gitlabToken)
+		err := createGLProject(repoName, gitlabToken, p, tmpl)
 		if err != nil {
 			fmt.Println("Error: Adding Private Project -", err)
 			return err
@@ -72,36 +93,36 @@ func SyncEnv(envDir string) error {
 	return nil
 }
 
-func Dash1Plan(envDir string) error {
+func Dash1Plan(envDir string, overrides []string) error {
 	envName := strings.Replace(envDir, common.EnvDir, "", 1)
-	err := dockercmd.MakePlan(envName)
+	err := dockercmd.MakePlan(envName, overrides)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-func ZeroPlatform(envDir string) error {
+func ZeroPlatform(envDir string, overrides []string) error {
 	envName := strings.Replace(envDir, common.EnvDir, "", 1)
-	err := dockercmd.MakePlatform(envName)
+	err := dockercmd.MakePlatform(envName, overrides)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-func Dash1Infrastructure(envDir string) error {
+func Dash1Infrastructure(envDir string, overrides []string) error {
 	envName := strings.Replace(envDir, common.EnvDir, "", 1)
-	err := dockercmd.MakeInfrastructure(envName)
+	err := dockercmd.MakeInfrastructure(envName, overrides)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-func Dash1Destroy(envDir string) error {
+func Dash1Destroy(envDir string, overrides []string) error {
 	envName := strings.Replace(envDir, common.EnvDir, "", 1)
-	err := dockercmd.MakeDestroy(envName)
+	err := dockercmd.MakeDestroy(envName, overrides)
 	if err != nil {
 		return err
 	}
@@ -115,46 +136,70 @@ func ListEnv() {
 	}
 }
 
-func InspectEnv(envDir string) {
+// InspectEnv prints the configuration of all *.env files of an environment.
+// with resolve, ${VAR} references are expanded and printed next to the raw value
+// whenever the two differ.
+// with explain, the layer every value comes from and the layers it shadows are printed as well.
+func InspectEnv(envDir string, overrides []string, resolve, explain bool) {
 	fmt.Println("Configuration for zero environment:", envDir)
-	allConfig, err := readAllEnvFiles(envDir)
+	resolver, err := config.LoadEnvironmentResolver(envDir, overrides)
 	if err != nil {
 		fmt.Println("Error: Inspect environment -", err)
-	}
-	if len(allConfig) == 0 {
 		fmt.Println("No configuration found in *.env files")
 		return
 	}
-	for c, val := range allConfig {
-		fmt.Println(strings.ToUpper(c) + "=" + val.(string))
+	if explain {
+		explainEnv(resolver)
+		return
+	}
+	for _, key := range resolver.Keys(config.EnvFileLayer, config.OverrideLayer) {
+		raw, _ := resolver.Lookup(key)
+		raw = maskSecret(key, raw)
+		if !resolve {
+			fmt.Println(key + "=" + raw)
+			continue
+		}
+		val, err := resolver.Resolve(key)
+		val = maskSecret(key, val)
+		if err != nil {
+			fmt.Printf("%s=%s => Error: %s\n", key, raw, err)
+		} else if val != raw {
+			fmt.Printf("%s=%s => %s\n", key, raw, val)
+		} else {
+			fmt.Println(key + "=" + raw)
+		}
 	}
 }
 
-func readAllEnvFiles(dirPath string) (map[string]interface{}, error) {
-	files, err := ioutil.ReadDir(dirPath)
-	if err != nil {
-		fmt.Printf("Error: Reading environment directory %s - %s\n", dirPath, err)
-		return nil, err
-	}
-	if len(files) < 1 {
-		fmt.Println("Info: No .env files found")
-		return nil, errors.New("no .env files found")
-	}
-	allConfig := make(map[string]interface{})
-	for _, file := range files {
-		fileName := filepath.Join(dirPath, file.Name())
-		if filepath.Ext(fileName) == ".env" {
-			viper.SetConfigFile(fileName)
-			err := viper.ReadInConfig()
-			if err != nil {
-				fmt.Printf("Error: while reading %s file - %s\n", fileName, err)
-				continue
-			}
-			currConfig := viper.AllSettings()
-			for k, v := range currConfig {
-				allConfig[k] = v
-			}
+// explainEnv prints the winning value of every key, the layer it came from and the layers it shadows
+func explainEnv(resolver *config.Resolver) {
+	for _, key := range resolver.Keys() {
+		e := resolver.Explain(key)
+		if e.Err != nil {
+			fmt.Printf("%s=%s (Error: %s)\n", key, maskSecret(key, e.Raw), e.Err)
+		} else {
+			fmt.Printf("%s=%s\n", key, maskSecret(key, e.Value))
+		}
+		fmt.Printf("    from %s\n", layerName(e.Origin))
+		for _, o := range e.Shadowed {
+			fmt.Printf("    shadows %s=%s from %s\n", key, maskSecret(key, o.Value), layerName(o.Layer))
 		}
 	}
-	return allConfig, nil
-}
\ No newline at end of file
+}
+
+// maskSecret hides the plaintext values of keys declared secret in the schema, e.g. by a provider.
+// encrypted values and secret:// references are shown as they are.
+func maskSecret(key, value string) string {
+	spec, ok := config.LookupKey(key)
+	if !ok || !spec.Secret || value == "" || config.IsEncrypted(value) || config.IsSecretRef(value) {
+		return value
+	}
+	return "********"
+}
+
+func layerName(layer *config.Layer) string {
+	if layer.Source == "" {
+		return layer.Name
+	}
+	return layer.Source
+}
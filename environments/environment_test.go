@@ -14,12 +14,27 @@ import (
 	"testing"
 )
 
+// useTempIf0Dir points the if0 paths to a temporary directory, the returned function restores them
+func useTempIf0Dir(t *testing.T) func() {
+	dir, err := ioutil.TempDir("", "if0-env")
+	assert.Nil(t, err)
+	if0Dir := common.If0Dir
+	common.SetIf0Dir(dir)
+	return func() {
+		_ = os.RemoveAll(dir)
+		common.SetIf0Dir(if0Dir)
+	}
+}
+
 func TestAddEnvAuthError(t *testing.T) {
+	defer useTempIf0Dir(t)()
 	getAuth = func(authObj sync.AuthOps, remoteStorage string) (transport.AuthMethod, error) {
 		return nil, errors.New("test-auth-error")
 	}
 	config.SetEnvVariable("GL_TOKEN", "")
-	err := AddEnv([]string{"add", "sample_repo", "repo-url"})
+	err := AddEnv([]string{"sample_repo", "file:///nonexistent/sample_repo.git"}, AddOptions{
+		Prompter: NewAnswersPrompter(map[string]string{"provider": "none", "nodes": "10.0.0.1"}, nil),
+	})
 	assert.EqualError(t, err, "test-auth-error")
 }
 
@@ -31,8 +46,11 @@ func TestAddEnvClone(t *testing.T) {
 	pushEnvInitChanges = func(r *git.Repository, auth transport.AuthMethod) error {
 		return nil
 	}
+	defer useTempIf0Dir(t)()
 	config.SetEnvVariable("GL_TOKEN", "")
-	err := AddEnv([]string{"add", "sample_repo", "repo-url"})
+	err := AddEnv([]string{"sample_repo", "file:///nonexistent/sample_repo.git"}, AddOptions{
+		Prompter: NewAnswersPrompter(map[string]string{"provider": "none", "nodes": "10.0.0.1"}, nil),
+	})
 	assert.Nil(t, err)
 }
 
@@ -81,9 +99,46 @@ func TestInspectEnv(t *testing.T) {
 	stdout := os.Stdout
 	r, w, _ := os.Pipe()
 	os.Stdout = w
-	InspectEnv("testdata/test-env-1")
+	InspectEnv("testdata/test-env-1", nil, false, false)
 	w.Close()
 	out, _ := ioutil.ReadAll(r)
 	os.Stdout = stdout
 	assert.Contains(t, string(out), "IF0_ENVIRONMENT=test-repo-1\n")
-}
\ No newline at end of file
+}
+
+func TestInspectEnvResolve(t *testing.T) {
+	common.If0Default = filepath.Join("testdata", "if0.env")
+	envDir := filepath.Join("testdata", "test-env-resolve")
+	_ = os.MkdirAll(envDir, 0755)
+	defer os.RemoveAll(envDir)
+	_ = ioutil.WriteFile(filepath.Join(envDir, "zero.env"),
+		[]byte("ZERO_BASE_DOMAIN=${IF0_ENVIRONMENT_DOMAIN:-example.com}\nZERO_ADMIN_PASSWORD_HASH=$$2y$$05$$abc\n"), 0644)
+	stdout := os.Stdout
+	r, w, _ := os.Pipe()
+	os.Stdout = w
+	InspectEnv(envDir, nil, true, false)
+	w.Close()
+	out, _ := ioutil.ReadAll(r)
+	os.Stdout = stdout
+	assert.Contains(t, string(out), "ZERO_BASE_DOMAIN=${IF0_ENVIRONMENT_DOMAIN:-example.com} => example.com\n")
+	assert.Contains(t, string(out), "ZERO_ADMIN_PASSWORD_HASH=$$2y$$05$$abc => $2y$05$abc\n")
+}
+
+func TestInspectEnvExplain(t *testing.T) {
+	common.If0Default = filepath.Join("testdata", "if0.env")
+	envDir := filepath.Join("testdata", "test-env-explain")
+	_ = os.MkdirAll(envDir, 0755)
+	defer os.RemoveAll(envDir)
+	_ = ioutil.WriteFile(filepath.Join(envDir, "zero.env"), []byte("ZERO_BASE_DOMAIN=zero.example.com\n"), 0644)
+	_ = ioutil.WriteFile(filepath.Join(envDir, "dash1.env"), []byte("ZERO_BASE_DOMAIN=dash1.example.com\n"), 0644)
+	stdout := os.Stdout
+	r, w, _ := os.Pipe()
+	os.Stdout = w
+	InspectEnv(envDir, []string{"ZERO_BASE_DOMAIN=cli.example.com"}, false, true)
+	w.Close()
+	out, _ := ioutil.ReadAll(r)
+	os.Stdout = stdout
+	assert.Contains(t, string(out), "ZERO_BASE_DOMAIN=cli.example.com\n    from command line\n")
+	assert.Contains(t, string(out), "shadows ZERO_BASE_DOMAIN=dash1.example.com from "+filepath.Join(envDir, "dash1.env"))
+	assert.Contains(t, string(out), "shadows ZERO_BASE_DOMAIN=zero.example.com from "+filepath.Join(envDir, "zero.env"))
+}
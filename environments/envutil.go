@@ -7,10 +7,8 @@ import (
 	"if0/common/sync"
 	"if0/config"
 	gitlabclient "if0/environments/git"
-	"net/url"
 	"os"
 	"path/filepath"
-	"strings"
 )
 
 var (
@@ -19,7 +17,7 @@ var (
 
 func cloneEnv(repoUrl, envDir string) (*git.Repository, error) {
 	// get authorization
-	authObj := sync.Auth{}
+	authObj := sync.Auth{EnvDir: envDir, Lookup: config.GetEnvVariable}
 	auth, err := getAuth(&authObj, repoUrl)
 	if err != nil {
 		fmt.Println("Authentication error - ", err)
@@ -56,18 +54,28 @@ func cloneEmptyRepo(remoteStorage, envDir string) (*git.Repository, error) {
 	return r, nil
 }
 
-func createLocalEnv(repoName string, repoUrl string) error {
-	envDir := createNestedDirPath(repoName, repoUrl)
+func createLocalEnv(repoName string, repoUrl string, p Prompter, tmpl *Template) error {
+	envDir, err := createNestedDirPath(repoName, repoUrl)
+	if err != nil {
+		fmt.Println("Error: Creating nested directories - ", err)
+		return err
+	}
 	// if a remote repository (empty) url is provided, sync the changes
 	if repoUrl != "" {
 		_, _ = cloneEnv(repoUrl, envDir)
-		addLocalEnv(envDir)
-		err := syncLocalEnvChanges(repoUrl, envDir)
+		err = addLocalEnv(envDir, p, tmpl)
+		if err != nil {
+			return err
+		}
+		err = syncLocalEnvChanges(repoUrl, envDir)
 		if err != nil {
 			return err
 		}
 	} else {
-		addLocalEnv(envDir)
+		err = addLocalEnv(envDir, p, tmpl)
+		if err != nil {
+			return err
+		}
 		fmt.Println("No remote repository url was found for sync. "+
 			"The local copy of the environment can be found at ", envDir)
 		fmt.Println("To sync the local changes, run `if0 add repo-name repo-url`")
@@ -75,15 +83,22 @@ func createLocalEnv(repoName string, repoUrl string) error {
 	return nil
 }
 
-func createGLProject(repoName, glToken string) error {
-	config.ReadConfigFile(common.If0Default)
-	if0RegUrl := config.GetEnvVariable("IF0_REGISTRY_URL")
-	if0RegGroup := config.GetEnvVariable("IF0_REGISTRY_GROUP")
+func createGLProject(repoName, glToken string, p Prompter, tmpl *Template) error {
+	store, _ := config.LoadStore(common.If0Default)
+	if0RegUrl := store.Get("IF0_REGISTRY_URL")
+	if0RegGroup := store.Get("IF0_REGISTRY_GROUP")
 	httpRepoUrl := if0RegUrl+"/"+if0RegGroup+"/"+repoName
 	// adding the environment locally
 	// TODO: we need a check here to check if the project exists already on gitlab
-	envDir := createNestedDirPath(repoName, httpRepoUrl)
-	addLocalEnv(envDir)
+	envDir, err := createNestedDirPath(repoName, httpRepoUrl)
+	if err != nil {
+		fmt.Println("Error: Creating nested directories - ", err)
+		return err
+	}
+	err = addLocalEnv(envDir, p, tmpl)
+	if err != nil {
+		return err
+	}
 	// creating a private project in gitlab
 	sshRepoUrl, _, err := gitlabclient.CreateProject(repoName, glToken)
 	if err != nil {
@@ -98,7 +113,7 @@ func createGLProject(repoName, glToken string) error {
 }
 
 func syncLocalEnvChanges(repoUrl string, envDir string) error {
-	authObj := sync.Auth{}
+	authObj := sync.Auth{EnvDir: envDir, Lookup: config.GetEnvVariable}
 	auth, err := getAuth(&authObj, repoUrl)
 	if err != nil {
 		fmt.Println("Authentication error - ", err)
@@ -113,7 +128,7 @@ func syncLocalEnvChanges(repoUrl string, envDir string) error {
 	return nil
 }
 
-func addLocalEnv(envDir string) {
+func addLocalEnv(envDir string, p Prompter, tmpl *Template) error {
 	// check if the repo exists already.
 	// if it does not exist, create a new one locally and sync
 	if _, err := os.Stat(envDir); os.IsNotExist(err) {
@@ -123,41 +138,31 @@ func addLocalEnv(envDir string) {
 		}
 	} else {
 		fmt.Println("environment already present")
-		return
+		return nil
 	}
-	_ = envInit(envDir)
-}
-
-func createNestedDirPath(repoName, repoUrl string) string {
-	var dirPath string
-	if repoUrl != "" {
-		repoUrl = removePortNumber(repoUrl)
-		dirPathElem := strings.FieldsFunc(repoUrl, func(r rune) bool {
-			return r == ':' || r == '/' || r == '@'
-		})
-		dirPathElem[len(dirPathElem)-1] = strings.Split(dirPathElem[len(dirPathElem)-1], ".")[0]
-		dirPath = filepath.Join(common.EnvDir, strings.Join(dirPathElem, string(os.PathSeparator)))
-	} else {
-		dirPath = filepath.Join(common.EnvDir, repoName)
+	err := envInit(envDir, p, tmpl)
+	if _, ok := err.(*MissingAnswersError); ok {
+		// nothing has been written that is worth keeping
+		_ = os.RemoveAll(envDir)
+		removeEmptyParents(envDir, common.EnvDir)
 	}
-	return dirPath
+	return err
 }
 
-func removePortNumber(repoUrl string) string {
-	if strings.Contains(repoUrl, "ssh:") {
-		u, _ := url.Parse(repoUrl)
-		repoUrl = strings.Replace(repoUrl, u.Port(), "", 1)
-		i := strings.Index(repoUrl, "git@")
-		repoUrl = repoUrl[i+4:]
-	} else if strings.Contains(repoUrl, "git@") {
-		i := strings.Index(repoUrl, "git@")
-		repoUrl = repoUrl[i+4:]
-	} else if strings.Contains(repoUrl, "http") {
-		u, _ := url.Parse(repoUrl)
-		repoUrl = strings.Replace(repoUrl, u.Port(), "", 1)
-		repoUrl = strings.Replace(repoUrl, u.Scheme, "", 1)
-	}
-	return repoUrl
+// createNestedDirPath returns the directory of an environment below EnvDir: the host and path of repoUrl,
+// e.g. gitlab.com/group/env for git@gitlab.com:group/env.git and https://gitlab.com:8443/group/env, or repoName without url
+func createNestedDirPath(repoName, repoUrl string) (string, error) {
+	if repoUrl == "" {
+		return filepath.Join(common.EnvDir, repoName), nil
+	}
+	remote, err := sync.ParseRemoteURL(repoUrl)
+	if err != nil {
+		return "", err
+	}
+	if remote.Path == "" {
+		return "", fmt.Errorf("invalid url %s: no repository path", remote)
+	}
+	return filepath.Join(common.EnvDir, filepath.FromSlash(remote.Canonical())), nil
 }
 
 // helper functions for `if0 list`
@@ -194,6 +199,9 @@ func getRepoUrl(envDir string) string {
 	if err != nil {
 		return ""
 	}
-	remotes, _ := r.Remote("origin")
+	remotes, err := r.Remote("origin")
+	if err != nil || len(remotes.Config().URLs) == 0 {
+		return ""
+	}
 	return remotes.Config().URLs[0]
 }
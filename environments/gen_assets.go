@@ -0,0 +1,44 @@
+//go:build ignore
+// +build ignore
+
+// gen_assets writes the files of the assets directory to assets_embedded.go, so that
+// new environments can be created without network access. run with `go generate ./environments`.
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"sort"
+)
+
+func main() {
+	files, err := filepath.Glob(filepath.Join("assets", "*"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	sort.Strings(files)
+	var out bytes.Buffer
+	out.WriteString("// Code generated by gen_assets.go; DO NOT EDIT.\n\npackage environments\n\n")
+	out.WriteString("// embeddedAssets are the assets built into if0, see assets.go\n")
+	out.WriteString("var embeddedAssets = map[string][]byte{\n")
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Fprintf(&out, "\t%q: []byte(%+q),\n", filepath.Base(file), data)
+	}
+	out.WriteString("}\n")
+	src, err := format.Source(out.Bytes())
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = ioutil.WriteFile("assets_embedded.go", src, 0644)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
@@ -45,13 +45,13 @@ func gitlabClient(gitlabToken string) (*gitlab.Client, error) {
 }
 
 func getIf0RegistryUrl() string {
-	config.ReadConfigFile(common.If0Default)
-	return config.GetEnvVariable("IF0_REGISTRY_URL")
+	store, _ := config.LoadStore(common.If0Default)
+	return store.Get("IF0_REGISTRY_URL")
 }
 
 func getIf0GroupId(client *gitlab.Client) (int, error) {
-	config.ReadConfigFile(common.If0Default)
-	groupName := config.GetEnvVariable("IF0_REGISTRY_GROUP")
+	store, _ := config.LoadStore(common.If0Default)
+	groupName := store.Get("IF0_REGISTRY_GROUP")
 
 	var namespaceId int
 	namespace, _, err := client.Namespaces.SearchNamespace(groupName)
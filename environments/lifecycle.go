@@ -0,0 +1,460 @@
+package environments
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"if0/common"
+	"if0/config"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// archiveExt is the extension of archived environments in ~/.if0/.archive
+const archiveExt = ".tar.gz"
+
+// RemoveEnv deletes the local copy of an environment. an environment with terraform state is only
+// removed with force, as its infrastructure would be left without state to destroy it.
+func RemoveEnv(name string, force bool) error {
+	name, err := resolveLocalEnv(name)
+	if err != nil {
+		return err
+	}
+	envDir := EnvDirOf(name)
+	state, err := infrastructureState(envDir)
+	if err != nil {
+		return err
+	}
+	if len(state) > 0 && !force {
+		return fmt.Errorf("environment %s has infrastructure state (%s), run `if0 destroy %s` first or use --force",
+			name, strings.Join(state, ", "), name)
+	}
+	err = os.RemoveAll(envDir)
+	if err != nil {
+		return err
+	}
+	removeEmptyParents(envDir, common.EnvDir)
+	return forgetCurrentEnv(name, "")
+}
+
+// CopyEnv creates the environment dst from the configuration of src, as the starting point of e.g. a staging environment.
+// the environment is created by envInit with the files of src, so its admin password, secrets and SSH keys are
+// generated as for a new environment. git history and infrastructure state are not copied.
+func CopyEnv(src, dst string) (dstDir string, err error) {
+	src, err = resolveLocalEnv(src)
+	if err != nil {
+		return "", err
+	}
+	dstDir, err = newEnvDir(dst)
+	if err != nil {
+		return "", err
+	}
+	// a partly created environment is removed
+	created := dstDir
+	defer func() {
+		if err != nil {
+			_ = os.RemoveAll(created)
+			removeEmptyParents(created, common.EnvDir)
+		}
+	}()
+	srcDir := EnvDirOf(src)
+	err = copyTree(srcDir, dstDir, func(rel string, info os.FileInfo) bool {
+		return rel == envMetadataFile || isStateFile(info.Name()) ||
+			info.Name() == ".git" || info.Name() == ".ssh" || info.Name() == ".terraform"
+	})
+	if err != nil {
+		return "", err
+	}
+	// the generated keys of zero.env are left out, envInit creates new ones
+	err = unsetGeneratedKeys(filepath.Join(dstDir, common.ZeroEnvFile))
+	if err != nil {
+		return "", err
+	}
+	// the infrastructure and the domain are those of src
+	err = envInit(dstDir, NewAnswersPrompter(map[string]string{"custom-domain": "n"}, nil), nil)
+	if err != nil {
+		return "", err
+	}
+	return dstDir, nil
+}
+
+// unsetGeneratedKeys removes the generatedZeroKeys from zeroFile, if it exists
+func unsetGeneratedKeys(zeroFile string) error {
+	unlock, err := config.LockConfig()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	zero, err := config.ReadDocument(zeroFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, key := range generatedZeroKeys {
+		zero.Unset(key)
+	}
+	return zero.WriteFile(zeroFile, 0644)
+}
+
+// MoveEnv renames an environment, IF0_ENVIRONMENT and the current environment are updated
+func MoveEnv(src, dst string) (string, error) {
+	src, err := resolveLocalEnv(src)
+	if err != nil {
+		return "", err
+	}
+	dstDir, err := newEnvDir(dst)
+	if err != nil {
+		return "", err
+	}
+	// newEnvDir created the directory, the environment takes its place
+	err = os.Remove(dstDir)
+	if err != nil {
+		return "", err
+	}
+	srcDir := EnvDirOf(src)
+	err = os.Rename(srcDir, dstDir)
+	if err != nil {
+		return "", err
+	}
+	removeEmptyParents(srcDir, common.EnvDir)
+	dst = envNameOf(dstDir)
+	err = config.SetConfigValue(filepath.Join(dstDir, common.ZeroEnvFile), "IF0_ENVIRONMENT", dst)
+	if err != nil {
+		return dstDir, err
+	}
+	return dstDir, forgetCurrentEnv(src, dst)
+}
+
+// ArchiveEnv moves an environment into a compressed archive in ~/.if0/.archive,
+// where it is no longer listed or addressed by environment commands. returns the archive.
+func ArchiveEnv(name string) (string, error) {
+	name, err := resolveLocalEnv(name)
+	if err != nil {
+		return "", err
+	}
+	archive := filepath.Join(common.ArchiveDir, filepath.FromSlash(name)+archiveExt)
+	if _, err := os.Stat(archive); err == nil {
+		return "", fmt.Errorf("archive %s already exists", archive)
+	}
+	err = os.MkdirAll(filepath.Dir(archive), 0755)
+	if err != nil {
+		return "", err
+	}
+	envDir := EnvDirOf(name)
+	err = writeArchive(envDir, archive)
+	if err != nil {
+		_ = os.Remove(archive)
+		return "", err
+	}
+	err = os.RemoveAll(envDir)
+	if err != nil {
+		return archive, err
+	}
+	removeEmptyParents(envDir, common.EnvDir)
+	return archive, forgetCurrentEnv(name, "")
+}
+
+// UnarchiveEnv restores an archived environment, name is matched like the names of environments
+func UnarchiveEnv(name string) (string, error) {
+	names, err := ArchivedEnvNames()
+	if err != nil {
+		return "", err
+	}
+	match, err := matchEnvName(strings.Trim(filepath.ToSlash(name), "/"), names)
+	if err != nil {
+		return "", err
+	}
+	if match == "" {
+		return "", fmt.Errorf("no archived environment %q in %s", name, common.ArchiveDir)
+	}
+	envDir, err := newEnvDir(match)
+	if err != nil {
+		return "", err
+	}
+	archive := filepath.Join(common.ArchiveDir, filepath.FromSlash(match)+archiveExt)
+	err = extractArchive(archive, envDir)
+	if err != nil {
+		_ = os.RemoveAll(envDir)
+		return "", err
+	}
+	err = os.Remove(archive)
+	if err != nil {
+		return envDir, err
+	}
+	removeEmptyParents(archive, common.ArchiveDir)
+	return envDir, nil
+}
+
+// ArchivedEnvNames returns the names of the archived environments
+func ArchivedEnvNames() ([]string, error) {
+	var names []string
+	err := filepath.Walk(common.ArchiveDir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && p == common.ArchiveDir {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if !info.IsDir() && strings.HasSuffix(p, archiveExt) {
+			rel, err := filepath.Rel(common.ArchiveDir, strings.TrimSuffix(p, archiveExt))
+			if err != nil {
+				return err
+			}
+			names = append(names, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	sort.Strings(names)
+	return names, err
+}
+
+// resolveLocalEnv resolves name like ResolveEnvName, but only accepts directories holding an environment,
+// so that e.g. gitlab.com cannot be removed as a whole
+func resolveLocalEnv(name string) (string, error) {
+	resolved, err := ResolveEnvName(name)
+	if err != nil {
+		return "", err
+	}
+	if !checkForZeroEnv(EnvDirOf(resolved)) {
+		return "", fmt.Errorf("%s is not an environment, it has no %s", resolved, common.ZeroEnvFile)
+	}
+	return resolved, nil
+}
+
+// infrastructureState returns the terraform state files of an environment that are not empty
+func infrastructureState(envDir string) ([]string, error) {
+	var state []string
+	err := filepath.Walk(envDir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() && info.Name() == ".git" {
+			return filepath.SkipDir
+		}
+		if !info.IsDir() && strings.HasSuffix(info.Name(), ".tfstate") && info.Size() > 0 {
+			rel, _ := filepath.Rel(envDir, p)
+			state = append(state, rel)
+		}
+		return nil
+	})
+	return state, err
+}
+
+func isStateFile(name string) bool {
+	return strings.HasSuffix(name, ".tfstate") || strings.HasSuffix(name, ".tfstate.backup") || name == "dash1.plan"
+}
+
+// newEnvDir creates the directory of a new environment, it fails if the environment exists
+func newEnvDir(name string) (string, error) {
+	name = strings.Trim(filepath.ToSlash(name), "/")
+	envDir := EnvDirOf(name)
+	if name == "" || !strings.HasPrefix(envDir, filepath.Clean(common.EnvDir)+string(os.PathSeparator)) {
+		return "", fmt.Errorf("invalid environment name %q", name)
+	}
+	if _, err := os.Stat(envDir); err == nil {
+		return "", fmt.Errorf("environment %s already exists", name)
+	}
+	return envDir, os.MkdirAll(envDir, 0755)
+}
+
+// envNameOf returns the name of the environment in envDir
+func envNameOf(envDir string) string {
+	rel, err := filepath.Rel(common.EnvDir, envDir)
+	if err != nil {
+		return envDir
+	}
+	return filepath.ToSlash(rel)
+}
+
+// forgetCurrentEnv updates the current environment if it was name: it is set to newName or unset
+func forgetCurrentEnv(name, newName string) error {
+	current, err := config.GetConfigValue(common.If0Default, CurrentEnvKey)
+	if err != nil || current != name {
+		return nil
+	}
+	if newName != "" {
+		return config.SetConfigValue(common.If0Default, CurrentEnvKey, newName)
+	}
+	return config.UnsetConfigValue(common.If0Default, CurrentEnvKey)
+}
+
+// removeEmptyParents removes the directories between path and root that are left empty
+func removeEmptyParents(path, root string) {
+	root = filepath.Clean(root)
+	for dir := filepath.Dir(path); strings.HasPrefix(dir, root+string(os.PathSeparator)); dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			return
+		}
+	}
+}
+
+// mergeMissingKeys adds the keys of src that are missing in dst to dst
+func mergeMissingKeys(src, dst string) error {
+	srcDoc, err := config.ReadDocument(src)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	dstDoc, err := config.ReadDocument(dst)
+	if err != nil {
+		return err
+	}
+	dstDoc.MergeDefaults(srcDoc)
+	return dstDoc.WriteFile(dst, 0644)
+}
+
+// copyTree copies the files below src to dst, skipping the files and directories skip returns true for
+func copyTree(src, dst string, skip func(rel string, info os.FileInfo) bool) error {
+	return filepath.Walk(src, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, p)
+		if err != nil || rel == "." {
+			return err
+		}
+		if skip(filepath.ToSlash(rel), info) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		target := filepath.Join(dst, rel)
+		switch {
+		case info.IsDir():
+			return os.MkdirAll(target, info.Mode().Perm())
+		case info.Mode()&os.ModeSymlink != 0:
+			link, err := os.Readlink(p)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		default:
+			data, err := ioutil.ReadFile(p)
+			if err != nil {
+				return err
+			}
+			return ioutil.WriteFile(target, data, info.Mode().Perm())
+		}
+	})
+}
+
+// writeArchive writes the files below dir to a gzip compressed tar archive
+func writeArchive(dir, archive string) error {
+	f, err := os.OpenFile(archive, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+	err = filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil || rel == "." {
+			return err
+		}
+		var link string
+		if info.Mode()&os.ModeSymlink != 0 {
+			if link, err = os.Readlink(p); err != nil {
+				return err
+			}
+		}
+		header, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(rel)
+		if err = tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		src, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer src.Close()
+		_, err = io.Copy(tw, src)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if err = tw.Close(); err != nil {
+		return err
+	}
+	if err = gz.Close(); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+// extractArchive extracts an archive written by writeArchive to dir
+func extractArchive(archive, dir string) error {
+	f, err := os.Open(archive)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return err
+	}
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dir, filepath.FromSlash(header.Name))
+		if !strings.HasPrefix(target, filepath.Clean(dir)+string(os.PathSeparator)) {
+			return fmt.Errorf("invalid path %s in archive %s", header.Name, archive)
+		}
+		mode := os.FileMode(header.Mode).Perm()
+		switch header.Typeflag {
+		case tar.TypeDir:
+			err = os.MkdirAll(target, mode)
+		case tar.TypeSymlink:
+			link := filepath.Join(filepath.Dir(target), filepath.FromSlash(header.Linkname))
+			if filepath.IsAbs(header.Linkname) || (link != filepath.Clean(dir) &&
+				!strings.HasPrefix(link, filepath.Clean(dir)+string(os.PathSeparator))) {
+				return fmt.Errorf("link %s in archive %s points outside of the environment", header.Name, archive)
+			}
+			err = os.Symlink(header.Linkname, target)
+		case tar.TypeReg:
+			err = extractFile(tr, target, mode)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func extractFile(r io.Reader, target string, mode os.FileMode) error {
+	err := os.MkdirAll(filepath.Dir(target), 0755)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(target, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, mode)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(f, r)
+	return err
+}
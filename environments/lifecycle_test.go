@@ -0,0 +1,135 @@
+package environments
+
+import (
+	"github.com/stretchr/testify/assert"
+	"if0/common"
+	"if0/config"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRemoveEnv(t *testing.T) {
+	defer setupContextTest(t)()
+	_, _ = UseEnv("acme/shop")
+	state := filepath.Join(EnvDirOf("gitlab.com/acme/shop"), "terraform.tfstate")
+	_ = ioutil.WriteFile(state, []byte("{}"), 0644)
+
+	err := RemoveEnv("acme/shop", false)
+	assert.Error(t, err)
+	assert.FileExists(t, state)
+	assert.Error(t, RemoveEnv("gitlab.com", true), "only environments can be removed")
+
+	assert.Nil(t, RemoveEnv("acme/shop", true))
+	_, err = os.Stat(EnvDirOf("gitlab.com/acme/shop"))
+	assert.True(t, os.IsNotExist(err))
+	assert.Equal(t, "", CurrentEnv())
+
+	assert.Nil(t, RemoveEnv("blog", false))
+	_, err = os.Stat(filepath.Join(common.EnvDir, "gitlab.com", "acme"))
+	assert.True(t, os.IsNotExist(err), "empty parent directories are removed")
+}
+
+func TestMoveEnv(t *testing.T) {
+	defer setupContextTest(t)()
+	_, _ = UseEnv("acme/blog")
+	envDir, err := MoveEnv("acme/blog", "gitlab.com/acme/news")
+	assert.Nil(t, err)
+	assert.Equal(t, EnvDirOf("gitlab.com/acme/news"), envDir)
+	val, _ := config.GetConfigValue(filepath.Join(envDir, "zero.env"), "IF0_ENVIRONMENT")
+	assert.Equal(t, "gitlab.com/acme/news", val)
+	assert.Equal(t, "gitlab.com/acme/news", CurrentEnv())
+
+	_, err = MoveEnv("news", "gitlab.com/beta/shop")
+	assert.EqualError(t, err, "environment gitlab.com/beta/shop already exists")
+	_, err = MoveEnv("news", "../outside")
+	assert.Error(t, err)
+}
+
+func TestArchiveEnv(t *testing.T) {
+	defer setupContextTest(t)()
+	envDir := EnvDirOf("gitlab.com/beta/shop")
+	_ = os.MkdirAll(filepath.Join(envDir, ".ssh"), 0700)
+	_ = ioutil.WriteFile(filepath.Join(envDir, ".ssh", "id_rsa"), []byte("key"), 0600)
+
+	archive, err := ArchiveEnv("beta/shop")
+	assert.Nil(t, err)
+	assert.FileExists(t, archive)
+	names, _ := EnvNames()
+	assert.NotContains(t, names, "gitlab.com/beta/shop")
+	archived, _ := ArchivedEnvNames()
+	assert.Equal(t, []string{"gitlab.com/beta/shop"}, archived)
+
+	restored, err := UnarchiveEnv("beta")
+	assert.Nil(t, err)
+	assert.Equal(t, envDir, restored)
+	data, _ := ioutil.ReadFile(filepath.Join(envDir, ".ssh", "id_rsa"))
+	assert.Equal(t, "key", string(data))
+	info, _ := os.Stat(filepath.Join(envDir, ".ssh", "id_rsa"))
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	archived, _ = ArchivedEnvNames()
+	assert.Empty(t, archived)
+}
+
+func TestExtractArchiveLinks(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "if0-archive")
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "src")
+	_ = os.MkdirAll(filepath.Join(src, "conf"), 0755)
+	_ = ioutil.WriteFile(filepath.Join(src, "zero.env"), []byte("IF0_ENVIRONMENT=env\n"), 0644)
+	assert.Nil(t, os.Symlink("../zero.env", filepath.Join(src, "conf", "zero.env")))
+	archive := filepath.Join(dir, "env.tar.gz")
+	assert.Nil(t, writeArchive(src, archive))
+	assert.Nil(t, extractArchive(archive, filepath.Join(dir, "inside")))
+	link, _ := os.Readlink(filepath.Join(dir, "inside", "conf", "zero.env"))
+	assert.Equal(t, "../zero.env", link)
+
+	for _, target := range []string{"../../../outside", "/etc/passwd"} {
+		_ = os.Remove(filepath.Join(src, "conf", "zero.env"))
+		assert.Nil(t, os.Symlink(target, filepath.Join(src, "conf", "zero.env")))
+		_ = os.Remove(archive)
+		assert.Nil(t, writeArchive(src, archive))
+		err := extractArchive(archive, filepath.Join(dir, "outside"))
+		assert.EqualError(t, err, "link conf/zero.env in archive "+archive+" points outside of the environment", target)
+		_ = os.RemoveAll(filepath.Join(dir, "outside"))
+	}
+}
+
+func TestCopyEnv(t *testing.T) {
+	defer setupContextTest(t)()
+	srcDir := EnvDirOf("gitlab.com/acme/shop")
+	_ = ioutil.WriteFile(filepath.Join(srcDir, "zero.env"),
+		[]byte("IF0_ENVIRONMENT=gitlab.com/acme/shop\nZERO_ADMIN_PASSWORD=secret\nZERO_BASE_DOMAIN=shop.example.com\n"), 0644)
+	_ = ioutil.WriteFile(filepath.Join(srcDir, "dash1.env"), []byte("DASH1_MODULE=hcloud\n"), 0644)
+	_ = ioutil.WriteFile(filepath.Join(srcDir, "terraform.tfstate"), []byte("{}"), 0644)
+
+	dstDir, err := CopyEnv("acme/shop", "gitlab.com/acme/staging")
+	assert.Nil(t, err)
+	assert.FileExists(t, filepath.Join(dstDir, "dash1.env"))
+	assert.FileExists(t, filepath.Join(dstDir, ".ssh", "id_ed25519"))
+	_, err = os.Stat(filepath.Join(dstDir, "terraform.tfstate"))
+	assert.True(t, os.IsNotExist(err), "state is not copied")
+
+	doc, err := config.ReadDocument(filepath.Join(dstDir, "zero.env"))
+	assert.Nil(t, err)
+	name, _ := doc.Get("IF0_ENVIRONMENT")
+	assert.Equal(t, "gitlab.com/acme/staging", name)
+	domain, _ := doc.Get("ZERO_BASE_DOMAIN")
+	assert.Equal(t, "shop.example.com", domain)
+	password, _ := doc.Get("ZERO_ADMIN_PASSWORD")
+	assert.NotEqual(t, "secret", password)
+	assert.True(t, config.IsEncrypted(password))
+}
+
+func TestCopyEnvRemovesIncompleteEnv(t *testing.T) {
+	defer setupContextTest(t)()
+	// without dash1.env and nodes, the infrastructure would have to be asked for
+	srcDir := EnvDirOf("gitlab.com/acme/shop")
+	_ = ioutil.WriteFile(filepath.Join(srcDir, "zero.env"), []byte("IF0_ENVIRONMENT=gitlab.com/acme/shop\n"), 0644)
+
+	dstDir, err := CopyEnv("acme/shop", "gitlab.com/other/staging")
+	assert.Error(t, err)
+	assert.Empty(t, dstDir)
+	assert.NoDirExists(t, EnvDirOf("gitlab.com/other"))
+}
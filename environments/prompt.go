@@ -0,0 +1,195 @@
+package environments
+
+import (
+	"bufio"
+	"fmt"
+	"gopkg.in/yaml.v2"
+	"if0/config"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Question is asked by a Prompter. Key names the answer in flags and answers files.
+type Question struct {
+	Key     string
+	Text    string
+	Default string
+	// Optional questions may be left unanswered
+	Optional bool
+	// Check validates the answer, if set
+	Check func(answer string) error
+}
+
+// Prompter answers the questions of `if0 add`
+type Prompter interface {
+	Ask(q Question) (string, error)
+}
+
+// MissingAnswersError lists the questions a non-interactive Prompter has no answer for
+type MissingAnswersError struct {
+	Keys []string
+}
+
+func (e *MissingAnswersError) Error() string {
+	return "missing answers for " + strings.Join(e.Keys, ", ") + ", provide them with flags or an answers file"
+}
+
+// consolePrompter reads the answers from the console
+type consolePrompter struct {
+	in  *bufio.Reader
+	out io.Writer
+}
+
+// NewConsolePrompter asks the questions on out and reads the answers from in
+func NewConsolePrompter(in io.Reader, out io.Writer) Prompter {
+	return &consolePrompter{in: bufio.NewReader(in), out: out}
+}
+
+// Ask repeats the question until the answer is valid
+func (p *consolePrompter) Ask(q Question) (string, error) {
+	for {
+		_, _ = fmt.Fprint(p.out, q.Text)
+		answer, err := p.in.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return "", err
+		}
+		answer = strings.TrimSpace(answer)
+		if answer == "" {
+			answer = q.Default
+		}
+		checkErr := q.check(answer)
+		if checkErr == nil {
+			return answer, nil
+		}
+		if err == io.EOF {
+			return "", checkErr
+		}
+		_, _ = fmt.Fprintln(p.out, "Error:", checkErr)
+	}
+}
+
+// answersPrompter answers questions from flags or an answers file
+type answersPrompter struct {
+	answers  map[string]string
+	fallback Prompter
+}
+
+// NewAnswersPrompter answers questions from answers. questions without answer are asked with fallback.
+// without fallback, they are answered with their default and fail with a MissingAnswersError if there is none.
+func NewAnswersPrompter(answers map[string]string, fallback Prompter) Prompter {
+	normalized := make(map[string]string)
+	for key, val := range answers {
+		normalized[strings.ToLower(key)] = strings.TrimSpace(val)
+	}
+	// --provider and --domain answer the yes/no questions leading to them
+	if provider, ok := normalized["provider"]; ok && normalized["use-provider"] == "" {
+		normalized["use-provider"] = "y"
+		if provider == "none" {
+			normalized["use-provider"] = "n"
+		}
+	}
+	if normalized["domain"] != "" && normalized["custom-domain"] == "" {
+		normalized["custom-domain"] = "y"
+	}
+	return &answersPrompter{answers: normalized, fallback: fallback}
+}
+
+func (p *answersPrompter) Ask(q Question) (string, error) {
+	if answer, ok := p.answers[strings.ToLower(q.Key)]; ok {
+		if err := q.check(answer); err != nil {
+			return "", fmt.Errorf("invalid answer for %s: %s", q.Key, err)
+		}
+		return answer, nil
+	}
+	if p.fallback != nil {
+		return p.fallback.Ask(q)
+	}
+	if q.Default != "" || q.Optional {
+		return q.Default, nil
+	}
+	return "", &MissingAnswersError{Keys: []string{q.Key}}
+}
+
+func (q Question) check(answer string) error {
+	if q.Check == nil {
+		return nil
+	}
+	return q.Check(answer)
+}
+
+// ReadAnswersFile reads an answers file, a YAML map for *.yml and *.yaml files and KEY=VALUE pairs otherwise
+func ReadAnswersFile(file string) (map[string]string, error) {
+	answers := make(map[string]string)
+	ext := strings.ToLower(filepath.Ext(file))
+	if ext != ".yml" && ext != ".yaml" {
+		doc, err := config.ReadDocument(file)
+		if err != nil {
+			return nil, err
+		}
+		return doc.Map(), nil
+	}
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var values map[string]interface{}
+	if err := yaml.Unmarshal(data, &values); err != nil {
+		return nil, fmt.Errorf("reading answers file %s: %s", file, err)
+	}
+	for key, val := range values {
+		switch v := val.(type) {
+		case []interface{}:
+			var items []string
+			for _, item := range v {
+				items = append(items, fmt.Sprint(item))
+			}
+			answers[key] = strings.Join(items, ",")
+		case nil:
+			answers[key] = ""
+		default:
+			answers[key] = fmt.Sprint(v)
+		}
+	}
+	return answers, nil
+}
+
+// questionAsker asks questions until the first error, missing answers are collected
+// so that all of them are reported together
+type questionAsker struct {
+	p       Prompter
+	missing []string
+	err     error
+}
+
+func (a *questionAsker) ask(q Question) string {
+	if a.err != nil {
+		return ""
+	}
+	answer, err := a.p.Ask(q)
+	if missing, ok := err.(*MissingAnswersError); ok {
+		a.missing = append(a.missing, missing.Keys...)
+		return q.Default
+	}
+	a.err = err
+	return answer
+}
+
+func (a *questionAsker) done() error {
+	if a.err != nil {
+		return a.err
+	}
+	if len(a.missing) > 0 {
+		sort.Strings(a.missing)
+		return &MissingAnswersError{Keys: a.missing}
+	}
+	return nil
+}
+
+// stdinPrompter is the Prompter of interactive commands
+func stdinPrompter() Prompter {
+	return NewConsolePrompter(os.Stdin, os.Stdout)
+}
@@ -0,0 +1,197 @@
+package environments
+
+import (
+	"fmt"
+	"if0/config"
+	"regexp"
+	"strings"
+)
+
+// Answer keys shared by the providers, so that `if0 add --token-from-env` and answers files
+// work the same for every provider
+const (
+	TokenAnswer        = "token"
+	SecretAnswer       = "secret"
+	RegionAnswer       = "region"
+	InstanceTypeAnswer = "instance-type"
+	NodeCountAnswer    = "node-count"
+)
+
+// Provider is a dash1 module provisioning the nodes of an environment
+type Provider struct {
+	// Name is the DASH1_MODULE of the provider
+	Name  string
+	Title string
+	// Variables are written to dash1.env in this order
+	Variables []ProviderVariable
+}
+
+// ProviderVariable is a variable of dash1.env. the KeySpec is registered in the configuration schema,
+// Required keys are required for environments of the provider only.
+type ProviderVariable struct {
+	config.KeySpec
+	// Answer is the answer key of the question for the variable, the variable name if empty
+	Answer  string
+	Default string
+}
+
+var (
+	providers     = make(map[string]*Provider)
+	providerOrder []string
+)
+
+func init() {
+	for _, p := range []Provider{
+		{Name: "hcloud", Title: "HCLOUD", Variables: []ProviderVariable{
+			secretVar("HCLOUD_TOKEN", TokenAnswer, `^[a-zA-Z0-9]{64}$`),
+			defaultVar("HCLOUD_LOCATION", RegionAnswer, "nbg1"),
+			defaultVar("HCLOUD_SERVER_TYPE", InstanceTypeAnswer, "cx21"),
+			nodeCountVar("HCLOUD_NODE_COUNT"),
+		}},
+		{Name: "digitalocean", Title: "Digital Ocean", Variables: []ProviderVariable{
+			secretVar("DO_TOKEN", TokenAnswer, ""),
+			defaultVar("DO_REGION", RegionAnswer, "fra1"),
+			defaultVar("DO_SIZE", InstanceTypeAnswer, "s-2vcpu-4gb"),
+			nodeCountVar("DO_NODE_COUNT"),
+		}},
+		{Name: "aws", Title: "AWS", Variables: []ProviderVariable{
+			secretVar("AWS_ACCESS_KEY_ID", TokenAnswer, `^[A-Z0-9]{16,128}$`),
+			secretVar("AWS_SECRET_ACCESS_KEY", SecretAnswer, ""),
+			defaultVar("AWS_REGION", RegionAnswer, "eu-central-1"),
+			defaultVar("AWS_INSTANCE_TYPE", InstanceTypeAnswer, "t3.medium"),
+			nodeCountVar("AWS_NODE_COUNT"),
+		}},
+		{Name: "vultr", Title: "Vultr", Variables: []ProviderVariable{
+			secretVar("VULTR_API_KEY", TokenAnswer, ""),
+			defaultVar("VULTR_REGION", RegionAnswer, "fra"),
+			defaultVar("VULTR_PLAN", InstanceTypeAnswer, "vc2-2c-4gb"),
+			nodeCountVar("VULTR_NODE_COUNT"),
+		}},
+		{Name: "scaleway", Title: "Scaleway", Variables: []ProviderVariable{
+			requiredVar("SCW_ACCESS_KEY", TokenAnswer, `^SCW[A-Z0-9]{17}$`),
+			secretVar("SCW_SECRET_KEY", SecretAnswer, ""),
+			requiredVar("SCW_DEFAULT_PROJECT_ID", "", ""),
+			defaultVar("SCW_DEFAULT_ZONE", RegionAnswer, "fr-par-1"),
+			defaultVar("SCW_INSTANCE_TYPE", InstanceTypeAnswer, "DEV1-M"),
+			nodeCountVar("SCW_NODE_COUNT"),
+		}},
+		{Name: "openstack", Title: "OpenStack", Variables: []ProviderVariable{
+			{KeySpec: config.KeySpec{Key: "OS_AUTH_URL", Type: config.TypeURL, Required: true}},
+			requiredVar("OS_USERNAME", "", ""),
+			secretVar("OS_PASSWORD", SecretAnswer, ""),
+			requiredVar("OS_PROJECT_NAME", "", ""),
+			defaultVar("OS_USER_DOMAIN_NAME", "", "Default"),
+			defaultVar("OS_REGION_NAME", RegionAnswer, "RegionOne"),
+			defaultVar("OS_FLAVOR", InstanceTypeAnswer, "m1.medium"),
+			nodeCountVar("OS_NODE_COUNT"),
+		}},
+		{Name: "libvirt", Title: "libvirt (local, Vagrant)", Variables: []ProviderVariable{
+			defaultVar("LIBVIRT_URI", "", "qemu:///system"),
+			{KeySpec: config.KeySpec{Key: "LIBVIRT_MEMORY", Type: config.TypeInt,
+				Description: "memory of a node in MB"}, Default: "4096"},
+			{KeySpec: config.KeySpec{Key: "LIBVIRT_CPUS", Type: config.TypeInt}, Default: "2"},
+			nodeCountVar("LIBVIRT_NODE_COUNT"),
+		}},
+	} {
+		RegisterProvider(p)
+	}
+}
+
+// RegisterProvider adds a provider to the registry and its variables to the configuration schema.
+// a provider with the same name is replaced.
+func RegisterProvider(p Provider) {
+	if _, ok := providers[p.Name]; !ok {
+		providerOrder = append(providerOrder, p.Name)
+	}
+	provider := p
+	providers[p.Name] = &provider
+	var keys []config.KeySpec
+	for _, v := range p.Variables {
+		keys = append(keys, v.KeySpec)
+	}
+	config.RegisterModule(p.Name, keys...)
+}
+
+// LookupProvider returns the provider with the given name, or number in the order of Providers starting at 1
+func LookupProvider(name string) (*Provider, bool) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	for i, n := range providerOrder {
+		if name == n || name == fmt.Sprint(i+1) {
+			return providers[n], true
+		}
+	}
+	return nil, false
+}
+
+// Providers returns the registered providers in the order of registration
+func Providers() []*Provider {
+	var list []*Provider
+	for _, name := range providerOrder {
+		list = append(list, providers[name])
+	}
+	return list
+}
+
+// ProviderNames returns the names of the registered providers
+func ProviderNames() []string {
+	return append([]string{}, providerOrder...)
+}
+
+// question returns the question asking for the value of the variable
+func (v ProviderVariable) question() Question {
+	q := Question{Key: v.Answer, Default: v.Default, Check: v.check}
+	if q.Key == "" {
+		q.Key = v.Key
+	}
+	switch {
+	case v.Default != "":
+		q.Text = fmt.Sprintf("Enter %s [%s]: ", v.Key, v.Default)
+	case v.Required:
+		q.Text = fmt.Sprintf("Enter %s: ", v.Key)
+	default:
+		q.Text = fmt.Sprintf("Enter %s (optional): ", v.Key)
+		q.Optional = true
+	}
+	return q
+}
+
+func (v ProviderVariable) check(value string) error {
+	if value == "" {
+		if v.Required {
+			return fmt.Errorf("%s is required", v.Key)
+		}
+		return nil
+	}
+	// the token of a secret:// reference or an ENC[...] value is not known before it is fetched or decrypted
+	if config.IsEncrypted(value) || config.IsSecretRef(value) {
+		return nil
+	}
+	if err := v.Check(value); err != nil {
+		return fmt.Errorf("%s %s", v.Key, err)
+	}
+	return nil
+}
+
+// secretVar is a required credential
+func secretVar(key, answer, pattern string) ProviderVariable {
+	v := requiredVar(key, answer, pattern)
+	v.Secret = true
+	return v
+}
+
+func requiredVar(key, answer, pattern string) ProviderVariable {
+	v := ProviderVariable{KeySpec: config.KeySpec{Key: key, Type: config.TypeString, Required: true}, Answer: answer}
+	if pattern != "" {
+		v.Pattern = regexp.MustCompile(pattern)
+	}
+	return v
+}
+
+func defaultVar(key, answer, def string) ProviderVariable {
+	return ProviderVariable{KeySpec: config.KeySpec{Key: key, Type: config.TypeString}, Answer: answer, Default: def}
+}
+
+func nodeCountVar(key string) ProviderVariable {
+	return ProviderVariable{KeySpec: config.KeySpec{Key: key, Type: config.TypeInt,
+		Description: "number of nodes"}, Answer: NodeCountAnswer, Default: "1"}
+}
@@ -0,0 +1,66 @@
+package environments
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"if0/config"
+	"strings"
+	"testing"
+)
+
+func TestLookupProvider(t *testing.T) {
+	p, ok := LookupProvider("2")
+	assert.True(t, ok)
+	assert.Equal(t, "digitalocean", p.Name)
+	p, ok = LookupProvider("OpenStack")
+	assert.True(t, ok)
+	assert.Equal(t, "openstack", p.Name)
+	_, ok = LookupProvider("gcp")
+	assert.False(t, ok)
+	assert.Equal(t, []string{"hcloud", "digitalocean", "aws", "vultr", "scaleway", "openstack", "libvirt"}, ProviderNames())
+}
+
+func TestProviderSchema(t *testing.T) {
+	spec, ok := config.LookupKey("VULTR_API_KEY")
+	assert.True(t, ok)
+	assert.True(t, spec.Secret)
+	assert.Equal(t, []string{"vultr"}, spec.RequiredFor)
+	module, _ := config.LookupKey(config.Dash1ModuleKey)
+	assert.Nil(t, module.Check("libvirt"))
+	assert.Equal(t, "********", maskSecret("HCLOUD_TOKEN", "plaintext"))
+	assert.Equal(t, "secret://pass/hcloud", maskSecret("HCLOUD_TOKEN", "secret://pass/hcloud"))
+	assert.Equal(t, "nbg1", maskSecret("HCLOUD_LOCATION", "nbg1"))
+}
+
+func TestProviderQuestions(t *testing.T) {
+	var out bytes.Buffer
+	a := &questionAsker{p: NewConsolePrompter(strings.NewReader("1\nshort\n"+strings.Repeat("a", 64)+"\n\n\nmany\n3\n"), &out)}
+	dash1 := config.NewDocument()
+	useCloudProvider(a, dash1)
+	assert.Nil(t, a.done())
+	assert.Equal(t, "DASH1_MODULE=hcloud\nHCLOUD_TOKEN="+strings.Repeat("a", 64)+"\n"+
+		"HCLOUD_LOCATION=nbg1\nHCLOUD_SERVER_TYPE=cx21\nHCLOUD_NODE_COUNT=3\n", string(dash1.Render()))
+	assert.Contains(t, out.String(), "Error: HCLOUD_TOKEN must match ^[a-zA-Z0-9]{64}$")
+	assert.Contains(t, out.String(), `Error: HCLOUD_NODE_COUNT must be a number, got "many"`)
+
+	a = &questionAsker{p: NewAnswersPrompter(map[string]string{"provider": "openstack", "OS_AUTH_URL": "keystone"}, nil)}
+	useCloudProvider(a, config.NewDocument())
+	assert.EqualError(t, a.done(), `invalid answer for OS_AUTH_URL: OS_AUTH_URL must be an absolute URL, got "keystone"`)
+
+	// references and encrypted values are not checked against the pattern of the token
+	a = &questionAsker{p: NewAnswersPrompter(map[string]string{"provider": "hcloud", "token": "secret://vault/kv/hcloud#token"}, nil)}
+	dash1 = config.NewDocument()
+	useCloudProvider(a, dash1)
+	assert.Nil(t, a.done())
+	// the # of the reference is quoted, it would start a comment otherwise
+	token, _ := config.ParseDocument(dash1.Render()).Get("HCLOUD_TOKEN")
+	assert.Equal(t, "secret://vault/kv/hcloud#token", token)
+	a = &questionAsker{p: NewAnswersPrompter(map[string]string{"provider": "aws", "token": "ENC[if0.v2,abc]", "secret": "s"}, nil)}
+	useCloudProvider(a, config.NewDocument())
+	assert.Nil(t, a.done())
+
+	a = &questionAsker{p: NewAnswersPrompter(map[string]string{"provider": "openstack"}, nil)}
+	useCloudProvider(a, config.NewDocument())
+	assert.EqualError(t, a.done(),
+		"missing answers for OS_AUTH_URL, OS_PROJECT_NAME, OS_USERNAME, secret, provide them with flags or an answers file")
+}
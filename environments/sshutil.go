@@ -1,82 +1,374 @@
 package environments
 
 import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
-	"crypto/x509"
+	"encoding/json"
 	"encoding/pem"
 	"fmt"
+	"github.com/pkg/errors"
 	"golang.org/x/crypto/ssh"
+	"if0/common"
+	"if0/config"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 )
 
-func generateSSHKeyPair(sshDir string) error {
-	privateKeyPath := filepath.Join(sshDir, "id_rsa")
-	publicKeyPath := filepath.Join(sshDir, "id_rsa.pub")
+// Types of the SSH keys of environments
+const (
+	KeyTypeED25519 = "ed25519"
+	KeyTypeECDSA   = "ecdsa"
+	KeyTypeRSA     = "rsa"
+)
+
+// Keys of if0.env and the process environment selecting the SSH keys of new environments
+const (
+	SSHKeyTypeKey       = "IF0_SSH_KEY_TYPE"
+	SSHKeyBitsKey       = "IF0_SSH_KEY_BITS"
+	SSHKeyPassphraseKey = "IF0_SSH_KEY_PASSPHRASE"
+)
+
+// DefaultSSHKeyGrace is how long a rotated SSH key is kept next to its successor
+const DefaultSSHKeyGrace = 7 * 24 * time.Hour
+
+// envMetadataFile records the SSH keys of an environment, next to the *.env files
+const envMetadataFile = ".if0-env.json"
+
+// SSHKeyOptions describe a generated SSH key
+type SSHKeyOptions struct {
+	// Type is KeyTypeED25519 (default), KeyTypeECDSA or KeyTypeRSA
+	Type string
+	// Bits of RSA keys (default 4096) and ECDSA keys (256, 384 or 521, default 256)
+	Bits int
+	// Passphrase encrypts the private key if not empty
+	Passphrase []byte
+	// Comment identifies the key, e.g. if0@gitlab.com/group/env-1
+	Comment string
+}
+
+// SSHKeyInfo is the record of an SSH key pair of an environment
+type SSHKeyInfo struct {
+	// File is the private key relative to the .ssh directory, the public key has the suffix .pub
+	File        string    `json:"file"`
+	Type        string    `json:"type"`
+	Fingerprint string    `json:"fingerprint"`
+	Comment     string    `json:"comment,omitempty"`
+	Created     time.Time `json:"created"`
+	// Retired is set when the key has been rotated, it is removed after Expires
+	Retired *time.Time `json:"retired,omitempty"`
+	Expires *time.Time `json:"expires,omitempty"`
+}
+
+// EnvMetadata is kept in .if0-env.json of an environment
+type EnvMetadata struct {
+	SSHKeys []SSHKeyInfo `json:"ssh_keys"`
+}
 
-	privateKey, err := generatePrivateKey()
+// sshKeyOptions returns the options of the SSH keys of new environments: IF0_SSH_KEY_TYPE and IF0_SSH_KEY_BITS
+// of if0.env, and IF0_SSH_KEY_PASSPHRASE of the process environment
+func sshKeyOptions(envPath string) (SSHKeyOptions, error) {
+	opts := SSHKeyOptions{Comment: "if0@" + envNameOf(envPath)}
+	store, _ := config.LoadStore(common.If0Default)
+	if store != nil {
+		opts.Type = store.Get(SSHKeyTypeKey)
+		if bits := store.Get(SSHKeyBitsKey); bits != "" {
+			n, err := strconv.Atoi(bits)
+			if err != nil {
+				return opts, fmt.Errorf("%s must be a number, got %q", SSHKeyBitsKey, bits)
+			}
+			opts.Bits = n
+		}
+	}
+	if passphrase := os.Getenv(SSHKeyPassphraseKey); passphrase != "" {
+		opts.Passphrase = []byte(passphrase)
+	}
+	return opts, nil
+}
+
+// sshKeyFile returns the OpenSSH file name of a key type, e.g. id_ed25519
+func sshKeyFile(keyType string) string {
+	return "id_" + keyType
+}
+
+// generateSSHKeyPair writes a new key pair to sshDir, the private key in OpenSSH format
+func generateSSHKeyPair(sshDir string, opts SSHKeyOptions) (*SSHKeyInfo, error) {
+	if opts.Type == "" {
+		opts.Type = KeyTypeED25519
+	}
+	privateKey, err := generatePrivateKey(opts)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	publicKey, err := ssh.NewPublicKey(privateKey.Public())
+	if err != nil {
+		return nil, err
+	}
+	privateKeyBytes, err := marshalOpenSSHPrivateKey(privateKey, opts.Comment, opts.Passphrase)
+	if err != nil {
+		return nil, err
+	}
+	publicKeyBytes := ssh.MarshalAuthorizedKey(publicKey)
+	if opts.Comment != "" {
+		publicKeyBytes = append(publicKeyBytes[:len(publicKeyBytes)-1], []byte(" "+opts.Comment+"\n")...)
+	}
+
+	file := sshKeyFile(opts.Type)
+	err = writeKeyToFile(privateKeyBytes, filepath.Join(sshDir, file), 0600)
+	if err != nil {
+		return nil, err
+	}
+	err = writeKeyToFile(publicKeyBytes, filepath.Join(sshDir, file+".pub"), 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &SSHKeyInfo{
+		File:        file,
+		Type:        opts.Type,
+		Fingerprint: ssh.FingerprintSHA256(publicKey),
+		Comment:     opts.Comment,
+		Created:     time.Now().UTC().Truncate(time.Second),
+	}, nil
+}
+
+func generatePrivateKey(opts SSHKeyOptions) (crypto.Signer, error) {
+	switch opts.Type {
+	case KeyTypeED25519:
+		if opts.Bits != 0 && opts.Bits != 256 {
+			return nil, fmt.Errorf("ed25519 keys have 256 bits, got %d", opts.Bits)
+		}
+		_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+		return privateKey, err
+	case KeyTypeECDSA:
+		var curve elliptic.Curve
+		switch opts.Bits {
+		case 0, 256:
+			curve = elliptic.P256()
+		case 384:
+			curve = elliptic.P384()
+		case 521:
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("ecdsa keys have 256, 384 or 521 bits, got %d", opts.Bits)
+		}
+		return ecdsa.GenerateKey(curve, rand.Reader)
+	case KeyTypeRSA:
+		bits := opts.Bits
+		if bits == 0 {
+			bits = 4096
+		}
+		if bits < 2048 {
+			return nil, fmt.Errorf("rsa keys need at least 2048 bits, got %d", bits)
+		}
+		privateKey, err := rsa.GenerateKey(rand.Reader, bits)
+		if err != nil {
+			return nil, err
+		}
+		return privateKey, privateKey.Validate()
+	}
+	return nil, fmt.Errorf("unknown SSH key type %q, expected %s, %s or %s", opts.Type, KeyTypeED25519, KeyTypeECDSA, KeyTypeRSA)
+}
+
+// marshalOpenSSHPrivateKey encodes a private key in the format of ssh-keygen.
+// with a passphrase, the key is encrypted with aes256-ctr and a key derived by bcrypt_pbkdf.
+func marshalOpenSSHPrivateKey(key crypto.Signer, comment string, passphrase []byte) ([]byte, error) {
+	var block *pem.Block
+	var err error
+	if len(passphrase) > 0 {
+		block, err = ssh.MarshalPrivateKeyWithPassphrase(key, comment, passphrase)
+	} else {
+		block, err = ssh.MarshalPrivateKey(key, comment)
+	}
+	if err != nil {
+		return nil, err
 	}
+	return pem.EncodeToMemory(block), nil
+}
 
-	publicKeyBytes, err := generatePublicKey(&privateKey.PublicKey)
+func writeKeyToFile(keyBytes []byte, file string, perm os.FileMode) error {
+	fmt.Printf("Creating ssh key %s\n", file)
+	err := ioutil.WriteFile(file, keyBytes, perm)
 	if err != nil {
 		return err
 	}
+	return nil
+}
 
-	privateKeyBytes := encodePrivateKeyToPEM(privateKey)
+// hasSSHKeyPair reports whether sshDir holds a private key id_* and its public key
+func hasSSHKeyPair(sshDir string) bool {
+	for _, key := range activeSSHKeys(sshDir) {
+		if _, err := os.Stat(filepath.Join(sshDir, key+".pub")); err == nil {
+			return true
+		}
+	}
+	return false
+}
 
-	err = writeKeyToFile(privateKeyBytes, privateKeyPath, 0600)
+// activeSSHKeys returns the private keys of sshDir which have not been retired by a rotation
+func activeSSHKeys(sshDir string) []string {
+	files, _ := ioutil.ReadDir(sshDir)
+	var keys []string
+	for _, f := range files {
+		if !f.IsDir() && strings.HasPrefix(f.Name(), "id_") && !strings.Contains(f.Name(), ".") {
+			keys = append(keys, f.Name())
+		}
+	}
+	return keys
+}
+
+// ReadEnvMetadata reads .if0-env.json of an environment, it is empty if there is none
+func ReadEnvMetadata(envDir string) (*EnvMetadata, error) {
+	meta := &EnvMetadata{}
+	data, err := ioutil.ReadFile(filepath.Join(envDir, envMetadataFile))
+	if os.IsNotExist(err) {
+		return meta, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, meta); err != nil {
+		return nil, errors.Wrapf(err, "reading %s", envMetadataFile)
+	}
+	return meta, nil
+}
+
+func writeEnvMetadata(envDir string, meta *EnvMetadata) error {
+	data, err := json.MarshalIndent(meta, "", "  ")
 	if err != nil {
 		return err
 	}
+	return ioutil.WriteFile(filepath.Join(envDir, envMetadataFile), append(data, '\n'), 0644)
+}
 
-	err = writeKeyToFile(publicKeyBytes, publicKeyPath, 0644)
+// recordSSHKey adds a key to the metadata of an environment
+func recordSSHKey(envDir string, key *SSHKeyInfo) error {
+	meta, err := ReadEnvMetadata(envDir)
 	if err != nil {
 		return err
 	}
-	return nil
+	meta.SSHKeys = append(meta.SSHKeys, *key)
+	return writeEnvMetadata(envDir, meta)
 }
 
-func generatePrivateKey() (*rsa.PrivateKey, error) {
-	privateKey, err := rsa.GenerateKey(rand.Reader, 4096)
+// RotateSSHKey replaces the SSH key pair of an environment, unset options default to those of new environments.
+// the previous pairs are renamed to
+// <file>.<timestamp> and kept for grace, retired pairs whose grace period has passed are removed.
+// fingerprints and dates of the keys are recorded in .if0-env.json.
+func RotateSSHKey(envDir string, opts SSHKeyOptions, grace time.Duration) (*SSHKeyInfo, error) {
+	sshDir := filepath.Join(envDir, ".ssh")
+	meta, err := ReadEnvMetadata(envDir)
 	if err != nil {
 		return nil, err
 	}
-	err = privateKey.Validate()
+	defaults, err := sshKeyOptions(envDir)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Type == "" {
+		opts.Type, opts.Bits = defaults.Type, defaults.Bits
+	}
+	if opts.Passphrase == nil {
+		opts.Passphrase = defaults.Passphrase
+	}
+	if opts.Comment == "" {
+		opts.Comment = defaults.Comment
+	}
+	err = os.MkdirAll(sshDir, 0700)
 	if err != nil {
 		return nil, err
 	}
-	return privateKey, nil
-}
 
-func generatePublicKey(privateKey *rsa.PublicKey) ([]byte, error) {
-	publicRsaKey, err := ssh.NewPublicKey(privateKey)
+	now := time.Now().UTC().Truncate(time.Second)
+	expires := now.Add(grace)
+	suffix := "." + now.Format("20060102T150405Z")
+	var retired []string
+	restore := func() {
+		for _, file := range retired {
+			_ = os.Rename(filepath.Join(sshDir, file+suffix), filepath.Join(sshDir, file))
+			_ = os.Rename(filepath.Join(sshDir, file+suffix+".pub"), filepath.Join(sshDir, file+".pub"))
+		}
+	}
+	for _, file := range activeSSHKeys(sshDir) {
+		info := findSSHKey(meta, file)
+		if info == nil {
+			// keys created before their metadata was recorded
+			info = untrackedSSHKey(sshDir, file)
+			meta.SSHKeys = append(meta.SSHKeys, *info)
+			info = &meta.SSHKeys[len(meta.SSHKeys)-1]
+		}
+		if _, err := os.Stat(filepath.Join(sshDir, file+suffix)); err == nil {
+			restore()
+			return nil, fmt.Errorf("%s has been rotated just now, try again in a second", file)
+		}
+		err = os.Rename(filepath.Join(sshDir, file), filepath.Join(sshDir, file+suffix))
+		if err == nil {
+			err = os.Rename(filepath.Join(sshDir, file+".pub"), filepath.Join(sshDir, file+suffix+".pub"))
+			if os.IsNotExist(err) {
+				err = nil
+			}
+		}
+		if err != nil {
+			restore()
+			return nil, err
+		}
+		retired = append(retired, file)
+		info.File = file + suffix
+		info.Retired = &now
+		info.Expires = &expires
+	}
+
+	key, err := generateSSHKeyPair(sshDir, opts)
 	if err != nil {
+		restore()
 		return nil, err
 	}
-	pubKeyBytes := ssh.MarshalAuthorizedKey(publicRsaKey)
-	return pubKeyBytes, nil
+	meta.SSHKeys = append(pruneSSHKeys(sshDir, meta.SSHKeys, now), *key)
+	return key, writeEnvMetadata(envDir, meta)
 }
 
-func encodePrivateKeyToPEM(privateKey *rsa.PrivateKey) []byte {
-	privateDER := x509.MarshalPKCS1PrivateKey(privateKey)
-	privateBlock := pem.Block{
-		Type:    "RSA PRIVATE KEY",
-		Headers: nil,
-		Bytes:   privateDER,
+// findSSHKey returns the active key recorded for file
+func findSSHKey(meta *EnvMetadata, file string) *SSHKeyInfo {
+	for i := range meta.SSHKeys {
+		if meta.SSHKeys[i].File == file && meta.SSHKeys[i].Retired == nil {
+			return &meta.SSHKeys[i]
+		}
 	}
-	privatePEM := pem.EncodeToMemory(&privateBlock)
-	return privatePEM
+	return nil
 }
 
-func writeKeyToFile(keyBytes []byte, file string, perm os.FileMode) error {
-	fmt.Printf("Creating ssh key %s\n", file)
-	err := ioutil.WriteFile(file, keyBytes, perm)
-	if err != nil {
-		return err
+// untrackedSSHKey describes a key pair without metadata using its public key and modification time
+func untrackedSSHKey(sshDir, file string) *SSHKeyInfo {
+	info := &SSHKeyInfo{File: file, Type: strings.TrimPrefix(file, "id_")}
+	if stat, err := os.Stat(filepath.Join(sshDir, file)); err == nil {
+		info.Created = stat.ModTime().UTC().Truncate(time.Second)
 	}
-	return nil
+	if data, err := ioutil.ReadFile(filepath.Join(sshDir, file+".pub")); err == nil {
+		if publicKey, comment, _, _, err := ssh.ParseAuthorizedKey(data); err == nil {
+			info.Fingerprint = ssh.FingerprintSHA256(publicKey)
+			info.Comment = comment
+		}
+	}
+	return info
+}
+
+// pruneSSHKeys removes the retired keys expired at now, the remaining keys are returned
+func pruneSSHKeys(sshDir string, keys []SSHKeyInfo, now time.Time) []SSHKeyInfo {
+	var kept []SSHKeyInfo
+	for _, key := range keys {
+		if key.Expires != nil && !key.Expires.After(now) {
+			fmt.Println("Removing retired ssh key", filepath.Join(sshDir, key.File))
+			_ = os.Remove(filepath.Join(sshDir, key.File))
+			_ = os.Remove(filepath.Join(sshDir, key.File+".pub"))
+			continue
+		}
+		kept = append(kept, key)
+	}
+	return kept
 }
@@ -0,0 +1,330 @@
+package environments
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+	"if0/common"
+	"if0/common/sync"
+	"if0/config"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"text/template"
+)
+
+// DefaultTemplate is the built-in template: the logo and a .gitlab-ci.yml including shipmate
+const DefaultTemplate = "default"
+
+// templateManifest declares the inputs and hooks of a template directory, it is not copied
+const templateManifest = "template.yaml"
+
+// templateExt marks the files whose content is rendered, the extension is removed
+const templateExt = ".tmpl"
+
+// Template scaffolds new environments. the files of Dir are copied to the environment, their names
+// are rendered with text/template, as are the contents of *.tmpl files.
+// zero.env, dash1.env and the SSH keys are generated as without a template, unless the template provides them;
+// keys a template's zero.env lacks are added to it.
+type Template struct {
+	Name        string
+	Description string          `yaml:"description"`
+	Inputs      []TemplateInput `yaml:"inputs"`
+	Hooks       TemplateHooks   `yaml:"hooks"`
+	// Dir is empty for the built-in default template
+	Dir string `yaml:"-"`
+	// trusted templates run their hooks without confirmation: the default template and those in TemplatesDir
+	trusted bool
+	// cleanup removes a template cloned from a git repository
+	cleanup func()
+}
+
+// TemplateInput is a value asked for when an environment is created from a template
+type TemplateInput struct {
+	Name        string `yaml:"name"`
+	Description string `yaml:"description"`
+	Default     string `yaml:"default"`
+	Required    bool   `yaml:"required"`
+	// Pattern is a regular expression the value has to match
+	Pattern string `yaml:"pattern"`
+}
+
+// TemplateHooks are shell commands run in the new environment
+type TemplateHooks struct {
+	// PostCreate runs after the files, keys and secrets of the environment have been created
+	PostCreate []string `yaml:"post_create"`
+}
+
+// HooksAnswer is the answer key confirming the post-create hooks of a template from outside TemplatesDir
+const HooksAnswer = "run-hooks"
+
+// TemplateData is passed to the templates: {{ .Name }}, {{ .Inputs.customer }}
+type TemplateData struct {
+	// Name of the environment, e.g. gitlab.com/group/env-1
+	Name string
+	// Base is the last element of Name
+	Base   string
+	Inputs map[string]string
+}
+
+var templateFuncs = template.FuncMap{
+	"lower":   strings.ToLower,
+	"upper":   strings.ToUpper,
+	"replace": strings.ReplaceAll,
+	"default": func(def, val string) string {
+		if val == "" {
+			return def
+		}
+		return val
+	},
+}
+
+// TemplatesDir returns the directory of the named templates of the profile
+func TemplatesDir() string {
+	return filepath.Join(common.If0Dir, "templates")
+}
+
+// LoadTemplate returns the template source refers to: the built-in default template, a directory,
+// a git repository or the name of a template below TemplatesDir. git repositories are cloned to a
+// temporary directory, Close removes it.
+func LoadTemplate(source string) (*Template, error) {
+	if source == "" || source == DefaultTemplate {
+		return &Template{Name: DefaultTemplate, Description: "logo and .gitlab-ci.yml including shipmate", trusted: true}, nil
+	}
+	if isGitURL(source) {
+		dir, err := ioutil.TempDir("", "if0-template")
+		if err != nil {
+			return nil, err
+		}
+		authObj := sync.Auth{Lookup: config.GetEnvVariable}
+		auth, err := getAuth(&authObj, source)
+		if err == nil {
+			_, err = clone(source, dir, auth)
+		}
+		if err != nil {
+			_ = os.RemoveAll(dir)
+			return nil, errors.Wrapf(err, "cloning template %s", source)
+		}
+		t, err := loadTemplateDir(source, dir)
+		if err != nil {
+			_ = os.RemoveAll(dir)
+			return nil, err
+		}
+		t.cleanup = func() { _ = os.RemoveAll(dir) }
+		return t, nil
+	}
+	if info, err := os.Stat(source); err == nil && info.IsDir() {
+		t, err := loadTemplateDir(filepath.Base(source), source)
+		if err != nil {
+			return nil, err
+		}
+		abs, err := filepath.Abs(source)
+		t.trusted = err == nil && filepath.Dir(abs) == filepath.Clean(TemplatesDir())
+		return t, nil
+	}
+	dir := filepath.Join(TemplatesDir(), source)
+	if info, err := os.Stat(dir); err == nil && info.IsDir() && filepath.Base(dir) == source {
+		t, err := loadTemplateDir(source, dir)
+		if err != nil {
+			return nil, err
+		}
+		t.trusted = true
+		return t, nil
+	}
+	return nil, fmt.Errorf("template %s not found, it is neither a directory, a git repository nor a template in %s",
+		source, TemplatesDir())
+}
+
+func loadTemplateDir(name, dir string) (*Template, error) {
+	t := &Template{}
+	data, err := ioutil.ReadFile(filepath.Join(dir, templateManifest))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		if err := yaml.UnmarshalStrict(data, t); err != nil {
+			return nil, fmt.Errorf("reading %s of template %s: %s", templateManifest, name, err)
+		}
+	}
+	for _, input := range t.Inputs {
+		if input.Name == "" {
+			return nil, fmt.Errorf("template %s has an input without name", name)
+		}
+		if _, err := regexp.Compile(input.Pattern); err != nil {
+			return nil, fmt.Errorf("invalid pattern of input %s of template %s: %s", input.Name, name, err)
+		}
+	}
+	t.Name = name
+	t.Dir = dir
+	return t, nil
+}
+
+func isGitURL(source string) bool {
+	for _, prefix := range []string{"https://", "http://", "ssh://", "file://", "git@"} {
+		if strings.HasPrefix(source, prefix) {
+			return true
+		}
+	}
+	return strings.HasSuffix(source, ".git")
+}
+
+// Close removes the clone of a template from a git repository
+func (t *Template) Close() {
+	if t != nil && t.cleanup != nil {
+		t.cleanup()
+	}
+}
+
+// confirmHooks asks whether the post-create hooks of a template from outside TemplatesDir may run,
+// they are shell commands of whoever wrote the template. confirmed hooks are not asked for again.
+func (t *Template) confirmHooks(p Prompter) error {
+	if t.trusted || len(t.Hooks.PostCreate) == 0 {
+		return nil
+	}
+	text := fmt.Sprintf("Template %s runs these post-create hooks in the new environment:\n  %s\nRun them? [y/N]: ",
+		t.Name, strings.Join(t.Hooks.PostCreate, "\n  "))
+	answer, err := p.Ask(Question{Key: HooksAnswer, Text: text})
+	if _, ok := err.(*MissingAnswersError); ok {
+		return fmt.Errorf("template %s runs post-create hooks, review them in its %s and confirm them with --yes",
+			t.Name, templateManifest)
+	}
+	if err != nil {
+		return err
+	}
+	answer = strings.ToLower(answer)
+	if answer != "y" && answer != "yes" {
+		return fmt.Errorf("the post-create hooks of template %s were not confirmed", t.Name)
+	}
+	t.trusted = true
+	return nil
+}
+
+// askInputs asks for the inputs of the template, answers are keyed by the input name
+func (t *Template) askInputs(p Prompter) (map[string]string, error) {
+	inputs := make(map[string]string)
+	a := &questionAsker{p: p}
+	for _, input := range t.Inputs {
+		input := input
+		q := Question{Key: input.Name, Default: input.Default, Optional: !input.Required}
+		text := input.Description
+		if text == "" {
+			text = input.Name
+		}
+		if input.Default != "" {
+			text += " [" + input.Default + "]"
+		}
+		q.Text = text + ": "
+		q.Check = func(answer string) error {
+			if answer == "" {
+				if input.Required {
+					return fmt.Errorf("%s is required", input.Name)
+				}
+				return nil
+			}
+			if input.Pattern != "" && !regexp.MustCompile(input.Pattern).MatchString(answer) {
+				return fmt.Errorf("%s must match %s, got %q", input.Name, input.Pattern, answer)
+			}
+			return nil
+		}
+		inputs[input.Name] = a.ask(q)
+	}
+	return inputs, a.done()
+}
+
+// render writes the files of the template to envPath
+func (t *Template) render(envPath string, data *TemplateData) error {
+	if t.Dir == "" {
+		// the logo is not needed to deploy, it is left out until it is built in or cached
+		err := writeAsset(LogoAsset, filepath.Join(envPath, "logo.png"))
+		if err != nil {
+			fmt.Println("Skipping logo.png -", err)
+		}
+		return createCIFile(envPath)
+	}
+	return filepath.Walk(t.Dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(t.Dir, p)
+		if err != nil || rel == "." {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if info.IsDir() && info.Name() == ".git" {
+			return filepath.SkipDir
+		}
+		if rel == templateManifest || (!info.IsDir() && !info.Mode().IsRegular()) {
+			return nil
+		}
+		name, err := renderString(rel, rel, data)
+		if err != nil {
+			return err
+		}
+		if clean := path.Clean(name); clean == ".." || strings.HasPrefix(clean, "../") || path.IsAbs(name) {
+			return fmt.Errorf("template file %s renders to %s, outside of the environment", rel, name)
+		}
+		target := filepath.Join(envPath, filepath.FromSlash(strings.TrimSuffix(name, templateExt)))
+		if info.IsDir() {
+			return os.MkdirAll(target, info.Mode().Perm())
+		}
+		content, err := ioutil.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		if strings.HasSuffix(name, templateExt) {
+			rendered, err := renderString(rel, string(content), data)
+			if err != nil {
+				return err
+			}
+			content = []byte(rendered)
+		}
+		fmt.Println("Creating file", target)
+		return ioutil.WriteFile(target, content, info.Mode().Perm())
+	})
+}
+
+func renderString(name, text string, data *TemplateData) (string, error) {
+	tmpl, err := template.New(name).Funcs(templateFuncs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", err
+	}
+	var out bytes.Buffer
+	if err := tmpl.Execute(&out, data); err != nil {
+		return "", err
+	}
+	return out.String(), nil
+}
+
+// runHooks runs the post-create hooks in envPath. the hooks get the name and directory of the
+// environment in IF0_ENVIRONMENT and IF0_ENV_DIR, and the inputs in IF0_INPUT_<NAME>.
+func (t *Template) runHooks(envPath string, data *TemplateData) error {
+	if !t.trusted && len(t.Hooks.PostCreate) > 0 {
+		return fmt.Errorf("the post-create hooks of template %s were not confirmed", t.Name)
+	}
+	env := append(os.Environ(), "IF0_ENVIRONMENT="+data.Name, "IF0_ENV_DIR="+envPath)
+	for name, val := range data.Inputs {
+		key := strings.ToUpper(regexp.MustCompile(`[^a-zA-Z0-9]`).ReplaceAllString(name, "_"))
+		env = append(env, "IF0_INPUT_"+key+"="+val)
+	}
+	for _, hook := range t.Hooks.PostCreate {
+		fmt.Println("Running post-create hook:", hook)
+		cmd := exec.Command("sh", "-c", hook)
+		if runtime.GOOS == "windows" {
+			cmd = exec.Command("cmd", "/C", hook)
+		}
+		cmd.Dir = envPath
+		cmd.Env = env
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			return errors.Wrapf(err, "post-create hook %q", hook)
+		}
+	}
+	return nil
+}
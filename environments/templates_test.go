@@ -0,0 +1,136 @@
+package environments
+
+import (
+	"github.com/stretchr/testify/assert"
+	"if0/common"
+	"if0/config"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeTestTemplate(t *testing.T, dir string) {
+	files := map[string]string{
+		"template.yaml": `description: test layout
+inputs:
+  - name: customer
+    description: Customer name
+    required: true
+    pattern: ^[a-z]+$
+  - name: size
+    default: small
+hooks:
+  post_create:
+    - echo "$IF0_INPUT_CUSTOMER $IF0_ENVIRONMENT" > hook.txt
+`,
+		"zero.env.tmpl":                         "ZERO_ADMIN_USER=ops\nZERO_BASE_DOMAIN={{ .Inputs.customer }}.example.com\n",
+		"dash1.env":                             "DASH1_MODULE=libvirt\n",
+		"{{ .Inputs.customer }}/README.md.tmpl": "# {{ .Base | upper }} ({{ .Inputs.size }})\n",
+		"static/{{ .Inputs.size }}.txt":         "{{ not rendered }}\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		assert.Nil(t, os.MkdirAll(filepath.Dir(path), 0755))
+		assert.Nil(t, ioutil.WriteFile(path, []byte(content), 0644))
+	}
+}
+
+func TestEnvInitTemplate(t *testing.T) {
+	defer useTempIf0Dir(t)()
+	writeTestTemplate(t, filepath.Join(TemplatesDir(), "customer-k3s"))
+	tmpl, err := LoadTemplate("customer-k3s")
+	assert.Nil(t, err)
+	assert.Equal(t, "test layout", tmpl.Description)
+
+	envPath := filepath.Join(common.EnvDir, "gitlab.com", "acme", "shop")
+	_ = os.MkdirAll(envPath, 0755)
+	err = envInit(envPath, NewAnswersPrompter(map[string]string{"customer": "acme"}, nil), tmpl)
+	assert.Nil(t, err)
+
+	readme, _ := ioutil.ReadFile(filepath.Join(envPath, "acme", "README.md"))
+	assert.Equal(t, "# SHOP (small)\n", string(readme))
+	static, _ := ioutil.ReadFile(filepath.Join(envPath, "static", "small.txt"))
+	assert.Equal(t, "{{ not rendered }}\n", string(static))
+	hook, _ := ioutil.ReadFile(filepath.Join(envPath, "hook.txt"))
+	assert.Equal(t, "acme gitlab.com/acme/shop\n", string(hook))
+	assert.NoFileExists(t, filepath.Join(envPath, "template.yaml"))
+	assert.NoFileExists(t, filepath.Join(envPath, ".gitlab-ci.yml"), "the files of the default template are not created")
+	assert.FileExists(t, filepath.Join(envPath, ".ssh", "id_ed25519"))
+
+	// the keys of the template take precedence, the generated keys are added
+	doc, err := config.ReadDocument(filepath.Join(envPath, "zero.env"))
+	assert.Nil(t, err)
+	user, _ := doc.Get("ZERO_ADMIN_USER")
+	assert.Equal(t, "ops", user)
+	domain, _ := doc.Get("ZERO_BASE_DOMAIN")
+	assert.Equal(t, "acme.example.com", domain)
+	name, _ := doc.Get("IF0_ENVIRONMENT")
+	assert.Equal(t, "gitlab.com/acme/shop", name)
+	assert.True(t, doc.Has("ZERO_ADMIN_PASSWORD_HASH"))
+	module, _ := config.GetConfigValue(filepath.Join(envPath, "dash1.env"), "DASH1_MODULE")
+	assert.Equal(t, "libvirt", module)
+}
+
+func TestTemplateInputs(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "if0-template")
+	defer os.RemoveAll(dir)
+	writeTestTemplate(t, dir)
+	tmpl, err := LoadTemplate(dir)
+	assert.Nil(t, err)
+
+	_, err = tmpl.askInputs(NewAnswersPrompter(nil, nil))
+	assert.EqualError(t, err, "missing answers for customer, provide them with flags or an answers file")
+	_, err = tmpl.askInputs(NewAnswersPrompter(map[string]string{"customer": "ACME"}, nil))
+	assert.EqualError(t, err, `invalid answer for customer: customer must match ^[a-z]+$, got "ACME"`)
+	inputs, err := tmpl.askInputs(NewAnswersPrompter(map[string]string{"customer": "acme"}, nil))
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"customer": "acme", "size": "small"}, inputs)
+}
+
+func TestLoadTemplate(t *testing.T) {
+	defer useTempIf0Dir(t)()
+	tmpl, err := LoadTemplate("")
+	assert.Nil(t, err)
+	assert.Equal(t, DefaultTemplate, tmpl.Name)
+	assert.Equal(t, "", tmpl.Dir)
+
+	_, err = LoadTemplate("missing")
+	assert.Error(t, err)
+
+	dir := filepath.Join(TemplatesDir(), "broken")
+	_ = os.MkdirAll(dir, 0755)
+	_ = ioutil.WriteFile(filepath.Join(dir, "template.yaml"), []byte("inputs:\n  - description: no name\n"), 0644)
+	_, err = LoadTemplate("broken")
+	assert.EqualError(t, err, "template broken has an input without name")
+
+	_ = ioutil.WriteFile(filepath.Join(dir, "template.yaml"), []byte("hooks:\n  pre_create: [ls]\n"), 0644)
+	_, err = LoadTemplate("broken")
+	assert.Error(t, err, "unknown fields are rejected")
+}
+
+func TestTemplateHooksConfirmation(t *testing.T) {
+	defer useTempIf0Dir(t)()
+	dir, _ := ioutil.TempDir("", "if0-template")
+	defer os.RemoveAll(dir)
+	writeTestTemplate(t, dir)
+	envPath := filepath.Join(common.EnvDir, "gitlab.com", "acme", "shop")
+
+	for _, answers := range []map[string]string{{}, {HooksAnswer: "n"}} {
+		tmpl, err := LoadTemplate(dir)
+		assert.Nil(t, err)
+		_ = os.MkdirAll(envPath, 0755)
+		answers["customer"] = "acme"
+		err = envInit(envPath, NewAnswersPrompter(answers, nil), tmpl)
+		assert.Error(t, err)
+		assert.NoFileExists(t, filepath.Join(envPath, "zero.env"), "nothing is created before the confirmation")
+		_ = os.RemoveAll(envPath)
+	}
+
+	tmpl, _ := LoadTemplate(dir)
+	assert.EqualError(t, tmpl.runHooks(envPath, &TemplateData{}), "the post-create hooks of template "+filepath.Base(dir)+" were not confirmed")
+	_ = os.MkdirAll(envPath, 0755)
+	err := envInit(envPath, NewAnswersPrompter(map[string]string{"customer": "acme", HooksAnswer: "y"}, nil), tmpl)
+	assert.Nil(t, err)
+	assert.FileExists(t, filepath.Join(envPath, "hook.txt"))
+}
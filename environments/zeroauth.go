@@ -1,95 +1,162 @@
 package environments
 
 import (
-	"bytes"
-	"context"
+	"crypto/rand"
 	"fmt"
-	"github.com/docker/docker/api/types"
-	"github.com/docker/docker/api/types/container"
-	"github.com/docker/docker/client"
-	"io"
-	"math/rand"
-	"os/exec"
-	"runtime"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/bcrypt"
+	"if0/common"
+	"if0/config"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
-	"time"
 )
 
-func init() {
-	rand.Seed(time.Now().UnixNano())
-}
+// Keys of if0.env configuring the generated admin passwords
+const (
+	PasswordLengthKey   = "IF0_ADMIN_PASSWORD_LENGTH"
+	PasswordAlphabetKey = "IF0_ADMIN_PASSWORD_ALPHABET"
+)
 
-var letters = []rune("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ")
+// Defaults of the generated admin passwords
+const (
+	DefaultPasswordLength   = 24
+	DefaultPasswordAlphabet = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+	minPasswordLength       = 8
+)
 
-func generateRandSeq() string {
-	b := make([]rune, 10)
-	for i := range b {
-		b[i] = letters[rand.Intn(len(letters))]
-	}
-	return string(b)
+// PasswordPolicy describes the generated admin passwords
+type PasswordPolicy struct {
+	Length   int
+	Alphabet string
 }
 
-func generateHashCmd(seq string) (string, error) {
-	// htpasswd -nbB admin 'superpassword' | cut -d ":" -f 2
-	if runtime.GOOS != "windows" {
-		command := "htpasswd -nbB admin " + "'"+seq+"'" + "| cut -d \":\" -f 2"
-		cmd := exec.Command("bash", "-c", command)
-		out, err := cmd.CombinedOutput()
+// passwordPolicy returns the policy configured in if0.env
+func passwordPolicy() (PasswordPolicy, error) {
+	policy := PasswordPolicy{Length: DefaultPasswordLength, Alphabet: DefaultPasswordAlphabet}
+	store, _ := config.LoadStore(common.If0Default)
+	if store == nil {
+		return policy, nil
+	}
+	if length := store.Get(PasswordLengthKey); length != "" {
+		n, err := strconv.Atoi(length)
 		if err != nil {
-			fmt.Println("Error: htpasswd - ", err, string(out))
-			return "", err
+			return policy, fmt.Errorf("%s must be a number, got %q", PasswordLengthKey, length)
 		}
-		hash := string(out)
-		hash = strings.Replace(hash, "$", "$$", -1)
-		return hash, nil
+		policy.Length = n
+	}
+	if alphabet := store.Get(PasswordAlphabetKey); alphabet != "" {
+		policy.Alphabet = alphabet
 	}
-	return "", nil
+	return policy, policy.validate()
 }
 
-func generateHashDocker(seq string) (string, error) {
-	ctx := context.Background()
-	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
-	if err != nil {
-		panic(err)
-	}
-	command := "htpasswd -nbB admin " + "'"+seq+"'" + "| cut -d \":\" -f 2"
-	resp, err := cli.ContainerCreate(ctx, &container.Config{
-		Image: "httpd:2.4-alpine",
-		Cmd:   []string{"bash", "-c", command},
-		Tty:   true,
-		AttachStdin: true,
-		AttachStdout: true,
-		AttachStderr: true,
-	}, nil, nil, "htpwd")
-	if err != nil {
-		return "", err
+// validate rejects policies generating weak passwords or passwords that need quoting in *.env files
+func (p PasswordPolicy) validate() error {
+	if p.Length < minPasswordLength {
+		return fmt.Errorf("password length must be at least %d, got %d", minPasswordLength, p.Length)
 	}
+	seen := make(map[rune]bool)
+	for _, r := range p.Alphabet {
+		if r <= ' ' || r > '~' || strings.ContainsRune("$#'\"\\`", r) {
+			return fmt.Errorf("password alphabet may only contain printable ASCII characters except $ # ' \" \\ `, got %q", r)
+		}
+		seen[r] = true
+	}
+	if len(seen) < 2 {
+		return fmt.Errorf("password alphabet needs at least 2 different characters, got %q", p.Alphabet)
+	}
+	return nil
+}
 
-	if err := cli.ContainerStart(ctx, resp.ID, types.ContainerStartOptions{}); err != nil {
+// Generate returns a random password drawn uniformly from the alphabet using crypto/rand
+func (p PasswordPolicy) Generate() (string, error) {
+	if err := p.validate(); err != nil {
 		return "", err
 	}
-
-	statusCh, errCh := cli.ContainerWait(ctx, resp.ID, container.WaitConditionNotRunning)
-	select {
-	case err := <-errCh:
+	alphabet := []rune(p.Alphabet)
+	max := big.NewInt(int64(len(alphabet)))
+	b := make([]rune, p.Length)
+	for i := range b {
+		n, err := rand.Int(rand.Reader, max)
 		if err != nil {
 			return "", err
 		}
-	case <-statusCh:
+		b[i] = alphabet[n.Int64()]
 	}
+	return string(b), nil
+}
 
-	out, err := cli.ContainerLogs(ctx, resp.ID, types.ContainerLogsOptions{ShowStdout: true})
+// hashPassword returns the bcrypt hash of an admin password as in htpasswd files.
+// `$` is escaped as `$$`, the hash is passed to traefik through docker-compose.
+func hashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 	if err != nil {
 		return "", err
 	}
-	buf := new(bytes.Buffer)
-	io.Copy(buf, out)
-	hash := buf.String()
-	hash = strings.Replace(hash, "$", "$$", -1)
+	return strings.Replace(string(hash), "$", "$$", -1), nil
+}
 
-	err = cli.ContainerRemove(ctx, resp.ID, types.ContainerRemoveOptions{})
+// generateAdminCredentials returns a new admin password and its hash
+func generateAdminCredentials() (string, string, error) {
+	policy, err := passwordPolicy()
 	if err != nil {
-		return "", err
+		return "", "", err
+	}
+	password, err := policy.Generate()
+	if err != nil {
+		return "", "", err
 	}
-	return hash, nil
-}
\ No newline at end of file
+	hash, err := hashPassword(password)
+	if err != nil {
+		return "", "", err
+	}
+	return password, hash, nil
+}
+
+// RotateAdmin replaces ZERO_ADMIN_PASSWORD and ZERO_ADMIN_PASSWORD_HASH in the zero.env of an environment.
+// zero.env is snapshotted first and the new password is written encrypted like the other secrets.
+// returns the snapshot and the new password.
+func RotateAdmin(envDir string) (*config.Snapshot, string, error) {
+	password, hash, err := generateAdminCredentials()
+	if err != nil {
+		return nil, "", errors.Wrap(err, "generating admin password")
+	}
+	unlock, err := config.LockConfig()
+	if err != nil {
+		return nil, "", err
+	}
+	defer unlock()
+	zeroFile := filepath.Join(envDir, "zero.env")
+	info, err := os.Stat(zeroFile)
+	if err != nil {
+		return nil, "", err
+	}
+	doc, err := config.ReadDocument(zeroFile)
+	if err != nil {
+		return nil, "", err
+	}
+	// the password is never written in plaintext
+	recipients, err := config.EnvironmentRecipients(envDir)
+	if err != nil {
+		return nil, "", err
+	}
+	encrypted, err := config.EncryptValue(password, "zero.env", "ZERO_ADMIN_PASSWORD", recipients)
+	if err != nil {
+		return nil, "", errors.Wrap(err, "encrypting ZERO_ADMIN_PASSWORD")
+	}
+	snapshot, err := config.TakeSnapshot(zeroFile)
+	if err != nil {
+		return nil, "", errors.Wrap(err, "snapshotting zero.env")
+	}
+	doc.SetDefault("ZERO_ADMIN_USER", "admin")
+	doc.Set("ZERO_ADMIN_PASSWORD", encrypted)
+	doc.Set("ZERO_ADMIN_PASSWORD_HASH", hash)
+	err = doc.WriteFile(zeroFile, info.Mode().Perm())
+	if err != nil {
+		return snapshot, "", err
+	}
+	return snapshot, password, nil
+}
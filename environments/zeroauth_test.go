@@ -0,0 +1,93 @@
+package environments
+
+import (
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+	"if0/common"
+	"if0/config"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPasswordPolicy(t *testing.T) {
+	password, err := PasswordPolicy{Length: 12, Alphabet: "ab"}.Generate()
+	assert.Nil(t, err)
+	assert.Len(t, password, 12)
+	assert.Empty(t, strings.Trim(password, "ab"))
+
+	for _, p := range []PasswordPolicy{
+		{Length: 4, Alphabet: DefaultPasswordAlphabet},
+		{Length: 12, Alphabet: "aaaa"},
+		{Length: 12, Alphabet: "ab$"},
+		{Length: 12, Alphabet: "ab c"},
+	} {
+		_, err := p.Generate()
+		assert.NotNil(t, err, "%+v", p)
+	}
+}
+
+func TestHashPassword(t *testing.T) {
+	hash, err := hashPassword("secret")
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(hash, "$$2a$$"), hash)
+	unescaped := strings.Replace(hash, "$$", "$", -1)
+	assert.Nil(t, bcrypt.CompareHashAndPassword([]byte(unescaped), []byte("secret")))
+}
+
+func TestRotateAdmin(t *testing.T) {
+	defer useTempIf0Dir(t)()
+	assert.Nil(t, ioutil.WriteFile(common.If0Default, []byte(PasswordLengthKey+"=16\n"), 0644))
+	envDir := filepath.Join(common.EnvDir, "rotate")
+	_ = os.MkdirAll(envDir, 0755)
+	zeroFile := filepath.Join(envDir, "zero.env")
+	old := "IF0_ENVIRONMENT=rotate\nZERO_ADMIN_USER=ops\nZERO_ADMIN_PASSWORD=old\nZERO_ADMIN_PASSWORD_HASH=$$2y$$05$$abc\n"
+	assert.Nil(t, ioutil.WriteFile(zeroFile, []byte(old), 0600))
+
+	snapshot, password, err := RotateAdmin(envDir)
+	assert.Nil(t, err)
+	assert.Len(t, password, 16)
+	saved, _ := ioutil.ReadFile(snapshot.Path())
+	assert.Equal(t, old, string(saved))
+
+	doc, err := config.ReadDocument(zeroFile)
+	assert.Nil(t, err)
+	user, _ := doc.Get("ZERO_ADMIN_USER")
+	assert.Equal(t, "ops", user)
+	encrypted, _ := doc.Get("ZERO_ADMIN_PASSWORD")
+	assert.True(t, config.IsEncrypted(encrypted))
+	hash, _ := doc.Get("ZERO_ADMIN_PASSWORD_HASH")
+	unescaped := strings.Replace(hash, "$$", "$", -1)
+	assert.Nil(t, bcrypt.CompareHashAndPassword([]byte(unescaped), []byte(password)))
+	info, _ := os.Stat(zeroFile)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+}
+
+func TestRotateAdminUnreadableIdentity(t *testing.T) {
+	defer useTempIf0Dir(t)()
+	envDir := filepath.Join(common.EnvDir, "rotate")
+	_ = os.MkdirAll(envDir, 0755)
+	zeroFile := filepath.Join(envDir, "zero.env")
+	old := "IF0_ENVIRONMENT=rotate\nZERO_ADMIN_PASSWORD=ENC[if0.v2,abc]\n"
+	assert.Nil(t, ioutil.WriteFile(zeroFile, []byte(old), 0600))
+	_ = os.MkdirAll(filepath.Dir(config.IdentityFile()), 0700)
+	_ = ioutil.WriteFile(config.IdentityFile(), []byte("not an identity\n"), 0600)
+
+	// the new password can not be encrypted, zero.env is left as it was
+	_, _, err := RotateAdmin(envDir)
+	assert.Error(t, err)
+	saved, _ := ioutil.ReadFile(zeroFile)
+	assert.Equal(t, old, string(saved))
+}
+
+func TestEnvInitInvalidPasswordPolicy(t *testing.T) {
+	defer useTempIf0Dir(t)()
+	assert.Nil(t, ioutil.WriteFile(common.If0Default, []byte(PasswordLengthKey+"=4\n"), 0644))
+	envPath := filepath.Join(common.EnvDir, "weak")
+	_ = os.MkdirAll(envPath, 0755)
+	err := envInit(envPath, NewAnswersPrompter(map[string]string{"provider": "none", "nodes": "10.0.0.1"}, nil), nil)
+	assert.EqualError(t, err, "password length must be at least 8, got 4")
+	assert.NoFileExists(t, filepath.Join(envPath, "zero.env"))
+}